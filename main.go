@@ -10,10 +10,15 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/stolostron/search-indexer/pkg/clusterhealth"
+	"github.com/stolostron/search-indexer/pkg/clusterquota"
 	"github.com/stolostron/search-indexer/pkg/clustersync"
 	"github.com/stolostron/search-indexer/pkg/config"
 	"github.com/stolostron/search-indexer/pkg/database"
+	"github.com/stolostron/search-indexer/pkg/logging"
+	"github.com/stolostron/search-indexer/pkg/metrics"
 	"github.com/stolostron/search-indexer/pkg/server"
+	"github.com/stolostron/search-indexer/pkg/tracing"
 	"k8s.io/klog/v2"
 )
 
@@ -21,6 +26,7 @@ func main() {
 	// Initialize the logger.
 	klog.InitFlags(nil)
 	flag.Parse()
+	logging.Setup()
 	defer klog.Flush()
 	klog.Info("Starting search-indexer.")
 
@@ -33,14 +39,33 @@ func main() {
 		klog.Fatal(configError)
 	}
 
+	// Load the redaction policy before any resource is indexed.
+	if err := database.LoadRedactionPolicy(); err != nil {
+		klog.Fatal("Error loading the redaction policy. ", err)
+	}
+
 	ctx, exitRoutines := context.WithCancel(context.Background())
 
+	// Start tracing. Traces are exported only when OTEL_EXPORTER_OTLP_ENDPOINT is set.
+	stopTracing := tracing.Start(ctx)
+
 	// Get TLS configuration from operator-provided env vars (or defaults).
 	tlsCfg := config.GetTLSConfig()
 
 	// Initialize the database
 	dao := database.NewDAO(nil)
 	dao.InitializeTables(ctx)
+	dao.LoadPropertyTypes(ctx)
+	go dao.StartInterClusterEdges(ctx)
+
+	// Report indexing problems to the ManagedCluster and search-collector addon.
+	clusterhealth.Start(ctx, config.Cfg.KubeClient, config.GetDynamicClient())
+
+	// Delete the metrics of the deleted clusters.
+	metrics.StartClusterWatch(ctx, config.GetDynamicClient())
+
+	// Watch the quota overrides of the clusters.
+	clusterquota.Start(ctx, config.GetDynamicClient())
 
 	// Start cluster sync.
 	go clustersync.ElectLeaderAndStart(ctx)
@@ -63,5 +88,8 @@ func main() {
 	// We could use a waitgroup to wait for leader election and server to shutdown
 	// but it add more complexity so keeping simple for now.
 	time.Sleep(5 * time.Second)
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	stopTracing(stopCtx)
+	stopCancel()
 	klog.Warning("Exiting search-indexer.")
 }
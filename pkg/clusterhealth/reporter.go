@@ -0,0 +1,217 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clusterhealth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Condition added to the search-collector ManagedClusterAddOn.
+const ConditionSearchIndexed = "SearchIndexed"
+
+// Reasons for the SearchIndexed condition and the ManagedCluster events.
+const (
+	ReasonIndexed           = "Indexed"           // The last request from the cluster was indexed.
+	ReasonSyncRejected      = "SyncRejected"      // A sync request was rejected by the request limiters.
+	ReasonResyncRejected    = "ResyncRejected"    // A resync request was rejected by the request limiters.
+	ReasonResourcesRejected = "ResourcesRejected" // Some resources or edges in the request weren't indexed.
+	ReasonInvalidRequest    = "InvalidRequest"    // The request couldn't be read or decoded.
+	ReasonDatabaseError     = "DatabaseError"     // The database returned an error processing the request.
+)
+
+const searchCollectorAddon = "search-collector"
+const queueSize = 100
+
+var managedClusterAddonGvr = schema.GroupVersionResource{
+	Group:    "addon.open-cluster-management.io",
+	Version:  "v1alpha1",
+	Resource: "managedclusteraddons",
+}
+
+// A status update waiting to be sent to the API server.
+type statusUpdate struct {
+	clusterName string
+	reason      string
+	message     string
+	indexed     bool
+}
+
+// Reports the indexing health of each cluster with Events on the ManagedCluster and the SearchIndexed condition
+// on the search-collector ManagedClusterAddOn. Problems are reported at most once per interval for each cluster and
+// reason, so a noisy cluster doesn't flood the API server. Updates are sent from a single goroutine and dropped
+// if the queue is full, so reporting never blocks a request.
+type reporter struct {
+	recorder      record.EventRecorder
+	dynamicClient dynamic.Interface
+	interval      time.Duration
+	queue         chan statusUpdate
+
+	lock         sync.Mutex
+	lastReported map[string]time.Time // Keyed by <cluster>/<reason>
+	indexed      map[string]bool      // Last SearchIndexed status sent for each cluster.
+}
+
+var healthReporter *reporter
+
+// Start reporting the indexing health of the clusters. Reports before Start are ignored.
+func Start(ctx context.Context, kubeClient kubernetes.Interface, dynamicClient dynamic.Interface) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	r := &reporter{
+		recorder: broadcaster.NewRecorder(scheme.Scheme,
+			corev1.EventSource{Component: "search-indexer", Host: config.Cfg.PodName}),
+		dynamicClient: dynamicClient,
+		interval:      time.Duration(config.Cfg.IndexingStatusIntervalMS) * time.Millisecond,
+		queue:         make(chan statusUpdate, queueSize),
+		lastReported:  map[string]time.Time{},
+		indexed:       map[string]bool{},
+	}
+	healthReporter = r
+
+	go func() {
+		defer broadcaster.Shutdown()
+		for {
+			select {
+			case <-ctx.Done():
+				klog.Info("Exit cluster health reporter.")
+				return
+			case update := <-r.queue:
+				r.send(ctx, update)
+			}
+		}
+	}()
+}
+
+// ReportProblem reports a problem indexing the requests from a cluster.
+func ReportProblem(clusterName, reason, message string) {
+	if healthReporter == nil || clusterName == "" {
+		return
+	}
+	healthReporter.enqueue(statusUpdate{clusterName: clusterName, reason: reason, message: message})
+}
+
+// ReportIndexed reports that a request from the cluster was indexed. Only sent when the status changes.
+func ReportIndexed(clusterName string) {
+	if healthReporter == nil || clusterName == "" {
+		return
+	}
+	healthReporter.enqueue(statusUpdate{clusterName: clusterName, reason: ReasonIndexed, indexed: true,
+		message: "Search is indexing the resources from the cluster."})
+}
+
+// Add the update to the queue if it isn't rate limited.
+func (r *reporter) enqueue(update statusUpdate) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Indexed is skipped while this replica last sent True. Other replicas can set the condition to False, so it's
+	// sent again after the interval, and send reads the condition from the addon.
+	key := update.clusterName + "/" + update.reason
+	if last, ok := r.lastReported[key]; ok && time.Since(last) < r.interval &&
+		(!update.indexed || r.indexed[update.clusterName]) {
+		klog.V(5).Infof("Skipping %s report for cluster %s. Last reported %s ago.", update.reason,
+			update.clusterName, time.Since(last))
+		return
+	}
+	r.lastReported[key] = time.Now()
+
+	select {
+	case r.queue <- update:
+		r.indexed[update.clusterName] = update.indexed
+	default:
+		klog.V(3).Infof("Dropping %s report for cluster %s because the queue is full.", update.reason,
+			update.clusterName)
+	}
+}
+
+// Update the SearchIndexed condition and send the Event. Indexed only sends the Event when the condition changed.
+func (r *reporter) send(ctx context.Context, update statusUpdate) {
+	changed, err := r.updateCondition(ctx, update)
+	if err != nil {
+		klog.Warningf("Error updating %s condition for cluster %s. %s", ConditionSearchIndexed,
+			update.clusterName, err)
+	}
+	eventType := corev1.EventTypeWarning
+	if update.indexed {
+		if !changed && err == nil {
+			return
+		}
+		eventType = corev1.EventTypeNormal
+	}
+	r.recorder.Event(managedClusterRef(update.clusterName), eventType, update.reason, update.message)
+}
+
+// Set the SearchIndexed condition on the search-collector ManagedClusterAddOn. Returns true if the condition changed.
+func (r *reporter) updateCondition(ctx context.Context, update statusUpdate) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	client := r.dynamicClient.Resource(managedClusterAddonGvr).Namespace(update.clusterName)
+	addon, err := client.Get(ctx, searchCollectorAddon, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		klog.V(3).Infof("The %s addon isn't enabled in cluster %s.", searchCollectorAddon, update.clusterName)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	status := struct {
+		Conditions []metav1.Condition `json:"conditions,omitempty"`
+	}{}
+	statusObj, _, _ := unstructured.NestedMap(addon.Object, "status")
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(statusObj, &status); err != nil {
+		return false, fmt.Errorf("error reading the addon status: %w", err)
+	}
+	conditionStatus := metav1.ConditionFalse
+	if update.indexed {
+		conditionStatus = metav1.ConditionTrue
+	}
+	changed := meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               ConditionSearchIndexed,
+		Status:             conditionStatus,
+		Reason:             update.reason,
+		Message:            update.message,
+		ObservedGeneration: addon.GetGeneration(),
+	})
+	if !changed {
+		return false, nil
+	}
+
+	conditions, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return false, err
+	}
+	if err = unstructured.SetNestedSlice(addon.Object, conditions["conditions"].([]interface{}),
+		"status", "conditions"); err != nil {
+		return false, err
+	}
+	_, err = client.UpdateStatus(ctx, addon, metav1.UpdateOptions{})
+	return err == nil, err
+}
+
+// Reference to the ManagedCluster for the Event. Events for cluster scoped objects are created in the default
+// namespace.
+func managedClusterRef(clusterName string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "cluster.open-cluster-management.io/v1",
+		Kind:       "ManagedCluster",
+		Name:       clusterName,
+	}
+}
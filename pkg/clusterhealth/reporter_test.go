@@ -0,0 +1,144 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clusterhealth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakeDynamic "k8s.io/client-go/dynamic/fake"
+	fakeClient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestReporter(objects ...runtime.Object) *reporter {
+	dynamicClient := fakeDynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{managedClusterAddonGvr: "ManagedClusterAddOnList"}, objects...)
+	return &reporter{
+		recorder:      record.NewFakeRecorder(10),
+		dynamicClient: dynamicClient,
+		interval:      time.Minute,
+		queue:         make(chan statusUpdate, 2),
+		lastReported:  map[string]time.Time{},
+		indexed:       map[string]bool{},
+	}
+}
+
+func newTestAddon(clusterName string) *unstructured.Unstructured {
+	addon := &unstructured.Unstructured{}
+	addon.SetAPIVersion("addon.open-cluster-management.io/v1alpha1")
+	addon.SetKind("ManagedClusterAddOn")
+	addon.SetNamespace(clusterName)
+	addon.SetName(searchCollectorAddon)
+	return addon
+}
+
+// The same problem is reported once per interval.
+func Test_enqueue_RateLimited(t *testing.T) {
+	r := newTestReporter()
+
+	r.enqueue(statusUpdate{clusterName: "cluster1", reason: ReasonSyncRejected})
+	r.enqueue(statusUpdate{clusterName: "cluster1", reason: ReasonSyncRejected})
+	assert.Equal(t, 1, len(r.queue), "Expected the second report to be rate limited.")
+
+	r.enqueue(statusUpdate{clusterName: "cluster1", reason: ReasonDatabaseError})
+	assert.Equal(t, 2, len(r.queue), "Expected a report for a different reason.")
+}
+
+// Indexed is only reported when the status changes, or after the interval in case another replica reported a problem.
+func Test_enqueue_Indexed(t *testing.T) {
+	r := newTestReporter()
+
+	r.enqueue(statusUpdate{clusterName: "cluster1", reason: ReasonIndexed, indexed: true})
+	r.enqueue(statusUpdate{clusterName: "cluster1", reason: ReasonIndexed, indexed: true})
+	assert.Equal(t, 1, len(r.queue), "Expected a single indexed report.")
+
+	r.lastReported["cluster1/"+ReasonIndexed] = time.Now().Add(-2 * r.interval)
+	r.enqueue(statusUpdate{clusterName: "cluster1", reason: ReasonIndexed, indexed: true})
+	assert.Equal(t, 2, len(r.queue), "Expected the indexed report again after the interval.")
+}
+
+// Updates are dropped when the queue is full.
+func Test_enqueue_QueueFull(t *testing.T) {
+	r := newTestReporter()
+
+	r.enqueue(statusUpdate{clusterName: "cluster1", reason: ReasonSyncRejected})
+	r.enqueue(statusUpdate{clusterName: "cluster2", reason: ReasonSyncRejected})
+	r.enqueue(statusUpdate{clusterName: "cluster3", reason: ReasonSyncRejected})
+	assert.Equal(t, 2, len(r.queue), "Expected the update to be dropped.")
+}
+
+// Should send a Warning event and set the SearchIndexed condition to False.
+func Test_send(t *testing.T) {
+	r := newTestReporter(newTestAddon("cluster1"))
+
+	r.send(context.Background(), statusUpdate{clusterName: "cluster1", reason: ReasonResyncRejected,
+		message: "Request rejected."})
+
+	event := <-r.recorder.(*record.FakeRecorder).Events
+	assert.Equal(t, "Warning ResyncRejected Request rejected.", event)
+
+	addon, err := r.dynamicClient.Resource(managedClusterAddonGvr).Namespace("cluster1").
+		Get(context.Background(), searchCollectorAddon, metav1.GetOptions{})
+	assert.Nil(t, err)
+	conditions, _, _ := unstructured.NestedSlice(addon.Object, "status", "conditions")
+	assert.Equal(t, 1, len(conditions))
+	condition := conditions[0].(map[string]interface{})
+	assert.Equal(t, ConditionSearchIndexed, condition["type"])
+	assert.Equal(t, "False", condition["status"])
+	assert.Equal(t, ReasonResyncRejected, condition["reason"])
+}
+
+// Should set the condition to True when another replica set it to False, and only send the Event when it changed.
+func Test_send_Indexed(t *testing.T) {
+	r := newTestReporter(newTestAddon("cluster1"))
+	events := r.recorder.(*record.FakeRecorder).Events
+	r.send(context.Background(), statusUpdate{clusterName: "cluster1", reason: ReasonSyncRejected,
+		message: "Request rejected."})
+	<-events
+	indexed := statusUpdate{clusterName: "cluster1", reason: ReasonIndexed, indexed: true, message: "Indexed."}
+
+	r.send(context.Background(), indexed)
+	assert.Equal(t, "Normal Indexed Indexed.", <-events)
+	r.send(context.Background(), indexed)
+	assert.Empty(t, events, "Expected no event when the condition didn't change.")
+
+	addon, err := r.dynamicClient.Resource(managedClusterAddonGvr).Namespace("cluster1").
+		Get(context.Background(), searchCollectorAddon, metav1.GetOptions{})
+	assert.Nil(t, err)
+	conditions, _, _ := unstructured.NestedSlice(addon.Object, "status", "conditions")
+	assert.Equal(t, "True", conditions[0].(map[string]interface{})["status"])
+}
+
+// Should not fail when the search-collector addon doesn't exist.
+func Test_updateCondition_AddonNotFound(t *testing.T) {
+	r := newTestReporter()
+
+	changed, err := r.updateCondition(context.Background(), statusUpdate{clusterName: "cluster1",
+		reason: ReasonIndexed, indexed: true})
+
+	assert.Nil(t, err)
+	assert.False(t, changed)
+}
+
+// Should create the Event using the Kubernetes API.
+func Test_Start(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kubeClient := fakeClient.NewSimpleClientset()
+	Start(ctx, kubeClient, newTestReporter().dynamicClient)
+	defer func() { healthReporter = nil }()
+
+	ReportProblem("cluster1", ReasonDatabaseError, "Error processing the request.")
+
+	assert.Eventually(t, func() bool {
+		events, _ := kubeClient.CoreV1().Events("default").List(ctx, metav1.ListOptions{})
+		return len(events.Items) == 1 && events.Items[0].InvolvedObject.Name == "cluster1"
+	}, 5*time.Second, 10*time.Millisecond, "Expected an event for the ManagedCluster.")
+}
@@ -0,0 +1,88 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clusterquota
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/database"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// Annotations on the ManagedCluster that override the default quotas. Zero is unlimited.
+const (
+	AnnotationResources = "search.open-cluster-management.io/quota-resources"
+	AnnotationEdges     = "search.open-cluster-management.io/quota-edges"
+	AnnotationDataBytes = "search.open-cluster-management.io/quota-data-bytes"
+)
+
+var managedClusterGvr = schema.GroupVersionResource{
+	Group:    "cluster.open-cluster-management.io",
+	Version:  "v1",
+	Resource: "managedclusters",
+}
+
+// Start watching the quota annotations of the ManagedClusters. Runs on every replica, not only the leader, because
+// each replica enforces the quotas of the requests it receives.
+func Start(ctx context.Context, dynamicClient dynamic.Interface) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient,
+		time.Duration(config.Cfg.ResyncPeriodMS)*time.Millisecond)
+	informer := factory.ForResource(managedClusterGvr).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    processCluster,
+		UpdateFunc: func(_, next interface{}) { processCluster(next) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if cluster, ok := obj.(*unstructured.Unstructured); ok {
+				database.DeleteClusterQuota(cluster.GetName())
+			}
+		},
+	})
+	if err != nil {
+		klog.Error("Error adding the event handler for the cluster quotas. ", err)
+		return
+	}
+	factory.Start(ctx.Done())
+}
+
+// Set the quota overrides of the ManagedCluster.
+func processCluster(obj interface{}) {
+	cluster, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	database.SetClusterQuota(cluster.GetName(), quotaFromAnnotations(cluster.GetName(), cluster.GetAnnotations()))
+}
+
+// Get the quota overrides from the annotations. Missing or invalid annotations use the default quota.
+func quotaFromAnnotations(clusterName string, annotations map[string]string) database.ClusterQuota {
+	return database.ClusterQuota{
+		Resources: annotationValue(clusterName, annotations, AnnotationResources),
+		Edges:     annotationValue(clusterName, annotations, AnnotationEdges),
+		DataBytes: annotationValue(clusterName, annotations, AnnotationDataBytes),
+	}
+}
+
+func annotationValue(clusterName string, annotations map[string]string, annotation string) int {
+	value, ok := annotations[annotation]
+	if !ok {
+		return -1
+	}
+	quota, err := strconv.Atoi(value)
+	if err != nil || quota < 0 {
+		klog.Warningf("Ignoring annotation %s=%q on ManagedCluster %s. The value must be zero or a positive integer.",
+			annotation, value, clusterName)
+		return -1
+	}
+	return quota
+}
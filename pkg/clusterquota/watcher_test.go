@@ -0,0 +1,29 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clusterquota
+
+import (
+	"testing"
+
+	"github.com/stolostron/search-indexer/pkg/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_quotaFromAnnotations(t *testing.T) {
+	quota := quotaFromAnnotations("cluster1", map[string]string{
+		AnnotationResources: "1000",
+		AnnotationEdges:     "-5",
+		"other":             "1",
+	})
+
+	assert.Equal(t, database.ClusterQuota{Resources: 1000, Edges: -1, DataBytes: -1}, quota)
+}
+
+func Test_quotaFromAnnotations_unlimited(t *testing.T) {
+	quota := quotaFromAnnotations("cluster1", map[string]string{
+		AnnotationResources: "0",
+		AnnotationDataBytes: "10Mi",
+	})
+
+	assert.Equal(t, database.ClusterQuota{Resources: 0, Edges: -1, DataBytes: -1}, quota)
+}
@@ -0,0 +1,185 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clustersync
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/stolostron/search-indexer/pkg/database"
+	"github.com/stolostron/search-indexer/pkg/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	klog "k8s.io/klog/v2"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+)
+
+const addonLabelPrefix = "feature.open-cluster-management.io/addon-"
+const searchCollectorAddon = "search-collector"
+
+// Status reported from the Available and Degraded conditions.
+const (
+	addonStatusAvailable   = "available"
+	addonStatusDegraded    = "degraded"
+	addonStatusUnavailable = "unavailable"
+	addonStatusUnknown     = "unknown"
+)
+
+// Addons always listed in the addon property of the Cluster node, with "true" or "false".
+var allAddons = [9]string{
+	"application-manager",
+	"cert-policy-controller",
+	"cluster-proxy",
+	"config-policy-controller",
+	"governance-policy-framework",
+	"iam-policy-controller",
+	"observability-controller",
+	"search-collector",
+	"work-manager",
+}
+
+// Holds the addon status reported by the ManagedClusterAddOn informer, keyed by cluster name and addon name.
+// The informer only watches the search-collector addon. The status from the ManagedClusterAddOn conditions is more
+// detailed than the value of the addon label, so it takes precedence when building the addonStatus property.
+var addonStatusCache = map[string]map[string]string{}
+var addonStatusLock = sync.RWMutex{}
+
+// Build the addon property for the Cluster node.
+// Addons are discovered from the feature.open-cluster-management.io/addon-<name> labels on the ManagedCluster. The
+// known addons are always listed, with "true" if the label exists and "false" otherwise, and the other addons with
+// a label are added with "true".
+func getEnabledAddons(labelMap map[string]interface{}) map[string]interface{} {
+	enabledAddons := make(map[string]interface{}, len(allAddons))
+	for _, addon := range allAddons {
+		enabledAddons[addon] = "false"
+	}
+	for label := range labelMap {
+		if addon, found := strings.CutPrefix(label, addonLabelPrefix); found && addon != "" {
+			enabledAddons[addon] = "true"
+		}
+	}
+	return enabledAddons
+}
+
+// Build the addonStatus property for the Cluster node, with the status of each addon with a label.
+// The status is the label value (available, unhealthy, unreachable), overridden with the status from the
+// ManagedClusterAddOn informer when we have it.
+func getAddonStatus(clusterName string, labelMap map[string]interface{}) map[string]interface{} {
+	addonStatus := make(map[string]interface{})
+	for label, value := range labelMap {
+		if addon, found := strings.CutPrefix(label, addonLabelPrefix); found && addon != "" {
+			addonStatus[addon] = value
+		}
+	}
+
+	addonStatusLock.RLock()
+	defer addonStatusLock.RUnlock()
+	for addon, status := range addonStatusCache[clusterName] {
+		addonStatus[addon] = status
+	}
+	return addonStatus
+}
+
+// Get the status from the Available and Degraded conditions. Used for ManagedClusterAddOn and HostedCluster.
+func getAvailabilityStatus(conditions []metav1.Condition) string {
+	status := addonStatusUnknown
+	for _, condition := range conditions {
+		switch condition.Type {
+		case addonv1alpha1.ManagedClusterAddOnConditionDegraded:
+			if condition.Status == metav1.ConditionTrue {
+				return addonStatusDegraded
+			}
+		case addonv1alpha1.ManagedClusterAddOnConditionAvailable:
+			switch condition.Status {
+			case metav1.ConditionTrue:
+				status = addonStatusAvailable
+			case metav1.ConditionFalse:
+				status = addonStatusUnavailable
+			}
+		}
+	}
+	return status
+}
+
+// Records the addon status from a ManagedClusterAddOn and returns true if the status changed.
+func updateAddonStatusCache(clusterName, addon, status string) bool {
+	addonStatusLock.Lock()
+	defer addonStatusLock.Unlock()
+	if addonStatusCache[clusterName] == nil {
+		addonStatusCache[clusterName] = map[string]string{}
+	}
+	if existing, ok := addonStatusCache[clusterName][addon]; ok && existing == status {
+		return false
+	}
+	addonStatusCache[clusterName][addon] = status
+	return true
+}
+
+// Removes the addon from the status cache. An empty addon name removes all addons for the cluster.
+func deleteAddonStatusCache(clusterName, addon string) {
+	addonStatusLock.Lock()
+	defer addonStatusLock.Unlock()
+	if addon == "" {
+		delete(addonStatusCache, clusterName)
+		return
+	}
+	delete(addonStatusCache[clusterName], addon)
+}
+
+// Transform a ManagedClusterAddOn event into an updated Cluster node.
+// Returns false if the Cluster node doesn't need to be updated.
+func transformManagedClusterAddon(obj *unstructured.Unstructured, deleted bool) (model.Resource, bool) {
+	clusterName := obj.GetNamespace() // Namespace reflects the name of the cluster
+	addonName := obj.GetName()
+
+	if deleted {
+		deleteAddonStatusCache(clusterName, addonName)
+	} else {
+		j, err := json.Marshal(obj)
+		if err != nil {
+			klog.Warning("Error marshalling ManagedClusterAddOn from Informer. ", err)
+			return model.Resource{}, false
+		}
+		managedClusterAddon := addonv1alpha1.ManagedClusterAddOn{}
+		if err = json.Unmarshal(j, &managedClusterAddon); err != nil {
+			klog.Warning("Failed to Unmarshal ManagedClusterAddOn ", err)
+			return model.Resource{}, false
+		}
+		if !updateAddonStatusCache(clusterName, addonName, getAvailabilityStatus(managedClusterAddon.Status.Conditions)) {
+			klog.V(4).Infof("Status for addon %s in cluster %s hasn't changed.", addonName, clusterName)
+			return model.Resource{}, false
+		}
+	}
+
+	// The Cluster node is created from the ManagedCluster. Wait for it if we don't have it yet.
+	props, ok := copyClusterProps(clusterName)
+	if !ok {
+		klog.V(4).Infof("Cluster node for %s not found in cache. Skipping addon update.", clusterName)
+		return model.Resource{}, false
+	}
+	labelMap, _ := props["label"].(map[string]interface{})
+	props["addonStatus"] = getAddonStatus(clusterName, labelMap)
+
+	return model.Resource{
+		Kind:           "Cluster",
+		UID:            string("cluster__" + clusterName),
+		Properties:     props,
+		ResourceString: "managedclusterinfos", // Maps rbac to ManagedClusterInfo
+	}, true
+}
+
+// Copy the properties of the Cluster node from the clusters cache.
+// The cached map must not be modified before the update is written to the database.
+func copyClusterProps(clusterName string) (map[string]interface{}, bool) {
+	data, ok := database.ReadClustersCache(string("cluster__" + clusterName))
+	existingProps, isMap := data.(map[string]interface{})
+	if !ok || !isMap {
+		return nil, false
+	}
+	props := make(map[string]interface{}, len(existingProps))
+	for key, val := range existingProps {
+		props[key] = val
+	}
+	return props, true
+}
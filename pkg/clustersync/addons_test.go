@@ -0,0 +1,70 @@
+// Copyright Contributors to the Open Cluster Management project
+package clustersync
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The known addons are always listed with "true" or "false", and other addons with a label are added.
+func Test_getEnabledAddons_FromLabels(t *testing.T) {
+	labels := map[string]interface{}{
+		"env": "dev",
+		"feature.open-cluster-management.io/addon-search-collector": "available",
+		"feature.open-cluster-management.io/addon-hypershift-addon": "unhealthy",
+	}
+
+	addons := getEnabledAddons(labels)
+
+	AssertEqual(t, len(addons), 10, "Expected the 9 known addons and hypershift-addon.")
+	AssertEqual(t, addons["search-collector"], "true", "Expected search-collector to be enabled.")
+	AssertEqual(t, addons["work-manager"], "false", "Expected work-manager to be disabled.")
+	AssertEqual(t, addons["hypershift-addon"], "true", "Expected hypershift-addon to be enabled.")
+}
+
+// The status is taken from the labels.
+func Test_getAddonStatus_FromLabels(t *testing.T) {
+	labels := map[string]interface{}{
+		"env": "dev",
+		"feature.open-cluster-management.io/addon-hypershift-addon": "unhealthy",
+		"feature.open-cluster-management.io/addon-volsync":          "unreachable",
+	}
+
+	addonStatus := getAddonStatus("cluster-labels", labels)
+
+	AssertEqual(t, len(addonStatus), 2, "Expected 2 addons.")
+	AssertEqual(t, addonStatus["hypershift-addon"], "unhealthy", "Expected hypershift-addon status from label.")
+	AssertEqual(t, addonStatus["volsync"], "unreachable", "Expected volsync status from label.")
+}
+
+// Status from the ManagedClusterAddOn overrides the label value.
+func Test_getAddonStatus_StatusFromInformer(t *testing.T) {
+	updateAddonStatusCache("cluster-status", searchCollectorAddon, addonStatusDegraded)
+	defer deleteAddonStatusCache("cluster-status", "")
+	labels := map[string]interface{}{
+		"feature.open-cluster-management.io/addon-search-collector": "available",
+	}
+
+	addonStatus := getAddonStatus("cluster-status", labels)
+
+	AssertEqual(t, addonStatus[searchCollectorAddon], addonStatusDegraded, "Expected search-collector status from informer.")
+}
+
+func Test_getAvailabilityStatus(t *testing.T) {
+	tests := []struct {
+		conditions []metav1.Condition
+		expected   string
+	}{
+		{nil, addonStatusUnknown},
+		{[]metav1.Condition{{Type: "Available", Status: metav1.ConditionTrue}}, addonStatusAvailable},
+		{[]metav1.Condition{{Type: "Available", Status: metav1.ConditionFalse}}, addonStatusUnavailable},
+		{[]metav1.Condition{{Type: "Available", Status: metav1.ConditionUnknown}}, addonStatusUnknown},
+		{[]metav1.Condition{
+			{Type: "Available", Status: metav1.ConditionTrue},
+			{Type: "Degraded", Status: metav1.ConditionTrue}}, addonStatusDegraded},
+	}
+	for _, test := range tests {
+		AssertEqual(t, getAvailabilityStatus(test.conditions), test.expected, "Unexpected addon status.")
+	}
+}
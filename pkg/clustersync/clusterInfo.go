@@ -0,0 +1,133 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clustersync
+
+import (
+	"sort"
+	"strings"
+
+	clusterv1beta1 "github.com/stolostron/cluster-lifecycle-api/clusterinfo/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+// Node labels used to find the cloud region of the cluster.
+var regionLabels = []string{"topology.kubernetes.io/region", "failure-domain.beta.kubernetes.io/region"}
+
+// Extract the distribution properties from the ManagedClusterInfo.
+// Properties for the OpenShift distribution are only added for OCP clusters.
+//
+//	distribution              string   Sample: OCP
+//	kubeVendor                string   Sample: OpenShift
+//	cloudVendor               string   Sample: Amazon
+//	openshiftVersion          string   Sample: 4.16.3
+//	openshiftDesiredVersion   string   Sample: 4.16.5
+//	openshiftChannel          string   Sample: stable-4.16
+//	openshiftAvailableUpdates []string Sample: [4.16.4, 4.16.5]
+//	openshiftUpgradeFailed    bool
+func addDistributionProperties(props map[string]interface{}, status *clusterv1beta1.ClusterInfoStatus) {
+	props["distribution"] = string(status.DistributionInfo.Type)
+	props["kubeVendor"] = string(status.KubeVendor)
+	props["cloudVendor"] = string(status.CloudVendor)
+
+	if status.DistributionInfo.Type != clusterv1beta1.DistributionTypeOCP {
+		return
+	}
+	ocp := status.DistributionInfo.OCP
+	props["openshiftVersion"] = ocp.Version
+	props["openshiftDesiredVersion"] = ocp.Desired.Version
+	if ocp.Desired.Version == "" {
+		props["openshiftDesiredVersion"] = ocp.DesiredVersion
+	}
+	props["openshiftChannel"] = ocp.Channel
+	props["openshiftUpgradeFailed"] = ocp.UpgradeFailed
+
+	availableUpdates := make([]string, 0, len(ocp.VersionAvailableUpdates))
+	for _, update := range ocp.VersionAvailableUpdates {
+		availableUpdates = append(availableUpdates, update.Version)
+	}
+	if len(availableUpdates) == 0 {
+		availableUpdates = append(availableUpdates, ocp.AvailableUpdates...)
+	}
+	sort.Strings(availableUpdates)
+	props["openshiftAvailableUpdates"] = availableUpdates
+}
+
+// Extract the node properties from the ManagedClusterInfo node list.
+// CPU is reported in cores and memory in bytes.
+//
+//	nodesReady         int64
+//	nodeRoles          []string Sample: [master, worker]
+//	region             string   Sample: us-east-1
+//	controlPlaneNodes  int64
+//	controlPlaneCPU    int64
+//	controlPlaneMemory int64
+//	workerNodes        int64
+//	workerCPU          int64
+//	workerMemory       int64
+func addNodeProperties(props map[string]interface{}, nodeList []clusterv1beta1.NodeStatus) {
+	var nodesReady, controlPlaneNodes, controlPlaneCPU, controlPlaneMemory, workerNodes, workerCPU, workerMemory int64
+	region := ""
+	roles := map[string]struct{}{}
+
+	for _, node := range nodeList {
+		for _, condition := range node.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				nodesReady++
+			}
+		}
+		if region == "" {
+			for _, label := range regionLabels {
+				if value, ok := node.Labels[label]; ok {
+					region = value
+					break
+				}
+			}
+		}
+
+		cpu := node.Capacity[clusterv1beta1.ResourceCPU]
+		memory := node.Capacity[clusterv1beta1.ResourceMemory]
+		isControlPlane, isWorker := false, false
+		for label := range node.Labels {
+			role, found := strings.CutPrefix(label, nodeRoleLabelPrefix)
+			if !found || role == "" {
+				continue
+			}
+			roles[role] = struct{}{}
+			switch role {
+			case "master", "control-plane":
+				isControlPlane = true
+			case "worker":
+				isWorker = true
+			}
+		}
+		// A node can have both roles in compact clusters.
+		if isControlPlane {
+			controlPlaneNodes++
+			controlPlaneCPU += cpu.Value()
+			controlPlaneMemory += memory.Value()
+		}
+		if isWorker {
+			workerNodes++
+			workerCPU += cpu.Value()
+			workerMemory += memory.Value()
+		}
+	}
+
+	nodeRoles := make([]string, 0, len(roles))
+	for role := range roles {
+		nodeRoles = append(nodeRoles, role)
+	}
+	sort.Strings(nodeRoles)
+
+	props["nodesReady"] = nodesReady
+	props["nodeRoles"] = nodeRoles
+	props["region"] = region
+	props["controlPlaneNodes"] = controlPlaneNodes
+	props["controlPlaneCPU"] = controlPlaneCPU
+	props["controlPlaneMemory"] = controlPlaneMemory
+	props["workerNodes"] = workerNodes
+	props["workerCPU"] = workerCPU
+	props["workerMemory"] = workerMemory
+}
@@ -0,0 +1,106 @@
+// Copyright Contributors to the Open Cluster Management project
+package clustersync
+
+import (
+	"reflect"
+	"testing"
+
+	clusterv1beta1 "github.com/stolostron/cluster-lifecycle-api/clusterinfo/v1beta1"
+	"github.com/stolostron/search-indexer/pkg/database"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_addDistributionProperties_OCP(t *testing.T) {
+	status := &clusterv1beta1.ClusterInfoStatus{
+		KubeVendor:  clusterv1beta1.KubeVendorOpenShift,
+		CloudVendor: clusterv1beta1.CloudVendorAWS,
+		DistributionInfo: clusterv1beta1.DistributionInfo{
+			Type: clusterv1beta1.DistributionTypeOCP,
+			OCP: clusterv1beta1.OCPDistributionInfo{
+				Version:       "4.16.3",
+				Channel:       "stable-4.16",
+				UpgradeFailed: true,
+				Desired:       clusterv1beta1.OCPVersionRelease{Version: "4.16.5"},
+				VersionAvailableUpdates: []clusterv1beta1.OCPVersionRelease{
+					{Version: "4.16.5"}, {Version: "4.16.4"},
+				},
+			},
+		},
+	}
+	props := map[string]interface{}{}
+
+	addDistributionProperties(props, status)
+
+	AssertEqual(t, props["distribution"], "OCP", "Unexpected distribution.")
+	AssertEqual(t, props["kubeVendor"], "OpenShift", "Unexpected kubeVendor.")
+	AssertEqual(t, props["cloudVendor"], "Amazon", "Unexpected cloudVendor.")
+	AssertEqual(t, props["openshiftVersion"], "4.16.3", "Unexpected openshiftVersion.")
+	AssertEqual(t, props["openshiftDesiredVersion"], "4.16.5", "Unexpected openshiftDesiredVersion.")
+	AssertEqual(t, props["openshiftChannel"], "stable-4.16", "Unexpected openshiftChannel.")
+	AssertEqual(t, props["openshiftUpgradeFailed"], true, "Unexpected openshiftUpgradeFailed.")
+	if !reflect.DeepEqual(props["openshiftAvailableUpdates"], []string{"4.16.4", "4.16.5"}) {
+		t.Errorf("Unexpected openshiftAvailableUpdates %v", props["openshiftAvailableUpdates"])
+	}
+}
+
+func Test_addDistributionProperties_NotOCP(t *testing.T) {
+	status := &clusterv1beta1.ClusterInfoStatus{KubeVendor: clusterv1beta1.KubeVendorEKS}
+	props := map[string]interface{}{}
+
+	addDistributionProperties(props, status)
+
+	AssertEqual(t, props["kubeVendor"], "EKS", "Unexpected kubeVendor.")
+	_, found := props["openshiftVersion"]
+	AssertEqual(t, found, false, "Expected no OpenShift properties for EKS cluster.")
+}
+
+func Test_addNodeProperties(t *testing.T) {
+	capacity := clusterv1beta1.ResourceList{
+		clusterv1beta1.ResourceCPU:    resource.MustParse("4"),
+		clusterv1beta1.ResourceMemory: resource.MustParse("16Gi"),
+	}
+	ready := []clusterv1beta1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	nodeList := []clusterv1beta1.NodeStatus{
+		{Name: "master-0", Capacity: capacity, Conditions: ready, Labels: map[string]string{
+			"node-role.kubernetes.io/master":        "",
+			"node-role.kubernetes.io/control-plane": "",
+			"topology.kubernetes.io/region":         "us-east-1"}},
+		{Name: "worker-0", Capacity: capacity, Conditions: ready, Labels: map[string]string{
+			"node-role.kubernetes.io/worker": ""}},
+		{Name: "worker-1", Capacity: capacity, Labels: map[string]string{
+			"node-role.kubernetes.io/worker": "",
+			"node-role.kubernetes.io/infra":  ""}},
+	}
+	props := map[string]interface{}{}
+
+	addNodeProperties(props, nodeList)
+
+	AssertEqual(t, props["nodesReady"], int64(2), "Unexpected nodesReady.")
+	AssertEqual(t, props["region"], "us-east-1", "Unexpected region.")
+	AssertEqual(t, props["controlPlaneNodes"], int64(1), "Unexpected controlPlaneNodes.")
+	AssertEqual(t, props["controlPlaneCPU"], int64(4), "Unexpected controlPlaneCPU.")
+	AssertEqual(t, props["workerNodes"], int64(2), "Unexpected workerNodes.")
+	AssertEqual(t, props["workerCPU"], int64(8), "Unexpected workerCPU.")
+	AssertEqual(t, props["workerMemory"], int64(2*16*1024*1024*1024), "Unexpected workerMemory.")
+	if !reflect.DeepEqual(props["nodeRoles"], []string{"control-plane", "infra", "master", "worker"}) {
+		t.Errorf("Unexpected nodeRoles %v", props["nodeRoles"])
+	}
+}
+
+// The conditions of the ManagedClusterInfo don't overwrite the conditions from the ManagedCluster.
+func Test_transformManagedClusterInfo_Conditions(t *testing.T) {
+	database.UpdateClustersCache("cluster__cluster-conditions", map[string]interface{}{
+		"name": "cluster-conditions", "ManagedClusterConditionAvailable": "True"})
+	defer database.DeleteClustersCache("cluster__cluster-conditions")
+	managedClusterInfo := &clusterv1beta1.ManagedClusterInfo{}
+	managedClusterInfo.SetName("cluster-conditions")
+	managedClusterInfo.Status.Conditions = []metav1.Condition{
+		{Type: "ManagedClusterConditionAvailable", Status: metav1.ConditionUnknown}}
+
+	resource := transformManagedClusterInfo(managedClusterInfo)
+
+	AssertEqual(t, resource.Properties["ManagedClusterConditionAvailable"], "True",
+		"Expected the condition from the ManagedCluster.")
+}
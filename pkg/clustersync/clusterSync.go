@@ -35,18 +35,6 @@ const managedClusterAddonGVR = "managedclusteraddons.v1alpha1.addon.open-cluster
 const lockName = "search-indexer.open-cluster-management.io"
 const managedClusterInfoApiGrp = "internal.open-cluster-management.io"
 
-var allAddons = [9]string{
-	"application-manager",
-	"cert-policy-controller",
-	"cluster-proxy",
-	"config-policy-controller",
-	"governance-policy-framework",
-	"iam-policy-controller",
-	"observability-controller",
-	"search-collector",
-	"work-manager",
-}
-
 func ElectLeaderAndStart(ctx context.Context) {
 	client = config.Cfg.KubeClient
 	podName := config.Cfg.PodName
@@ -77,32 +65,67 @@ func syncClusters(ctx context.Context) {
 	managedClusterGvr, _ := schema.ParseResourceArg(managedClusterGVR)
 	managedClusterInfoGvr, _ := schema.ParseResourceArg(managedClusterInfoGVR)
 	managedClusterAddonGvr, _ := schema.ParseResourceArg(managedClusterAddonGVR)
+	managedClusterSetGvr, _ := schema.ParseResourceArg(managedClusterSetGVR)
+	managedClusterSetBindingGvr, _ := schema.ParseResourceArg(managedClusterSetBindingGVR)
+	placementGvr, _ := schema.ParseResourceArg(placementGVR)
+	placementDecisionGvr, _ := schema.ParseResourceArg(placementDecisionGVR)
+	hostedClusterGvr, _ := schema.ParseResourceArg(hostedClusterGVR)
 
 	//Create Informers for ManagedCluster and ManagedClusterInfo
 	managedClusterInformer := dynamicFactory.ForResource(*managedClusterGvr).Informer()
 	managedClusterInfoInformer := dynamicFactory.ForResource(*managedClusterInfoGvr).Informer()
+	// The status of the other addons is taken from their label on the ManagedCluster.
 	managedClusterAddonInformer := filteredDynamicFactory.ForResource(*managedClusterAddonGvr).Informer()
 
+	// Create Informers for the hub resources with edges to the clusters.
+	managedClusterSetInformer := dynamicFactory.ForResource(*managedClusterSetGvr).Informer()
+	managedClusterSetBindingInformer := dynamicFactory.ForResource(*managedClusterSetBindingGvr).Informer()
+	placementInformer := dynamicFactory.ForResource(*placementGvr).Informer()
+	placementDecisionInformer := dynamicFactory.ForResource(*placementDecisionGvr).Informer()
+	// HostedClusters on the hub are used to find the hosted control plane of HyperShift clusters.
+	hostedClusterInformer := dynamicFactory.ForResource(*hostedClusterGvr).Informer()
+	managedClusterStore = managedClusterInformer.GetStore()
+	clusterSetStore = managedClusterSetInformer.GetStore()
+
 	resyncPeriod := time.Duration(config.Cfg.ResyncPeriodMS) * time.Millisecond
+	// Delete the queued clusters, including the stale clusters found below. Runs until leadership is lost.
+	go dao.StartClusterDeleteQueue(ctx)
 	// Confirm delete event not missed if indexer OR db goes offline:
 	err := deleteStaleClusterResources(ctx, dynamicClient, *managedClusterGvr)
 	if err != nil {
 		klog.Warning("Error deleting stale clusters resources", err.Error())
 	}
+	// Delete the edges whose source or destination doesn't exist. Runs until leadership is lost.
+	go dao.StartOrphanEdgeCleanup(ctx)
+	// Reconcile the property indexes and report their usage. Only the leader builds and drops indexes, so replicas
+	// don't drop an index that another one is building.
+	go dao.ReconcileIndexes(ctx)
+	go dao.StartIndexUsageReport(ctx)
+	// Compute the interCluster edges of all clusters. The replicas only compute the queued clusters and hub kinds.
+	go dao.ReconcileInterClusterEdges(ctx)
 
 	// Create handlers for events
 	handlers := cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			klog.V(4).Info("AddFunc for ", obj.(*unstructured.Unstructured).GetKind())
 			processClusterUpsert(ctx, obj)
+			if obj.(*unstructured.Unstructured).GetKind() == "ManagedCluster" {
+				scheduleClusterSetRefresh(ctx)
+			}
 		},
 		UpdateFunc: func(prev interface{}, next interface{}) {
 			klog.V(4).Info("UpdateFunc for ", next.(*unstructured.Unstructured).GetKind())
 			processClusterUpsert(ctx, next)
+			if next.(*unstructured.Unstructured).GetKind() == "ManagedCluster" && clusterLabelsChanged(prev, next) {
+				scheduleClusterSetRefresh(ctx)
+			}
 		},
 		DeleteFunc: func(obj interface{}) {
 			klog.V(4).Info("DeleteFunc for ", obj.(*unstructured.Unstructured).GetKind())
 			processClusterDelete(ctx, obj)
+			if obj.(*unstructured.Unstructured).GetKind() == "ManagedCluster" {
+				scheduleClusterSetRefresh(ctx)
+			}
 		},
 	}
 
@@ -114,8 +137,46 @@ func syncClusters(ctx context.Context) {
 	_, managedClusterAddonErr := managedClusterAddonInformer.AddEventHandlerWithResyncPeriod(handlers, resyncPeriod)
 	checkError(managedClusterAddonErr, "Error adding eventHandler for managedClusterAddon")
 
+	hubResourceHandlers := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			klog.V(4).Info("AddFunc for ", obj.(*unstructured.Unstructured).GetKind())
+			processHubResourceUpsert(ctx, obj)
+		},
+		UpdateFunc: func(prev interface{}, next interface{}) {
+			klog.V(4).Info("UpdateFunc for ", next.(*unstructured.Unstructured).GetKind())
+			processHubResourceUpsert(ctx, next)
+		},
+		DeleteFunc: func(obj interface{}) {
+			klog.V(4).Info("DeleteFunc for ", obj.(*unstructured.Unstructured).GetKind())
+			processHubResourceDelete(ctx, obj)
+		},
+	}
+	hubInformers := []cache.SharedIndexInformer{managedClusterSetInformer, managedClusterSetBindingInformer,
+		placementInformer, placementDecisionInformer}
+	for _, informer := range hubInformers {
+		_, hubResourceErr := informer.AddEventHandlerWithResyncPeriod(hubResourceHandlers, resyncPeriod)
+		checkError(hubResourceErr, "Error adding eventHandler for hub resource")
+	}
+
+	hostedClusterHandlers := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			klog.V(4).Info("AddFunc for ", obj.(*unstructured.Unstructured).GetKind())
+			processHostedClusterEvent(ctx, obj, false)
+		},
+		UpdateFunc: func(prev interface{}, next interface{}) {
+			klog.V(4).Info("UpdateFunc for ", next.(*unstructured.Unstructured).GetKind())
+			processHostedClusterEvent(ctx, next, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			klog.V(4).Info("DeleteFunc for ", obj.(*unstructured.Unstructured).GetKind())
+			processHostedClusterEvent(ctx, obj, true)
+		},
+	}
+	_, hostedClusterErr := hostedClusterInformer.AddEventHandlerWithResyncPeriod(hostedClusterHandlers, resyncPeriod)
+	checkError(hostedClusterErr, "Error adding eventHandler for hostedCluster")
+
 	wg := sync.WaitGroup{}
-	wg.Add(3)
+	wg.Add(8)
 	// Periodically check if the ManagedCluster/ManagedClusterInfo resource exists
 	go func() {
 		defer wg.Done()
@@ -129,6 +190,26 @@ func syncClusters(ctx context.Context) {
 		defer wg.Done()
 		stopAndStartInformer(ctx, "addon.open-cluster-management.io/v1alpha1", managedClusterAddonInformer)
 	}()
+	go func() {
+		defer wg.Done()
+		stopAndStartInformer(ctx, "cluster.open-cluster-management.io/v1beta2", managedClusterSetInformer)
+	}()
+	go func() {
+		defer wg.Done()
+		stopAndStartInformer(ctx, "cluster.open-cluster-management.io/v1beta2", managedClusterSetBindingInformer)
+	}()
+	go func() {
+		defer wg.Done()
+		stopAndStartInformer(ctx, "cluster.open-cluster-management.io/v1beta1", placementInformer)
+	}()
+	go func() {
+		defer wg.Done()
+		stopAndStartInformer(ctx, "cluster.open-cluster-management.io/v1beta1", placementDecisionInformer)
+	}()
+	go func() {
+		defer wg.Done()
+		stopAndStartInformer(ctx, "hypershift.openshift.io/v1beta1", hostedClusterInformer)
+	}()
 
 	// block until goroutines to finish
 	wg.Wait()
@@ -142,8 +223,9 @@ func deleteStaleClusterResources(ctx context.Context, dynamicClient dynamic.Inte
 		klog.Warning("Error finding stale cluster resources", err.Error())
 		return err
 	} else if len(clusterRemaining) > 0 {
+		// A cluster that was pending delete keeps the time to purge its data.
 		for _, cluster := range clusterRemaining {
-			dao.DeleteClusterAndResources(ctx, cluster, false)
+			softDeleteCluster(ctx, cluster)
 		}
 	}
 	return err
@@ -218,8 +300,14 @@ func processClusterUpsert(ctx context.Context, obj interface{}) {
 		}
 		resource = transformManagedClusterInfo(&managedClusterInfo)
 	case "ManagedClusterAddOn":
-		klog.V(4).Infof("No upsert cluster actions for kind: %s", obj.(*unstructured.Unstructured).GetKind())
-		return
+		if obj.(*unstructured.Unstructured).GetName() == searchCollectorAddon {
+			cancelSoftDelete(ctx, obj.(*unstructured.Unstructured).GetNamespace())
+		}
+		var changed bool
+		resource, changed = transformManagedClusterAddon(obj.(*unstructured.Unstructured), false)
+		if !changed {
+			return
+		}
 	default:
 		klog.Warning("ClusterWatch received unknown kind.", obj.(*unstructured.Unstructured).GetKind())
 		return
@@ -227,6 +315,10 @@ func processClusterUpsert(ctx context.Context, obj interface{}) {
 
 	// Upsert (attempt insert, update on failure)
 	dao.UpsertCluster(ctx, resource)
+	if obj.(*unstructured.Unstructured).GetKind() == "ManagedCluster" {
+		hostingCluster, _ := resource.Properties["hostingCluster"].(string)
+		updateHostingEdge(ctx, obj.(*unstructured.Unstructured).GetName(), hostingCluster)
+	}
 
 	// A cluster can be offline due to resource shortage, network outage or other reasons. We are not deleting
 	// the cluster or resources if a cluster is offline to avoid unnecessary deletes and re-inserts in the database.
@@ -276,6 +368,10 @@ func transformManagedClusterInfo(managedClusterInfo *clusterv1beta1.ManagedClust
 	props["apiEndpoint"] = managedClusterInfo.Spec.MasterEndpoint
 	props["consoleURL"] = managedClusterInfo.Status.ConsoleURL
 	props["nodes"] = int64(len(managedClusterInfo.Status.NodeList))
+	addDistributionProperties(props, &managedClusterInfo.Status)
+	addNodeProperties(props, managedClusterInfo.Status.NodeList)
+	// The conditions, like ManagedClusterConditionAvailable and HubAcceptedManagedCluster, are taken from the
+	// ManagedCluster.
 	props["kind"] = "Cluster"
 	props["name"] = managedClusterInfo.GetName()
 	props["apigroup"] = managedClusterInfoApiGrp // Maps rbac to ManagedClusterInfo
@@ -307,6 +403,7 @@ func transformManagedCluster(managedCluster *clusterv1.ManagedCluster) model.Res
 
 			// Extract the enabled addons from labels
 			props["addon"] = getEnabledAddons(labelMap) // maps to the enabled addons on the cluster
+			props["addonStatus"] = getAddonStatus(managedCluster.GetName(), labelMap)
 
 		}
 	}
@@ -321,11 +418,13 @@ func transformManagedCluster(managedCluster *clusterv1.ManagedCluster) model.Res
 	memCapacity := managedCluster.Status.Capacity["memory"]
 	props["memory"] = memCapacity.String()
 	props["kubernetesVersion"] = managedCluster.Status.Version.Kubernetes
+	props["hubAcceptsClient"] = managedCluster.Spec.HubAcceptsClient
 
 	for _, condition := range managedCluster.Status.Conditions {
 		props[condition.Type] = string(condition.Status)
 	}
 	props = addAdditionalProperties(props)
+	addHostedClusterProperties(props, managedCluster.GetName(), managedCluster.GetAnnotations())
 	resource := model.Resource{
 		Kind:           "Cluster",
 		UID:            string("cluster__" + managedCluster.GetName()),
@@ -348,16 +447,25 @@ func processClusterDelete(ctx context.Context, obj interface{}) {
 		// ManagedClusterInfo (namespace scoped) will be deleted when the MC (cluster scoped) is being deleted.
 		// So, we are tracking deletes of MC only to avoid duplication.
 		deleteClusterNode = true
+		deleteAddonStatusCache(clusterName, "")
+		stopPendingDelete(clusterName)
+		mux.Lock()
+		updateHostingEdge(ctx, clusterName, "")
+		deleteHostingEdgesTo(ctx, clusterName)
+		mux.Unlock()
 		klog.V(3).Infof("Received delete for %s. Deleting Cluster resource %s and all resources from the DB", kind,
 			clusterName)
 
 	case "ManagedClusterAddOn":
 		clusterName = obj.(*unstructured.Unstructured).GetNamespace() // Namespace reflects the name of the cluster
+		processAddonDelete(ctx, obj.(*unstructured.Unstructured))
 		// When ManagedClusterAddOn (MCA) is deleted, search is disabled in the cluster. So, we delete the resources
-		// and edges for that cluster from db. But the cluster node is kept until MC is deleted.
-		deleteClusterNode = false
+		// and edges for that cluster from db, after the grace period if enabled. But the cluster node is kept until
+		// MC is deleted.
 		klog.V(3).Infof("Received delete for %s %s. Deleting Cluster resources and edges for cluster %s from the DB",
 			name, kind, clusterName)
+		softDeleteCluster(ctx, clusterName)
+		return
 
 	case "ManagedClusterInfo":
 		klog.V(4).Infof("No delete cluster actions for kind: %s", kind)
@@ -413,19 +521,13 @@ func findStaleClusterResources(ctx context.Context, dynamicClient dynamic.Interf
 
 }
 
-// find the enabled addons from the cluster labels
-func getEnabledAddons(labelMap map[string]interface{}) map[string]interface{} {
-
-	enabledAddons := make(map[string]interface{}, len(allAddons))
-	for _, addon := range allAddons {
-		addonLabelName := "feature.open-cluster-management.io/addon-" + addon
-		if _, ok := labelMap[addonLabelName]; ok {
-			enabledAddons[addon] = "true"
-		} else {
-			enabledAddons[addon] = "false"
-		}
+// Removes the status of a deleted addon from the Cluster node.
+func processAddonDelete(ctx context.Context, obj *unstructured.Unstructured) {
+	mux.Lock()
+	defer mux.Unlock()
+	if resource, changed := transformManagedClusterAddon(obj, true); changed {
+		dao.UpsertCluster(ctx, resource)
 	}
-	return enabledAddons
 }
 
 func checkError(err error, logMessage string) {
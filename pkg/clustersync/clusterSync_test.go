@@ -15,6 +15,7 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/jackc/pgx/v4"
 	"github.com/pashagolub/pgxmock"
+	"github.com/stolostron/search-indexer/pkg/config"
 	"github.com/stolostron/search-indexer/pkg/database"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -105,10 +106,14 @@ func initializeVars() {
 			"search-collector":            "true",
 			"work-manager":                "false",
 		},
+		"addonStatus": map[string]string{
+			"search-collector": "available",
+		},
 		"apigroup":            managedClusterInfoApiGrp,
 		"kind_plural":         "managedclusterinfos",
 		"cpu":                 0,
 		"created":             "0001-01-01T00:00:00Z",
+		"hubAcceptsClient":    false,
 		"kind":                "Cluster",
 		"kubernetesVersion":   "",
 		"memory":              "0",
@@ -168,6 +173,18 @@ func Test_ProcessClusterUpsert_ManagedClusterInfo(t *testing.T) {
 	props["apiEndpoint"] = ""
 	props["consoleURL"] = ""
 	props["nodes"] = 0
+	props["distribution"] = ""
+	props["kubeVendor"] = ""
+	props["cloudVendor"] = ""
+	props["nodesReady"] = 0
+	props["nodeRoles"] = []string{}
+	props["region"] = ""
+	props["controlPlaneNodes"] = 0
+	props["controlPlaneCPU"] = 0
+	props["controlPlaneMemory"] = 0
+	props["workerNodes"] = 0
+	props["workerCPU"] = 0
+	props["workerMemory"] = 0
 	existingCluster["Properties"] = props
 	expectedProps, _ := json.Marshal(existingCluster["Properties"])
 
@@ -241,11 +258,17 @@ func Test_ProcessClusterDeleteOnMC(t *testing.T) {
 	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
 		_ = mockConn.Close(ctx)
 	}(mockConn, context.Background())
-	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."resources" WHERE (("cluster" = 'name-foo') AND ("uid" != 'cluster__name-foo'))`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE ("cluster" = 'name-foo')`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectCommit()
 
+	// hostedBy edges from the clusters hosted by the deleted cluster.
+	mockPool.EXPECT().Exec(gomock.Any(),
+		gomock.Eq(`DELETE FROM "search"."edges" WHERE (("destid" = 'cluster__name-foo') AND ("edgetype" = 'hostedBy') AND ("cluster" = ''))`),
+		gomock.Eq([]interface{}{}),
+	).Return(nil, nil)
+
 	mockPool.EXPECT().Exec(gomock.Any(),
 		gomock.Eq(`DELETE FROM "search"."resources" WHERE ("uid" = 'cluster__name-foo')`),
 		gomock.Eq([]interface{}{}),
@@ -279,7 +302,7 @@ func Test_ProcessClusterDeleteOnMCASearch(t *testing.T) {
 	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
 		_ = mockConn.Close(ctx)
 	}(mockConn, context.Background())
-	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."resources" WHERE (("cluster" = 'name-foo') AND ("uid" != 'cluster__name-foo'))`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE ("cluster" = 'name-foo')`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectCommit()
@@ -355,11 +378,15 @@ func Test_DeleteStaleClustersResources(t *testing.T) {
 	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
 		_ = mockConn.Close(ctx)
 	}(mockConn, context.Background())
-	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."resources" WHERE (("cluster" = 'name-foo') AND ("uid" != 'cluster__name-foo'))`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE ("cluster" = 'name-foo')`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectCommit()
 
+	mockPool.EXPECT().Exec(gomock.Any(),
+		gomock.Eq(`DELETE FROM "search"."edges" WHERE (("destid" = 'cluster__name-foo') AND ("edgetype" = 'hostedBy') AND ("cluster" = ''))`),
+		gomock.Eq([]interface{}{}),
+	).Return(nil, nil)
 	mockPool.EXPECT().Exec(gomock.Any(),
 		gomock.Eq(`DELETE FROM "search"."resources" WHERE ("uid" = 'cluster__name-foo')`),
 		gomock.Eq([]interface{}{}),
@@ -460,11 +487,15 @@ func Test_DeleteStaleClustersResources_DB_Outage(t *testing.T) {
 	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
 		_ = mockConn.Close(ctx)
 	}(mockConn, context.Background())
-	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, fakeErr).Times(1).Return(mockConn, nil).Times(1) // return mock error
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, fakeErr).Times(1).Return(mockConn, nil).Times(1) // return mock error
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."resources" WHERE (("cluster" = 'name-foo') AND ("uid" != 'cluster__name-foo'))`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE ("cluster" = 'name-foo')`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectCommit()
 
+	mockPool.EXPECT().Exec(gomock.Any(),
+		gomock.Eq(`DELETE FROM "search"."edges" WHERE (("destid" = 'cluster__name-foo') AND ("edgetype" = 'hostedBy') AND ("cluster" = ''))`),
+		gomock.Eq([]interface{}{}),
+	).Return(nil, nil)
 	mockPool.EXPECT().Exec(gomock.Any(),
 		gomock.Eq(`DELETE FROM "search"."resources" WHERE ("uid" = 'cluster__name-foo')`),
 		gomock.Eq([]interface{}{}),
@@ -499,6 +530,10 @@ func Test_syncClusters_ContextCanceled(t *testing.T) {
 	defer restore()
 
 	initializeVars()
+	// The mock pool doesn't return the rows of the index usage report.
+	savedInterval := config.Cfg.IndexReportIntervalMS
+	config.Cfg.IndexReportIntervalMS = 0
+	defer func() { config.Cfg.IndexReportIntervalMS = savedInterval }()
 
 	// Set up the mock infrastructure
 	ctrl := gomock.NewController(t)
@@ -548,6 +583,10 @@ func Test_syncClusters_DeleteStaleError(t *testing.T) {
 	defer restore()
 
 	initializeVars()
+	// The mock pool doesn't return the rows of the index usage report.
+	savedInterval := config.Cfg.IndexReportIntervalMS
+	config.Cfg.IndexReportIntervalMS = 0
+	defer func() { config.Cfg.IndexReportIntervalMS = savedInterval }()
 
 	// Set up the mock infrastructure
 	ctrl := gomock.NewController(t)
@@ -0,0 +1,204 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clustersync
+
+import (
+	"context"
+	"sync"
+
+	"github.com/stolostron/search-indexer/pkg/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	klog "k8s.io/klog/v2"
+)
+
+// HostedCluster is read as unstructured to avoid a dependency on the HyperShift API.
+const hostedClusterGVR = "hostedclusters.v1beta1.hypershift.openshift.io"
+
+// Annotations used to detect hosted clusters.
+const (
+	klusterletDeployModeAnnotation = "import.open-cluster-management.io/klusterlet-deploy-mode"
+	hostingClusterAnnotation       = "import.open-cluster-management.io/hosting-cluster-name"
+	managedClusterNameAnnotation   = "cluster.open-cluster-management.io/managedcluster-name"
+	klusterletDeployModeHosted     = "Hosted"
+)
+
+const edgeTypeHostedBy = "hostedBy" // Cluster (hosted) -> Cluster (hosting)
+
+// Properties added to the Cluster node of hosted clusters.
+var hostedClusterProps = []string{"hostedCluster", "hostingCluster", "hostedClusterNamespace",
+	"hostedControlPlaneNamespace", "controlPlaneStatus"}
+
+// State of the HostedCluster for a ManagedCluster.
+type hostedClusterState struct {
+	namespace string
+	name      string
+	status    string
+}
+
+// Holds the HostedClusters from the informer, keyed by the name of the ManagedCluster.
+var hostedClusterCache = map[string]hostedClusterState{}
+var hostedClusterLock = sync.RWMutex{}
+
+// Holds the hosting cluster written to the database for the hostedBy edge of each hosted cluster.
+// Guarded by mux.
+var hostingEdgeCache = map[string]string{}
+
+// Get the name of the ManagedCluster for a HostedCluster.
+func hostedClusterManagedClusterName(obj *unstructured.Unstructured) string {
+	if name := obj.GetAnnotations()[managedClusterNameAnnotation]; name != "" {
+		return name
+	}
+	return obj.GetName()
+}
+
+// Records the HostedCluster in the cache and returns the name of the ManagedCluster.
+func updateHostedClusterCache(obj *unstructured.Unstructured, deleted bool) string {
+	clusterName := hostedClusterManagedClusterName(obj)
+	hostedClusterLock.Lock()
+	defer hostedClusterLock.Unlock()
+	if deleted {
+		delete(hostedClusterCache, clusterName)
+		return clusterName
+	}
+
+	status := struct {
+		Conditions []metav1.Condition `json:"conditions"`
+	}{}
+	statusObj, _, _ := unstructured.NestedMap(obj.Object, "status")
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(statusObj, &status); err != nil {
+		klog.Warningf("Failed to read the status of HostedCluster %s/%s. %s", obj.GetNamespace(), obj.GetName(), err)
+	}
+	hostedClusterCache[clusterName] = hostedClusterState{
+		namespace: obj.GetNamespace(),
+		name:      obj.GetName(),
+		status:    getAvailabilityStatus(status.Conditions),
+	}
+	return clusterName
+}
+
+// Find the name of the hosting cluster. HostedCluster resources on the hub are hosted by the local-cluster.
+func hostingClusterName(annotations map[string]string) string {
+	if name := annotations[hostingClusterAnnotation]; name != "" {
+		return name
+	}
+	if managedClusterStore != nil {
+		for _, item := range managedClusterStore.List() {
+			managedCluster, ok := item.(*unstructured.Unstructured)
+			if ok && managedCluster.GetLabels()["local-cluster"] == "true" {
+				return managedCluster.GetName()
+			}
+		}
+	}
+	return "local-cluster"
+}
+
+// Add the hosted control plane properties to the Cluster node. A cluster is hosted if the klusterlet is deployed in
+// Hosted mode or if a HostedCluster exists for the cluster. Returns the name of the hosting cluster, or an empty
+// string if the cluster isn't hosted.
+//
+//	hostedCluster               bool
+//	hostingCluster              string Sample: local-cluster
+//	hostedClusterNamespace      string Sample: clusters
+//	hostedControlPlaneNamespace string Sample: clusters-<name>
+//	controlPlaneStatus          string Sample: available
+func addHostedClusterProperties(props map[string]interface{}, clusterName string,
+	annotations map[string]string) string {
+	// Remove the existing values, so they aren't kept when the cluster is no longer hosted.
+	for _, key := range hostedClusterProps {
+		delete(props, key)
+	}
+	hostedClusterLock.RLock()
+	hostedCluster, hasHostedCluster := hostedClusterCache[clusterName]
+	hostedClusterLock.RUnlock()
+
+	if !hasHostedCluster && annotations[klusterletDeployModeAnnotation] != klusterletDeployModeHosted {
+		return ""
+	}
+	hostingCluster := hostingClusterName(annotations)
+	props["hostedCluster"] = true
+	props["hostingCluster"] = hostingCluster
+	if hasHostedCluster {
+		props["hostedClusterNamespace"] = hostedCluster.namespace
+		props["hostedControlPlaneNamespace"] = hostedCluster.namespace + "-" + hostedCluster.name
+		props["controlPlaneStatus"] = hostedCluster.status
+	}
+	return hostingCluster
+}
+
+// Get the annotations of the ManagedCluster from the informer store.
+func managedClusterAnnotations(clusterName string) map[string]string {
+	if managedClusterStore == nil {
+		return nil
+	}
+	item, exists, err := managedClusterStore.GetByKey(clusterName)
+	if err != nil || !exists {
+		return nil
+	}
+	if managedCluster, ok := item.(*unstructured.Unstructured); ok {
+		return managedCluster.GetAnnotations()
+	}
+	return nil
+}
+
+// Replace the hostedBy edge from the hosted cluster. An empty hosting cluster removes the edge.
+// Must be called with mux locked.
+func updateHostingEdge(ctx context.Context, clusterName, hostingCluster string) {
+	if hostingEdgeCache[clusterName] == hostingCluster {
+		return
+	}
+	sourceUID := "cluster__" + clusterName
+	edges := make([]model.Edge, 0, 1)
+	if hostingCluster != "" {
+		edges = append(edges, model.Edge{SourceUID: sourceUID, SourceKind: "Cluster", EdgeType: edgeTypeHostedBy,
+			DestUID: "cluster__" + hostingCluster, DestKind: "Cluster"})
+	}
+	if err := dao.ReplaceHubEdges(ctx, sourceUID, edgeTypeHostedBy, edges); err != nil {
+		klog.Warningf("Error updating %s edge for cluster %s. %s", edgeTypeHostedBy, clusterName, err)
+		return
+	}
+	if hostingCluster == "" {
+		delete(hostingEdgeCache, clusterName)
+	} else {
+		hostingEdgeCache[clusterName] = hostingCluster
+	}
+}
+
+// Delete the hostedBy edges to a hosting cluster that was deleted. The hosted clusters keep their annotation, so the
+// edges are written again by updateHostingEdge if the hosting cluster comes back.
+// Must be called with mux locked.
+func deleteHostingEdgesTo(ctx context.Context, hostingCluster string) {
+	if err := dao.DeleteHubEdgesTo(ctx, "cluster__"+hostingCluster, edgeTypeHostedBy); err != nil {
+		klog.Warningf("Error deleting %s edges to cluster %s. %s", edgeTypeHostedBy, hostingCluster, err)
+		return
+	}
+	for clusterName, hosting := range hostingEdgeCache {
+		if hosting == hostingCluster {
+			delete(hostingEdgeCache, clusterName)
+		}
+	}
+}
+
+// Update the Cluster node and the hostedBy edge when a HostedCluster changes.
+func processHostedClusterEvent(ctx context.Context, obj interface{}, deleted bool) {
+	mux.Lock()
+	defer mux.Unlock()
+	clusterName := updateHostedClusterCache(obj.(*unstructured.Unstructured), deleted)
+
+	// The Cluster node is created from the ManagedCluster. Wait for it if we don't have it yet.
+	props, ok := copyClusterProps(clusterName)
+	if !ok {
+		klog.V(4).Infof("Cluster node for %s not found in cache. Skipping HostedCluster update.", clusterName)
+		return
+	}
+	hostingCluster := addHostedClusterProperties(props, clusterName, managedClusterAnnotations(clusterName))
+
+	dao.UpsertCluster(ctx, model.Resource{
+		Kind:           "Cluster",
+		UID:            string("cluster__" + clusterName),
+		Properties:     props,
+		ResourceString: "managedclusterinfos", // Maps rbac to ManagedClusterInfo
+	})
+	updateHostingEdge(ctx, clusterName, hostingCluster)
+}
@@ -0,0 +1,104 @@
+// Copyright Contributors to the Open Cluster Management project
+package clustersync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/stolostron/search-indexer/pkg/database"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestHostedCluster(namespace, name string, available string) *unstructured.Unstructured {
+	hc := newTestUnstructured("hypershift.openshift.io/v1beta1", "HostedCluster", namespace, name, "")
+	hc.Object["status"] = map[string]interface{}{
+		"conditions": []interface{}{
+			map[string]interface{}{"type": "Available", "status": available, "reason": "AsExpected",
+				"lastTransitionTime": "2024-01-01T00:00:00Z", "message": ""},
+		},
+	}
+	return hc
+}
+
+// A HostedCluster on the hub maps to the ManagedCluster with the same name, hosted by the local-cluster.
+func Test_addHostedClusterProperties_HostedCluster(t *testing.T) {
+	managedClusterStore = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	defer func() { managedClusterStore = nil }()
+	_ = managedClusterStore.Add(newTestManagedCluster("hub", map[string]string{"local-cluster": "true"}))
+	defer func() { hostedClusterCache = map[string]hostedClusterState{} }()
+
+	clusterName := updateHostedClusterCache(newTestHostedCluster("clusters", "hcp1", "True"), false)
+	props := map[string]interface{}{}
+	hostingCluster := addHostedClusterProperties(props, clusterName, nil)
+
+	AssertEqual(t, clusterName, "hcp1", "Expected ManagedCluster name from the HostedCluster name.")
+	AssertEqual(t, hostingCluster, "hub", "Expected the local-cluster to be the hosting cluster.")
+	AssertEqual(t, props["hostedCluster"], true, "Expected hostedCluster property.")
+	AssertEqual(t, props["hostingCluster"], "hub", "Unexpected hostingCluster.")
+	AssertEqual(t, props["hostedClusterNamespace"], "clusters", "Unexpected hostedClusterNamespace.")
+	AssertEqual(t, props["hostedControlPlaneNamespace"], "clusters-hcp1", "Unexpected hostedControlPlaneNamespace.")
+	AssertEqual(t, props["controlPlaneStatus"], addonStatusAvailable, "Unexpected controlPlaneStatus.")
+}
+
+// Clusters imported in Hosted mode are detected from the ManagedCluster annotations.
+func Test_addHostedClusterProperties_Annotations(t *testing.T) {
+	props := map[string]interface{}{}
+	hostingCluster := addHostedClusterProperties(props, "hosted-1", map[string]string{
+		klusterletDeployModeAnnotation: klusterletDeployModeHosted,
+		hostingClusterAnnotation:       "hosting-1",
+	})
+
+	AssertEqual(t, hostingCluster, "hosting-1", "Expected hosting cluster from annotation.")
+	AssertEqual(t, props["hostingCluster"], "hosting-1", "Unexpected hostingCluster.")
+	_, found := props["controlPlaneStatus"]
+	AssertEqual(t, found, false, "Expected no controlPlaneStatus without a HostedCluster.")
+}
+
+// Properties from a previous state are removed when the cluster is no longer hosted.
+func Test_addHostedClusterProperties_NotHosted(t *testing.T) {
+	props := map[string]interface{}{"hostedCluster": true, "hostingCluster": "hub", "name": "c1"}
+	hostingCluster := addHostedClusterProperties(props, "c1", nil)
+
+	AssertEqual(t, hostingCluster, "", "Expected no hosting cluster.")
+	AssertEqual(t, len(props), 1, "Expected hosted cluster properties to be removed.")
+}
+
+func Test_updateHostingEdge(t *testing.T) {
+	defer func() { hostingEdgeCache = map[string]string{} }()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockPool := pgxpoolmock.NewMockPgxPool(ctrl)
+	dao = database.NewDAO(mockPool)
+
+	// Non-hosted clusters without an existing edge don't call the database.
+	updateHostingEdge(context.Background(), "c1", "")
+
+	// Edge already written isn't written again.
+	hostingEdgeCache["c2"] = "hub"
+	updateHostingEdge(context.Background(), "c2", "hub")
+}
+
+// Deleting a hosting cluster removes the hostedBy edges to it and forgets them in the cache.
+func Test_deleteHostingEdgesTo(t *testing.T) {
+	defer func() { hostingEdgeCache = map[string]string{} }()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockPool := pgxpoolmock.NewMockPgxPool(ctrl)
+	dao = database.NewDAO(mockPool)
+	hostingEdgeCache["c1"] = "hub"
+	hostingEdgeCache["c2"] = "other-hub"
+
+	mockPool.EXPECT().Exec(gomock.Any(),
+		gomock.Eq(`DELETE FROM "search"."edges" WHERE (("destid" = 'cluster__hub') AND ("edgetype" = 'hostedBy') AND ("cluster" = ''))`),
+		gomock.Eq([]interface{}{}),
+	).Return(nil, nil)
+
+	deleteHostingEdgesTo(context.Background(), "hub")
+
+	_, ok := hostingEdgeCache["c1"]
+	AssertEqual(t, ok, false, "Expected the edge to the deleted hosting cluster to be removed from the cache.")
+	AssertEqual(t, hostingEdgeCache["c2"], "other-hub", "Expected the edge to another hosting cluster to be kept.")
+}
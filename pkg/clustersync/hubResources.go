@@ -0,0 +1,278 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clustersync
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	klog "k8s.io/klog/v2"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	clusterv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+)
+
+const managedClusterSetGVR = "managedclustersets.v1beta2.cluster.open-cluster-management.io"
+const managedClusterSetBindingGVR = "managedclustersetbindings.v1beta2.cluster.open-cluster-management.io"
+const placementGVR = "placements.v1beta1.cluster.open-cluster-management.io"
+const placementDecisionGVR = "placementdecisions.v1beta1.cluster.open-cluster-management.io"
+const clusterApiGrp = "cluster.open-cluster-management.io"
+
+// Edge types from the hub resources.
+const (
+	edgeTypeContains = "contains" // ManagedClusterSet -> Cluster
+	edgeTypeUses     = "uses"     // ManagedClusterSetBinding -> ManagedClusterSet
+	edgeTypeOwnedBy  = "ownedBy"  // PlacementDecision -> Placement
+	edgeTypeSelects  = "selects"  // PlacementDecision -> Cluster
+)
+
+// Stores from the informers, used to resolve the ManagedClusterSet members.
+var managedClusterStore cache.Store
+var clusterSetStore cache.Store
+
+// Holds the last state written to the database for each hub resource. Used to skip unchanged updates.
+type hubResourceState struct {
+	props map[string]interface{}
+	edges []model.Edge
+}
+
+var hubResourceCache = map[string]hubResourceState{}
+var hubMux sync.Mutex
+
+// Build the UID for a hub resource. Sample: placement__<namespace>/<name>
+func hubResourceUID(kind, namespace, name string) string {
+	if namespace == "" {
+		return strings.ToLower(kind) + "__" + name
+	}
+	return strings.ToLower(kind) + "__" + namespace + "/" + name
+}
+
+// Transform a ManagedClusterSet, ManagedClusterSetBinding, Placement, or PlacementDecision into a hub resource and
+// the edges from the resource.
+func transformHubResource(obj *unstructured.Unstructured) (model.Resource, []model.Edge, bool) {
+	kind := obj.GetKind()
+	uid := hubResourceUID(kind, obj.GetNamespace(), obj.GetName())
+
+	props := map[string]interface{}{
+		"kind":                kind,
+		"kind_plural":         strings.ToLower(kind) + "s",
+		"name":                obj.GetName(),
+		"apigroup":            clusterApiGrp,
+		"apiversion":          strings.TrimPrefix(obj.GetAPIVersion(), clusterApiGrp+"/"),
+		"created":             obj.GetCreationTimestamp().UTC().Format(time.RFC3339),
+		"_hubClusterResource": true,
+	}
+	if obj.GetNamespace() != "" {
+		props["namespace"] = obj.GetNamespace()
+	}
+	if len(obj.GetLabels()) > 0 {
+		labelMap := make(map[string]interface{}, len(obj.GetLabels()))
+		for key, val := range obj.GetLabels() {
+			labelMap[key] = val
+		}
+		props["label"] = labelMap
+	}
+
+	j, err := json.Marshal(obj)
+	if err != nil {
+		klog.Warningf("Error marshalling %s from Informer. %s", kind, err)
+		return model.Resource{}, nil, false
+	}
+
+	edges := make([]model.Edge, 0)
+	switch kind {
+	case "ManagedClusterSet":
+		clusterSet := clusterv1beta2.ManagedClusterSet{}
+		if err = json.Unmarshal(j, &clusterSet); err != nil {
+			klog.Warning("Failed to Unmarshal ManagedClusterSet ", err)
+			return model.Resource{}, nil, false
+		}
+		props["selectorType"] = string(clusterSet.Spec.ClusterSelector.SelectorType)
+		for _, cluster := range clusterSetMembers(&clusterSet) {
+			edges = append(edges, model.Edge{SourceUID: uid, SourceKind: kind, EdgeType: edgeTypeContains,
+				DestUID: "cluster__" + cluster, DestKind: "Cluster"})
+		}
+
+	case "ManagedClusterSetBinding":
+		binding := clusterv1beta2.ManagedClusterSetBinding{}
+		if err = json.Unmarshal(j, &binding); err != nil {
+			klog.Warning("Failed to Unmarshal ManagedClusterSetBinding ", err)
+			return model.Resource{}, nil, false
+		}
+		props["clusterSet"] = binding.Spec.ClusterSet
+		edges = append(edges, model.Edge{SourceUID: uid, SourceKind: kind, EdgeType: edgeTypeUses,
+			DestUID: hubResourceUID("ManagedClusterSet", "", binding.Spec.ClusterSet), DestKind: "ManagedClusterSet"})
+
+	case "Placement":
+		placement := clusterv1beta1.Placement{}
+		if err = json.Unmarshal(j, &placement); err != nil {
+			klog.Warning("Failed to Unmarshal Placement ", err)
+			return model.Resource{}, nil, false
+		}
+		if len(placement.Spec.ClusterSets) > 0 {
+			props["clusterSets"] = placement.Spec.ClusterSets
+		}
+		props["numberOfSelectedClusters"] = int64(placement.Status.NumberOfSelectedClusters)
+
+	case "PlacementDecision":
+		decision := clusterv1beta1.PlacementDecision{}
+		if err = json.Unmarshal(j, &decision); err != nil {
+			klog.Warning("Failed to Unmarshal PlacementDecision ", err)
+			return model.Resource{}, nil, false
+		}
+		props["decisions"] = int64(len(decision.Status.Decisions))
+		if placementName, ok := decision.GetLabels()[clusterv1beta1.PlacementLabel]; ok {
+			props["placement"] = placementName
+			edges = append(edges, model.Edge{SourceUID: uid, SourceKind: kind, EdgeType: edgeTypeOwnedBy,
+				DestUID: hubResourceUID("Placement", decision.GetNamespace(), placementName), DestKind: "Placement"})
+		}
+		for _, d := range decision.Status.Decisions {
+			if d.ClusterName == "" {
+				continue
+			}
+			edges = append(edges, model.Edge{SourceUID: uid, SourceKind: kind, EdgeType: edgeTypeSelects,
+				DestUID: "cluster__" + d.ClusterName, DestKind: "Cluster"})
+		}
+
+	default:
+		klog.Warning("Received unknown hub resource kind. ", kind)
+		return model.Resource{}, nil, false
+	}
+
+	resource := model.Resource{
+		Kind:           kind,
+		UID:            uid,
+		Properties:     props,
+		ResourceString: props["kind_plural"].(string),
+	}
+	return resource, edges, true
+}
+
+// Find the names of the ManagedClusters selected by the ManagedClusterSet.
+func clusterSetMembers(clusterSet *clusterv1beta2.ManagedClusterSet) []string {
+	members := make([]string, 0)
+	if managedClusterStore == nil {
+		return members
+	}
+
+	var selector labels.Selector
+	switch clusterSet.Spec.ClusterSelector.SelectorType {
+	case clusterv1beta2.LabelSelector:
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(clusterSet.Spec.ClusterSelector.LabelSelector)
+		if err != nil {
+			klog.Warningf("Invalid label selector in ManagedClusterSet %s. %s", clusterSet.GetName(), err)
+			return members
+		}
+	default: // ExclusiveClusterSetLabel
+		selector = labels.SelectorFromSet(labels.Set{clusterv1beta2.ClusterSetLabel: clusterSet.GetName()})
+	}
+
+	for _, item := range managedClusterStore.List() {
+		managedCluster, ok := item.(*unstructured.Unstructured)
+		if ok && selector.Matches(labels.Set(managedCluster.GetLabels())) {
+			members = append(members, managedCluster.GetName())
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Insert or update the hub resource and its edges.
+func processHubResourceUpsert(ctx context.Context, obj interface{}) {
+	hubMux.Lock()
+	defer hubMux.Unlock()
+
+	resource, edges, ok := transformHubResource(obj.(*unstructured.Unstructured))
+	if !ok {
+		return
+	}
+	if existing, found := hubResourceCache[resource.UID]; found &&
+		reflect.DeepEqual(existing.props, resource.Properties) && reflect.DeepEqual(existing.edges, edges) {
+		klog.V(4).Infof("Hub resource %s already exists in DB and is up to date.", resource.UID)
+		return
+	}
+	if err := dao.UpsertHubResource(ctx, resource, edges); err != nil {
+		klog.Warningf("Error inserting/updating hub resource %s. %s", resource.UID, err)
+		return
+	}
+	hubResourceCache[resource.UID] = hubResourceState{props: resource.Properties, edges: edges}
+}
+
+// Delete the hub resource and its edges.
+func processHubResourceDelete(ctx context.Context, obj interface{}) {
+	hubMux.Lock()
+	defer hubMux.Unlock()
+
+	u := obj.(*unstructured.Unstructured)
+	uid := hubResourceUID(u.GetKind(), u.GetNamespace(), u.GetName())
+	if err := dao.DeleteHubResource(ctx, uid); err != nil {
+		klog.Warningf("Error deleting hub resource %s. %s", uid, err)
+		return
+	}
+	delete(hubResourceCache, uid)
+
+	// Edges to the deleted resource were also deleted. Clear the cached state of the resources with those edges,
+	// so the edges are written again if the resource is recreated.
+	for cachedUID, state := range hubResourceCache {
+		for _, edge := range state.edges {
+			if edge.DestUID == uid {
+				delete(hubResourceCache, cachedUID)
+				break
+			}
+		}
+	}
+}
+
+// Delay to batch the ManagedCluster changes, like the initial list of the informer, before refreshing the
+// ManagedClusterSets.
+var clusterSetRefreshDelay = 2 * time.Second
+var clusterSetRefreshPending bool
+var clusterSetRefreshLock sync.Mutex
+
+// Refresh the ManagedClusterSets once for the ManagedCluster changes within clusterSetRefreshDelay.
+func scheduleClusterSetRefresh(ctx context.Context) {
+	clusterSetRefreshLock.Lock()
+	defer clusterSetRefreshLock.Unlock()
+	if clusterSetRefreshPending {
+		return
+	}
+	clusterSetRefreshPending = true
+	time.AfterFunc(clusterSetRefreshDelay, func() {
+		clusterSetRefreshLock.Lock()
+		clusterSetRefreshPending = false
+		clusterSetRefreshLock.Unlock()
+		if ctx.Err() == nil {
+			refreshClusterSets(ctx)
+		}
+	})
+}
+
+// True if the ManagedCluster labels changed, which can change the ManagedClusterSet members.
+func clusterLabelsChanged(prev, next interface{}) bool {
+	prevCluster, ok := prev.(*unstructured.Unstructured)
+	if !ok {
+		return true
+	}
+	return !reflect.DeepEqual(prevCluster.GetLabels(), next.(*unstructured.Unstructured).GetLabels())
+}
+
+// The ManagedClusterSet members depend on the ManagedCluster labels, so update the ManagedClusterSet edges when a
+// ManagedCluster changes. Unchanged ManagedClusterSets are skipped by processHubResourceUpsert.
+func refreshClusterSets(ctx context.Context) {
+	if clusterSetStore == nil {
+		return
+	}
+	for _, clusterSet := range clusterSetStore.List() {
+		processHubResourceUpsert(ctx, clusterSet)
+	}
+}
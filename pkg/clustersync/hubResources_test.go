@@ -0,0 +1,143 @@
+// Copyright Contributors to the Open Cluster Management project
+package clustersync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/stolostron/search-indexer/pkg/database"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestManagedCluster(name string, labels map[string]string) *unstructured.Unstructured {
+	mc := newTestUnstructured(managedclustergroupAPIVersion, "ManagedCluster", "", name, "")
+	mc.SetLabels(labels)
+	return mc
+}
+
+func Test_transformHubResource_ManagedClusterSet(t *testing.T) {
+	managedClusterStore = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	defer func() { managedClusterStore = nil }()
+	_ = managedClusterStore.Add(newTestManagedCluster("cluster-b", map[string]string{
+		"cluster.open-cluster-management.io/clusterset": "set1"}))
+	_ = managedClusterStore.Add(newTestManagedCluster("cluster-a", map[string]string{
+		"cluster.open-cluster-management.io/clusterset": "set1"}))
+	_ = managedClusterStore.Add(newTestManagedCluster("cluster-c", map[string]string{
+		"cluster.open-cluster-management.io/clusterset": "set2"}))
+
+	obj := newTestUnstructured("cluster.open-cluster-management.io/v1beta2", "ManagedClusterSet", "", "set1", "")
+
+	resource, edges, ok := transformHubResource(obj)
+
+	AssertEqual(t, ok, true, "Expected ManagedClusterSet to be transformed.")
+	AssertEqual(t, resource.UID, "managedclusterset__set1", "Unexpected UID.")
+	AssertEqual(t, resource.Properties["kind_plural"], "managedclustersets", "Unexpected kind_plural.")
+	AssertEqual(t, resource.Properties["apiversion"], "v1beta2", "Unexpected apiversion.")
+	AssertEqual(t, resource.Properties["_hubClusterResource"], true, "Expected hub cluster resource.")
+	AssertEqual(t, len(edges), 2, "Expected an edge to each cluster in the set.")
+	AssertEqual(t, edges[0].DestUID, "cluster__cluster-a", "Unexpected edge destination.")
+	AssertEqual(t, edges[1].DestUID, "cluster__cluster-b", "Unexpected edge destination.")
+	AssertEqual(t, edges[0].EdgeType, edgeTypeContains, "Unexpected edge type.")
+}
+
+func Test_transformHubResource_ManagedClusterSetLabelSelector(t *testing.T) {
+	managedClusterStore = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	defer func() { managedClusterStore = nil }()
+	_ = managedClusterStore.Add(newTestManagedCluster("cluster-a", map[string]string{"env": "prod"}))
+	_ = managedClusterStore.Add(newTestManagedCluster("cluster-b", map[string]string{"env": "dev"}))
+
+	obj := newTestUnstructured("cluster.open-cluster-management.io/v1beta2", "ManagedClusterSet", "", "prod", "")
+	obj.Object["spec"] = map[string]interface{}{
+		"clusterSelector": map[string]interface{}{
+			"selectorType":  "LabelSelector",
+			"labelSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"env": "prod"}},
+		},
+	}
+
+	_, edges, _ := transformHubResource(obj)
+
+	AssertEqual(t, len(edges), 1, "Expected an edge to the cluster matching the label selector.")
+	AssertEqual(t, edges[0].DestUID, "cluster__cluster-a", "Unexpected edge destination.")
+}
+
+func Test_transformHubResource_PlacementDecision(t *testing.T) {
+	obj := newTestUnstructured("cluster.open-cluster-management.io/v1beta1", "PlacementDecision", "ns1", "p1-decision-1", "")
+	obj.SetLabels(map[string]string{"cluster.open-cluster-management.io/placement": "p1"})
+	obj.Object["status"] = map[string]interface{}{
+		"decisions": []interface{}{
+			map[string]interface{}{"clusterName": "cluster-a", "reason": ""},
+			map[string]interface{}{"clusterName": "cluster-b", "reason": ""},
+		},
+	}
+
+	resource, edges, ok := transformHubResource(obj)
+
+	AssertEqual(t, ok, true, "Expected PlacementDecision to be transformed.")
+	AssertEqual(t, resource.UID, "placementdecision__ns1/p1-decision-1", "Unexpected UID.")
+	AssertEqual(t, resource.Properties["namespace"], "ns1", "Unexpected namespace.")
+	AssertEqual(t, resource.Properties["decisions"], int64(2), "Unexpected number of decisions.")
+	AssertEqual(t, len(edges), 3, "Expected edges to the placement and to each cluster.")
+	AssertEqual(t, edges[0].DestUID, "placement__ns1/p1", "Unexpected edge to placement.")
+	AssertEqual(t, edges[0].EdgeType, edgeTypeOwnedBy, "Unexpected edge type.")
+	AssertEqual(t, edges[1].DestUID, "cluster__cluster-a", "Unexpected edge to cluster.")
+	AssertEqual(t, edges[2].EdgeType, edgeTypeSelects, "Unexpected edge type.")
+}
+
+func Test_transformHubResource_ManagedClusterSetBinding(t *testing.T) {
+	obj := newTestUnstructured("cluster.open-cluster-management.io/v1beta2", "ManagedClusterSetBinding", "ns1", "set1", "")
+	obj.Object["spec"] = map[string]interface{}{"clusterSet": "set1"}
+
+	_, edges, _ := transformHubResource(obj)
+
+	AssertEqual(t, len(edges), 1, "Expected an edge to the ManagedClusterSet.")
+	AssertEqual(t, edges[0].DestUID, "managedclusterset__set1", "Unexpected edge destination.")
+}
+
+// An unchanged hub resource isn't written again.
+func Test_processHubResourceUpsert_SkipUnchanged(t *testing.T) {
+	defer func() { hubResourceCache = map[string]hubResourceState{} }()
+	obj := newTestUnstructured("cluster.open-cluster-management.io/v1beta1", "Placement", "ns1", "p1", "")
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockPool := pgxpoolmock.NewMockPgxPool(ctrl)
+	dao = database.NewDAO(mockPool)
+
+	resource, edges, _ := transformHubResource(obj)
+	hubResourceCache[resource.UID] = hubResourceState{props: resource.Properties, edges: edges}
+
+	// The mock pool fails the test if the database is called.
+	processHubResourceUpsert(context.Background(), obj)
+}
+
+// Only label changes can change the ManagedClusterSet members.
+func Test_clusterLabelsChanged(t *testing.T) {
+	prev := newTestManagedCluster("c1", map[string]string{"env": "dev"})
+
+	AssertEqual(t, clusterLabelsChanged(prev, newTestManagedCluster("c1", map[string]string{"env": "dev"})), false,
+		"Expected unchanged labels to skip the refresh.")
+	AssertEqual(t, clusterLabelsChanged(prev, newTestManagedCluster("c1", map[string]string{"env": "prod"})), true,
+		"Expected changed labels to refresh the ManagedClusterSets.")
+}
+
+// Should refresh the ManagedClusterSets once for the changes within the delay.
+func Test_scheduleClusterSetRefresh(t *testing.T) {
+	savedDelay, savedStore := clusterSetRefreshDelay, clusterSetStore
+	clusterSetRefreshDelay, clusterSetStore = 10*time.Millisecond, nil
+	defer func() { clusterSetRefreshDelay, clusterSetStore = savedDelay, savedStore }()
+
+	scheduleClusterSetRefresh(context.Background())
+	scheduleClusterSetRefresh(context.Background())
+	clusterSetRefreshLock.Lock()
+	AssertEqual(t, clusterSetRefreshPending, true, "Expected a pending refresh.")
+	clusterSetRefreshLock.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	clusterSetRefreshLock.Lock()
+	AssertEqual(t, clusterSetRefreshPending, false, "Expected the refresh to run after the delay.")
+	clusterSetRefreshLock.Unlock()
+}
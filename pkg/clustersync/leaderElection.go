@@ -4,9 +4,11 @@ package clustersync
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -15,7 +17,54 @@ import (
 	klog "k8s.io/klog/v2"
 )
 
+// Leadership status reported by the /leader endpoint.
+type LeaderStatus struct {
+	Identity    string     `json:"identity"`              // Name of this pod.
+	Leader      string     `json:"leader"`                // Name of the pod holding the lease.
+	IsLeader    bool       `json:"isLeader"`              // True if this pod is running the leader tasks.
+	LeaderSince *time.Time `json:"leaderSince,omitempty"` // Time this pod started the leader tasks.
+	Transitions int        `json:"transitions"`           // Leader changes observed by this pod.
+}
+
 var leader string
+var leaderStatus = LeaderStatus{}
+var leaderLock = sync.RWMutex{}
+
+// Get the current leadership status.
+func GetLeaderStatus() LeaderStatus {
+	leaderLock.RLock()
+	defer leaderLock.RUnlock()
+	status := leaderStatus
+	status.Identity = config.Cfg.PodName
+	status.Leader = leader
+	return status
+}
+
+// Record a new leader. Changes after the first leader observed are counted as transitions.
+func setLeader(currentId string) {
+	leaderLock.Lock()
+	defer leaderLock.Unlock()
+	if leader != "" && leader != currentId {
+		leaderStatus.Transitions++
+		metrics.LeaderTransitions.Inc()
+	}
+	leader = currentId
+}
+
+// Record when this pod starts or stops running the leader tasks.
+func setIsLeader(isLeader bool) {
+	leaderLock.Lock()
+	defer leaderLock.Unlock()
+	leaderStatus.IsLeader = isLeader
+	if isLeader {
+		now := time.Now()
+		leaderStatus.LeaderSince = &now
+		metrics.LeaderStatus.Set(1)
+	} else {
+		leaderStatus.LeaderSince = nil
+		metrics.LeaderStatus.Set(0)
+	}
+}
 
 func getNewLock(client *kubernetes.Clientset, lockname, podName, podNamespace string) *resourcelock.LeaseLock {
 	return &resourcelock.LeaseLock{
@@ -41,25 +90,29 @@ func runLeaderElection(ctx context.Context, lock *resourcelock.LeaseLock, runLea
 			leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
 				Lock:            lock,
 				ReleaseOnCancel: true, // Releases the lock on context cancel.
-				LeaseDuration:   15 * time.Second,
-				RenewDeadline:   10 * time.Second,
-				RetryPeriod:     2 * time.Second,
+				LeaseDuration:   time.Duration(config.Cfg.LeaseDurationMS) * time.Millisecond,
+				RenewDeadline:   time.Duration(config.Cfg.RenewDeadlineMS) * time.Millisecond,
+				RetryPeriod:     time.Duration(config.Cfg.RetryPeriodMS) * time.Millisecond,
 				Callbacks: leaderelection.LeaderCallbacks{
 					OnStartedLeading: func(c context.Context) {
 						klog.Info("I'm the leader! Starting leader activities.")
-						leader = config.Cfg.PodName
+						setLeader(config.Cfg.PodName)
+						setIsLeader(true)
+						start := time.Now()
 						runLeaderTasks(c)
+						metrics.LeaderTasksDuration.Observe(time.Since(start).Seconds())
 					},
 					OnStoppedLeading: func() {
-						if leader == config.Cfg.PodName {
+						if GetLeaderStatus().IsLeader {
 							klog.Info("I'm no longer the leader.")
 						}
+						setIsLeader(false)
 					},
 					OnNewLeader: func(currentId string) {
 						if currentId != config.Cfg.PodName {
 							klog.Infof("Leader is %s", currentId)
-							leader = currentId
 						}
+						setLeader(currentId)
 					},
 				},
 			})
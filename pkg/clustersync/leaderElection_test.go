@@ -53,3 +53,38 @@ func Test_runLeaderElectionAndCancel(t *testing.T) {
 		t.Error("Expected leader process to be cancelled.")
 	}
 }
+
+// Leader changes after the first leader are counted as transitions.
+func Test_setLeader(t *testing.T) {
+	resetLeader := func() {
+		leaderLock.Lock()
+		defer leaderLock.Unlock()
+		leader = ""
+		leaderStatus = LeaderStatus{}
+	}
+	resetLeader()
+	defer resetLeader()
+
+	setLeader("pod-a")
+	setLeader("pod-a")
+	AssertEqual(t, GetLeaderStatus().Transitions, 0, "Expected no transitions for the first leader.")
+
+	setLeader("pod-b")
+	status := GetLeaderStatus()
+	AssertEqual(t, status.Leader, "pod-b", "Expected new leader.")
+	AssertEqual(t, status.Transitions, 1, "Expected a leader transition.")
+}
+
+func Test_setIsLeader(t *testing.T) {
+	defer func() { leaderStatus = LeaderStatus{} }()
+
+	setIsLeader(true)
+	status := GetLeaderStatus()
+	AssertEqual(t, status.IsLeader, true, "Expected pod to be the leader.")
+	AssertEqual(t, status.LeaderSince != nil, true, "Expected leaderSince to be set.")
+
+	setIsLeader(false)
+	status = GetLeaderStatus()
+	AssertEqual(t, status.IsLeader, false, "Expected pod to not be the leader.")
+	AssertEqual(t, status.LeaderSince == nil, true, "Expected leaderSince to be cleared.")
+}
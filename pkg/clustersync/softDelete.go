@@ -0,0 +1,146 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package clustersync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/database"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	klog "k8s.io/klog/v2"
+)
+
+// Soft delete of the cluster data, enabled with CLUSTER_DELETE_GRACE_MS.
+//
+// Deleting the search-collector ManagedClusterAddOn is often a transient reinstall. Instead of deleting the resources
+// and edges of the cluster, the Cluster node and the resources of the cluster are marked with the time to purge them
+// (database.PendingDeleteProperty), and consumers exclude the marked resources. The data is purged when the grace
+// period elapses, unless the addon is created again first. The time is stored in the database, so a new leader schedules the purge again when it finds
+// the stale cluster data at startup.
+//
+// The annotation search.open-cluster-management.io/delete-grace-period on the ManagedCluster overrides the grace
+// period of a cluster, as a duration like 2h. Deleting the ManagedCluster always deletes the data immediately.
+
+const deleteGracePeriodAnnotation = "search.open-cluster-management.io/delete-grace-period"
+
+// Timers that purge the data of the clusters pending delete, keyed by cluster name.
+var pendingDeletes = map[string]*time.Timer{}
+var pendingDeletesLock = sync.Mutex{}
+
+// Get the grace period of the cluster from the ManagedCluster annotation or CLUSTER_DELETE_GRACE_MS.
+func deleteGracePeriod(clusterName string) time.Duration {
+	gracePeriod := time.Duration(config.Cfg.ClusterDeleteGraceMS) * time.Millisecond
+	if value, ok := managedClusterAnnotations(clusterName)[deleteGracePeriodAnnotation]; ok {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 {
+			klog.Warningf("Invalid %s annotation %q on ManagedCluster %s. Using the default grace period %s.",
+				deleteGracePeriodAnnotation, value, clusterName, gracePeriod)
+			return gracePeriod
+		}
+		gracePeriod = duration
+	}
+	return gracePeriod
+}
+
+// Delete the resources and edges of the cluster after the grace period. The Cluster node is kept.
+func softDeleteCluster(ctx context.Context, clusterName string) {
+	gracePeriod := deleteGracePeriod(clusterName)
+	if gracePeriod <= 0 {
+		metrics.ClusterDeletes.WithLabelValues("immediate").Inc()
+		dao.DeleteClusterAndResources(ctx, clusterName, false)
+		return
+	}
+	mux.Lock()
+	purgeAt, found, err := dao.MarkClusterPendingDelete(ctx, clusterName, time.Now().Add(gracePeriod))
+	mux.Unlock()
+	if err != nil || !found {
+		// Without the marks, the data can't be hidden or the purge scheduled again by a new leader.
+		klog.Warningf("Unable to mark cluster %s pending delete. Deleting the cluster resources now.", clusterName)
+		metrics.ClusterDeletes.WithLabelValues("immediate").Inc()
+		dao.DeleteClusterAndResources(ctx, clusterName, false)
+		return
+	}
+	klog.V(2).Infof("Cluster %s is pending delete. Resources and edges will be deleted at %s.", clusterName,
+		purgeAt.Format(time.RFC3339))
+	metrics.ClusterDeletes.WithLabelValues("pending").Inc()
+	schedulePurge(ctx, clusterName, purgeAt)
+}
+
+// Start the timer to purge the data of the cluster, replacing the previous timer.
+func schedulePurge(ctx context.Context, clusterName string, purgeAt time.Time) {
+	pendingDeletesLock.Lock()
+	defer pendingDeletesLock.Unlock()
+	if timer, ok := pendingDeletes[clusterName]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(purgeAt), func() {
+		pendingDeletesLock.Lock()
+		current := pendingDeletes[clusterName] == timer
+		if current {
+			delete(pendingDeletes, clusterName)
+		}
+		pendingDeletesLock.Unlock()
+		// The leader that replaces this one schedules the purge again.
+		if current && ctx.Err() == nil {
+			purgeCluster(ctx, clusterName)
+		}
+	})
+	pendingDeletes[clusterName] = timer
+}
+
+// Delete the resources and edges of the cluster at the end of the grace period. The pending delete is cleared once
+// the resources are deleted.
+func purgeCluster(ctx context.Context, clusterName string) {
+	klog.V(2).Infof("Grace period for cluster %s ended. Deleting the cluster resources.", clusterName)
+	metrics.ClusterDeletes.WithLabelValues("purged").Inc()
+	dao.DeleteClusterAndResources(ctx, clusterName, false)
+}
+
+// Keep the data of the cluster when the search-collector addon is created again during the grace period.
+// Must be called with mux locked.
+func cancelSoftDelete(ctx context.Context, clusterName string) {
+	pendingDeletesLock.Lock()
+	timer, scheduled := pendingDeletes[clusterName]
+	if scheduled {
+		timer.Stop()
+		delete(pendingDeletes, clusterName)
+	}
+	pendingDeletesLock.Unlock()
+	dao.CancelClusterDelete(ctx, clusterName)
+	if !scheduled && !clusterPendingDelete(clusterName) {
+		return
+	}
+	cleared, err := dao.ClearClusterPendingDelete(ctx, clusterName)
+	if err != nil {
+		klog.Warningf("Error canceling the pending delete of cluster %s. %s", clusterName, err)
+		return
+	}
+	if cleared {
+		klog.V(2).Infof("The %s addon of cluster %s was created again. Canceled the pending delete.",
+			searchCollectorAddon, clusterName)
+		metrics.ClusterDeletes.WithLabelValues("canceled").Inc()
+	}
+}
+
+// Stop the timer of the cluster. Used when the data is deleted immediately.
+func stopPendingDelete(clusterName string) {
+	pendingDeletesLock.Lock()
+	defer pendingDeletesLock.Unlock()
+	if timer, ok := pendingDeletes[clusterName]; ok {
+		timer.Stop()
+		delete(pendingDeletes, clusterName)
+	}
+}
+
+// True if the Cluster node in the clusters cache is pending delete. A Cluster node that isn't cached yet after a
+// restart is checked by the next event of the addon, from the informer resync.
+func clusterPendingDelete(clusterName string) bool {
+	data, _ := database.ReadClustersCache("cluster__" + clusterName)
+	props, _ := data.(map[string]interface{})
+	_, pending := props[database.PendingDeleteProperty]
+	return pending
+}
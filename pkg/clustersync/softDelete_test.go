@@ -0,0 +1,112 @@
+// Copyright Contributors to the Open Cluster Management project
+package clustersync
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/database"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The annotation of the ManagedCluster overrides CLUSTER_DELETE_GRACE_MS.
+func Test_deleteGracePeriod(t *testing.T) {
+	savedGrace := config.Cfg.ClusterDeleteGraceMS
+	config.Cfg.ClusterDeleteGraceMS = 60 * 1000
+	managedClusterStore = cache.NewStore(cache.MetaNamespaceKeyFunc)
+	defer func() {
+		managedClusterStore = nil
+		config.Cfg.ClusterDeleteGraceMS = savedGrace
+	}()
+	protected := newTestManagedCluster("protected", nil)
+	protected.SetAnnotations(map[string]string{deleteGracePeriodAnnotation: "2h"})
+	invalid := newTestManagedCluster("invalid", nil)
+	invalid.SetAnnotations(map[string]string{deleteGracePeriodAnnotation: "later"})
+	_ = managedClusterStore.Add(protected)
+	_ = managedClusterStore.Add(invalid)
+
+	AssertEqual(t, deleteGracePeriod("protected"), 2*time.Hour, "Expected the grace period from the annotation.")
+	AssertEqual(t, deleteGracePeriod("invalid"), time.Minute, "Expected the default grace period.")
+	AssertEqual(t, deleteGracePeriod("other"), time.Minute, "Expected the default grace period.")
+}
+
+// Deleting the search-collector addon marks the cluster pending delete. Creating it again cancels the delete.
+func Test_softDeleteCluster_canceled(t *testing.T) {
+	savedGrace := config.Cfg.ClusterDeleteGraceMS
+	config.Cfg.ClusterDeleteGraceMS = 60 * 60 * 1000
+	defer func() { config.Cfg.ClusterDeleteGraceMS = savedGrace }()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockPool := pgxpoolmock.NewMockPgxPool(ctrl)
+	dao = database.NewDAO(mockPool)
+	defer database.DeleteClustersCache("cluster__name-foo")
+
+	pending := map[string]interface{}{"name": "name-foo", database.PendingDeleteProperty: "2026-01-01T10:00:00Z"}
+	markRows := pgxpoolmock.NewRows([]string{"data"}).AddRow(pending).ToPgxRows()
+	clearRows := pgxpoolmock.NewRows([]string{"data"}).AddRow(map[string]interface{}{"name": "name-foo"}).ToPgxRows()
+	gomock.InOrder(
+		mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster__name-foo", gomock.Any()).Return(markRows, nil),
+		mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), "name-foo", "2026-01-01T10:00:00Z").
+			Return(pgconn.CommandTag("UPDATE 5"), nil),
+		mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), "name-foo", "cluster__name-foo").
+			Return(pgconn.CommandTag("UPDATE 5"), nil),
+		mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster__name-foo").Return(clearRows, nil),
+	)
+
+	obj := newTestUnstructured(managedclusteraddongroupAPIVersion, "ManagedClusterAddOn", "name-foo",
+		"search-collector", "test-mca-uid")
+	processClusterDelete(context.Background(), obj)
+
+	_, scheduled := pendingDeletes["name-foo"]
+	AssertEqual(t, scheduled, true, "Expected the purge of cluster name-foo to be scheduled.")
+	AssertEqual(t, clusterPendingDelete("name-foo"), true, "Expected cluster name-foo to be pending delete.")
+
+	mux.Lock()
+	cancelSoftDelete(context.Background(), "name-foo")
+	mux.Unlock()
+
+	_, scheduled = pendingDeletes["name-foo"]
+	AssertEqual(t, scheduled, false, "Expected the purge of cluster name-foo to be canceled.")
+	AssertEqual(t, clusterPendingDelete("name-foo"), false, "Expected cluster name-foo not to be pending delete.")
+}
+
+// Without a Cluster node, the data is deleted immediately.
+func Test_softDeleteCluster_noClusterNode(t *testing.T) {
+	savedGrace := config.Cfg.ClusterDeleteGraceMS
+	config.Cfg.ClusterDeleteGraceMS = 60 * 60 * 1000
+	defer func() { config.Cfg.ClusterDeleteGraceMS = savedGrace }()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockPool := pgxpoolmock.NewMockPgxPool(ctrl)
+	dao = database.NewDAO(mockPool)
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster__name-bar", gomock.Any()).
+		Return(pgxpoolmock.NewRows([]string{"data"}).ToPgxRows(), nil)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."resources" WHERE (("cluster" = 'name-bar') AND ("uid" != 'cluster__name-bar'))`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE ("cluster" = 'name-bar')`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mockConn.ExpectCommit()
+
+	softDeleteCluster(context.Background(), "name-bar")
+
+	_, scheduled := pendingDeletes["name-bar"]
+	AssertEqual(t, scheduled, false, "Expected no purge scheduled for cluster name-bar.")
+	if err := mockConn.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %s", err)
+	}
+}
@@ -5,11 +5,13 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/url"
 	"os"
 	"strconv"
 
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/klog/v2"
 )
 
@@ -20,65 +22,127 @@ var Cfg = new()
 
 // Struct to hold our configuration
 type Config struct {
-	DBBatchSize         int // Batch size used to write to DB. Default: 2500
-	DBHealthCkeckPeriod int // Overrides pgxpool.Config{ HealthCheckPeriod } Default: 1 min
-	DBHost              string
-	DBMinConns          int32 // Overrides pgxpool.Config{ MinConns } Default: 2
-	DBMaxConns          int32 // Overrides pgxpool.Config{ MaxConns } Default: 10
-	DBMaxConnIdleTime   int   // Overrides pgxpool.Config{ MaxConnIdleTime } Default: 5 min
-	DBMaxConnLifeTime   int   // Overrides pgxpool.Config{ MaxConnLifetime } Default: 5 min
-	DBMaxConnLifeJitter int   // Overrides pgxpool.Config{ MaxConnLifetimeJitter } Default: 1 min
-	DBName              string
-	DBPass              string
-	DBPort              int
-	DBUser              string
-	DevelopmentMode     bool
-	HTTPTimeout         int // Timeout for http server connections. Default: 5 min
-	KubeClient          *kubernetes.Clientset
-	KubeConfigPath      string
-	MaxBackoffMS        int // Maximum backoff in ms to wait after db connection error
-	PodName             string
-	PodNamespace        string
-	ResyncPeriodMS      int    // Time in MS for the clusters informer. Default: 15 min.
-	RediscoverRateMS    int    // Time in MS we should check on cluster resource type
-	RequestLimit        int    // Max number of concurrent requests. Used to prevent from overloading the database
-	LargeRequestLimit   int    // Max number of large concurrent requests. Used to help control memory spikes
-	LargeRequestSize    int    // Size defining a large request. Used by large request limiter middleware to control large requests
-	ServerAddress       string // Web server address
-	SlowLog             int    // Log operations slower than the specified time in ms. Default: 1 sec
-	Version             string
+	ClusterDeleteChunkSize   int // Rows deleted by each statement of the cluster delete queue. Default: 10000
+	ClusterDeleteGraceMS     int // Time the data of a cluster is kept after its search addon is deleted. Default: 0
+	ClusterDeleteWorkers     int // Concurrent cluster deletes. The queue is disabled when 0. Default: 0
+	DBBatchSize              int // Batch size used to write to DB. Default: 2500
+	DBHealthCkeckPeriod      int // Overrides pgxpool.Config{ HealthCheckPeriod } Default: 1 min
+	DBHost                   string
+	DBMinConns               int32 // Overrides pgxpool.Config{ MinConns } Default: 2
+	DBMaxConns               int32 // Overrides pgxpool.Config{ MaxConns } Default: 10
+	DBMaxConnIdleTime        int   // Overrides pgxpool.Config{ MaxConnIdleTime } Default: 5 min
+	DBMaxConnLifeTime        int   // Overrides pgxpool.Config{ MaxConnLifetime } Default: 5 min
+	DBMaxConnLifeJitter      int   // Overrides pgxpool.Config{ MaxConnLifetimeJitter } Default: 1 min
+	DBHashPartitions         int   // Number of partitions with DB_PARTITION_MODE=hash. Default: 16
+	DBName                   string
+	DBPartitionMode          string // Partition the resources and edges by cluster, none, list or hash. Default: none
+	DBPass                   string
+	DBPort                   int
+	DBUser                   string
+	DevelopmentMode          bool
+	EdgeValidation           string // Check that edges reference known resources, off, warn or strict. Default: off
+	HTTPTimeout              int    // Timeout for http server connections. Default: 5 min
+	IndexConfigPath          string // File with the JSONB property indexes to reconcile at startup. Default: ""
+	IndexReportIntervalMS    int    // Time between reports of the index usage. Disabled when 0. Default: 1 hour
+	IndexingStatusIntervalMS int    // Minimum time between reports of the same indexing problem for a cluster. Default: 5 min
+	InterClusterEdgesMS      int    // Delay to batch changes before computing the interCluster edges. Disabled when 0. Default: 0
+	KubeClient               *kubernetes.Clientset
+	KubeConfigPath           string
+	LeaseDurationMS          int    // Leader election lease duration. Default: 15 sec
+	RenewDeadlineMS          int    // Time the leader retries to renew the lease before giving up. Default: 10 sec
+	RetryPeriodMS            int    // Time between leader election attempts. Default: 2 sec
+	LogFormat                string // Log format, text or json. Default: text
+	MaxBackoffMS             int    // Maximum backoff in ms to wait after db connection error
+	MaxPropertyBytes         int    // Max bytes of a property value as JSON. Unlimited when 0. Default: 0
+	MaxResourceBytes         int    // Max bytes of the resource data as JSON. Unlimited when 0. Default: 0
+	MetricsMaxClusters       int    // Max clusters with their own label in the per-cluster metrics. Default: 500
+	OrphanEdgeCleanupMS      int    // Time between deletes of the edges to missing resources. Disabled when 0. Default: 0
+	OversizePolicy           string // What to do with data over the size limits, reject, truncate or drop. Default: reject
+	OTLPEndpoint             string // OTLP endpoint to export traces. Tracing is disabled when empty. Default: ""
+	PodName                  string
+	PodNamespace             string
+	PropertySamples          int    // Values sampled for each property in search.property_catalog. Disabled when 0. Default: 0
+	PropertyTypesMode        string // Normalize the property types, off, configured or learned. Default: off
+	PropertyTypesPath        string // File with the configured property types. Default: ""
+	QuotaDataBytes           int    // Max bytes of resource data for each cluster. Unlimited when 0. Default: 0
+	QuotaEdges               int    // Max edges for each cluster. Unlimited when 0. Default: 0
+	QuotaResources           int    // Max resources for each cluster. Unlimited when 0. Default: 0
+	RedactionConfigPath      string // File with the property redaction policy. Redaction is disabled when empty. Default: ""
+	ResyncMode               string // How a resync is written, inplace or atomic (staged and swapped). Default: inplace
+	ResyncPeriodMS           int    // Time in MS for the clusters informer. Default: 15 min.
+	RediscoverRateMS         int    // Time in MS we should check on cluster resource type
+	RequestLimit             int    // Max number of concurrent requests. Used to prevent from overloading the database
+	LargeRequestLimit        int    // Max number of large concurrent requests. Used to help control memory spikes
+	LargeRequestSize         int    // Size defining a large request. Used by large request limiter middleware to control large requests
+	ServerAddress            string // Web server address
+	SlowLog                  int    // Log operations slower than the specified time in ms. Default: 1 sec
+	SlowQueryExplainMS       int    // Capture EXPLAIN plans for batches slower than this. Disabled when 0. Default: 0
+	SlowQueryExplainPct      int    // Percent of the slow batches sampled to capture EXPLAIN plans. Default: 10
+	SlowQueryPlans           int    // Max EXPLAIN plans kept for the /admin/slow-queries endpoint. Default: 50
+	Version                  string
 }
 
 // Reads config from environment.
 func new() *Config {
 	conf := &Config{
-		DBBatchSize: getEnvAsInt("DB_BATCH_SIZE", 2500),
-		DBHost:      getEnv("DB_HOST", "localhost"),
+		ClusterDeleteChunkSize: getEnvAsInt("CLUSTER_DELETE_CHUNK_SIZE", 10000),
+		ClusterDeleteGraceMS:   getEnvAsInt("CLUSTER_DELETE_GRACE_MS", 0), // Data is deleted immediately when 0.
+		ClusterDeleteWorkers:   getEnvAsInt("CLUSTER_DELETE_WORKERS", 0),  // The queue is disabled when 0.
+		DBBatchSize:            getEnvAsInt("DB_BATCH_SIZE", 2500),
+		DBHost:                 getEnv("DB_HOST", "localhost"),
 		// Postgres has 100 conns by default. Using 10 allows scaling indexer and api.
-		DBMaxConns:          getEnvAsInt32("DB_MAX_CONNS", int32(10)),          // 10     Overrides pgxpool default (4)
-		DBMaxConnIdleTime:   getEnvAsInt("DB_MAX_CONN_IDLE_TIME", 5*60*1000),   // 5 min, Overrides pgxpool default (30)
-		DBMaxConnLifeJitter: getEnvAsInt("DB_MAX_CONN_LIFE_JITTER", 1*60*1000), // 1 min, Overrides pgxpool default
-		DBMaxConnLifeTime:   getEnvAsInt("DB_MAX_CONN_LIFE_TIME", 5*60*1000),   // 5 min, Overrides pgxpool default (60)
-		DBMinConns:          getEnvAsInt32("DB_MIN_CONNS", int32(2)),           // 2      Overrides pgxpool default (0)
-		DBName:              getEnv("DB_NAME", ""),
-		DBPass:              getEnv("DB_PASS", ""),
-		DBPort:              getEnvAsInt("DB_PORT", 5432),
-		DBUser:              getEnv("DB_USER", ""),
-		DevelopmentMode:     DEVELOPMENT_MODE,                       // Don't read ENV. See config_development.go to enable.
-		HTTPTimeout:         getEnvAsInt("HTTP_TIMEOUT", 5*60*1000), // 5 min
-		KubeConfigPath:      getKubeConfigPath(),
+		DBMaxConns:               getEnvAsInt32("DB_MAX_CONNS", int32(10)),          // 10     Overrides pgxpool default (4)
+		DBMaxConnIdleTime:        getEnvAsInt("DB_MAX_CONN_IDLE_TIME", 5*60*1000),   // 5 min, Overrides pgxpool default (30)
+		DBMaxConnLifeJitter:      getEnvAsInt("DB_MAX_CONN_LIFE_JITTER", 1*60*1000), // 1 min, Overrides pgxpool default
+		DBMaxConnLifeTime:        getEnvAsInt("DB_MAX_CONN_LIFE_TIME", 5*60*1000),   // 5 min, Overrides pgxpool default (60)
+		DBMinConns:               getEnvAsInt32("DB_MIN_CONNS", int32(2)),           // 2      Overrides pgxpool default (0)
+		DBHashPartitions:         getEnvAsInt("DB_HASH_PARTITIONS", 16),
+		DBName:                   getEnv("DB_NAME", ""),
+		DBPartitionMode:          getEnv("DB_PARTITION_MODE", "none"),
+		DBPass:                   getEnv("DB_PASS", ""),
+		DBPort:                   getEnvAsInt("DB_PORT", 5432),
+		DBUser:                   getEnv("DB_USER", ""),
+		DevelopmentMode:          DEVELOPMENT_MODE,                                      // Don't read ENV. See config_development.go to enable.
+		EdgeValidation:           getEnv("EDGE_VALIDATION", "off"),                      // Edges aren't validated when off.
+		HTTPTimeout:              getEnvAsInt("HTTP_TIMEOUT", 5*60*1000),                // 5 min
+		IndexConfigPath:          getEnv("INDEX_CONFIG_PATH", ""),                       // Indexes aren't reconciled when empty.
+		IndexReportIntervalMS:    getEnvAsInt("INDEX_REPORT_INTERVAL_MS", 60*60*1000),   // 1 hour
+		IndexingStatusIntervalMS: getEnvAsInt("INDEXING_STATUS_INTERVAL_MS", 5*60*1000), // 5 min
+		InterClusterEdgesMS:      getEnvAsInt("INTER_CLUSTER_EDGES_MS", 0),              // interCluster edges aren't computed when 0.
+		KubeConfigPath:           getKubeConfigPath(),
+		LeaseDurationMS:          getEnvAsInt("LEASE_DURATION_MS", 15*1000), // 15 sec
+		RenewDeadlineMS:          getEnvAsInt("RENEW_DEADLINE_MS", 10*1000), // 10 sec
+		RetryPeriodMS:            getEnvAsInt("RETRY_PERIOD_MS", 2*1000),    // 2 sec
+		LogFormat:                getEnv("LOG_FORMAT", "text"),
 		// Use 5 min for delete cluster activities and 30 seconds for db reconnect retry
-		MaxBackoffMS:      getEnvAsInt("MAX_BACKOFF_MS", 5*60*1000), // 5 min
-		PodName:           getEnv("POD_NAME", "local-dev"),
-		PodNamespace:      getEnv("POD_NAMESPACE", "open-cluster-management"),
-		RediscoverRateMS:  getEnvAsInt("REDISCOVER_RATE_MS", 5*60*1000), // 5 min
-		ResyncPeriodMS:    getEnvAsInt("RESYNC_PERIOD_MS", 15*60*1000),  // 15 min - cluster resync period
-		RequestLimit:      getEnvAsInt("REQUEST_LIMIT", 25),             // Set to 25 to prevent memory issues.
-		LargeRequestLimit: getEnvAsInt("LARGE_REQUEST_LIMIT", 5),
-		LargeRequestSize:  getEnvAsInt("LARGE_REQUEST_SIZE", 1024*1024*20), // 20 MB
-		ServerAddress:     getEnv("AGGREGATOR_ADDRESS", ":3010"),
-		SlowLog:           getEnvAsInt("SLOW_LOG", 1000), // 1 second
-		Version:           COMPONENT_VERSION,
+		MaxBackoffMS:        getEnvAsInt("MAX_BACKOFF_MS", 5*60*1000), // 5 min
+		MaxPropertyBytes:    getEnvAsInt("MAX_PROPERTY_BYTES", 0),
+		MaxResourceBytes:    getEnvAsInt("MAX_RESOURCE_BYTES", 0),
+		MetricsMaxClusters:  getEnvAsInt("METRICS_MAX_CLUSTERS", 500),
+		OrphanEdgeCleanupMS: getEnvAsInt("ORPHAN_EDGE_CLEANUP_MS", 0),
+		OversizePolicy:      getEnv("OVERSIZE_POLICY", "reject"),
+		OTLPEndpoint:        getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		PodName:             getEnv("POD_NAME", "local-dev"),
+		PodNamespace:        getEnv("POD_NAMESPACE", "open-cluster-management"),
+		PropertySamples:     getEnvAsInt("PROPERTY_CATALOG_SAMPLES", 0),
+		PropertyTypesMode:   getEnv("PROPERTY_TYPES_MODE", "off"),
+		PropertyTypesPath:   getEnv("PROPERTY_TYPES_CONFIG_PATH", ""),
+		RediscoverRateMS:    getEnvAsInt("REDISCOVER_RATE_MS", 5*60*1000), // 5 min
+		QuotaDataBytes:      getEnvAsInt("QUOTA_DATA_BYTES", 0),
+		QuotaEdges:          getEnvAsInt("QUOTA_EDGES", 0),
+		QuotaResources:      getEnvAsInt("QUOTA_RESOURCES", 0),
+		RedactionConfigPath: getEnv("REDACTION_CONFIG_PATH", ""),
+		ResyncMode:          getEnv("RESYNC_MODE", "inplace"),
+		ResyncPeriodMS:      getEnvAsInt("RESYNC_PERIOD_MS", 15*60*1000), // 15 min - cluster resync period
+		RequestLimit:        getEnvAsInt("REQUEST_LIMIT", 25),            // Set to 25 to prevent memory issues.
+		LargeRequestLimit:   getEnvAsInt("LARGE_REQUEST_LIMIT", 5),
+		LargeRequestSize:    getEnvAsInt("LARGE_REQUEST_SIZE", 1024*1024*20), // 20 MB
+		ServerAddress:       getEnv("AGGREGATOR_ADDRESS", ":3010"),
+		SlowLog:             getEnvAsInt("SLOW_LOG", 1000), // 1 second
+		SlowQueryExplainMS:  getEnvAsInt("SLOW_QUERY_EXPLAIN_MS", 0),
+		SlowQueryExplainPct: getEnvAsInt("SLOW_QUERY_EXPLAIN_PCT", 10),
+		SlowQueryPlans:      getEnvAsInt("SLOW_QUERY_PLANS", 50),
+		Version:             COMPONENT_VERSION,
 	}
 
 	// URLEncode the db password.
@@ -142,5 +206,57 @@ func (cfg *Config) Validate() error {
 	if cfg.DBPass == "" {
 		return errors.New("required environment DB_PASS is not set")
 	}
+	if cfg.ClusterDeleteGraceMS < 0 {
+		return errors.New("CLUSTER_DELETE_GRACE_MS must be zero or greater")
+	}
+	if cfg.ClusterDeleteWorkers < 0 {
+		return errors.New("CLUSTER_DELETE_WORKERS must be zero or greater")
+	}
+	if cfg.ClusterDeleteWorkers > 0 && cfg.ClusterDeleteChunkSize <= 0 {
+		return errors.New("CLUSTER_DELETE_CHUNK_SIZE must be greater than zero")
+	}
+	if cfg.DBPartitionMode != "none" && cfg.DBPartitionMode != "list" && cfg.DBPartitionMode != "hash" {
+		return fmt.Errorf("DB_PARTITION_MODE must be none, list or hash, got %s", cfg.DBPartitionMode)
+	}
+	if cfg.DBPartitionMode == "hash" && cfg.DBHashPartitions <= 0 {
+		return errors.New("DB_HASH_PARTITIONS must be greater than zero")
+	}
+	if cfg.EdgeValidation != "off" && cfg.EdgeValidation != "warn" && cfg.EdgeValidation != "strict" {
+		return fmt.Errorf("EDGE_VALIDATION must be off, warn or strict, got %s", cfg.EdgeValidation)
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return fmt.Errorf("LOG_FORMAT must be text or json, got %s", cfg.LogFormat)
+	}
+	if cfg.MaxPropertyBytes < 0 || cfg.MaxResourceBytes < 0 {
+		return errors.New("MAX_PROPERTY_BYTES and MAX_RESOURCE_BYTES must be zero or greater")
+	}
+	if cfg.OversizePolicy != "reject" && cfg.OversizePolicy != "truncate" && cfg.OversizePolicy != "drop" {
+		return fmt.Errorf("OVERSIZE_POLICY must be reject, truncate or drop, got %s", cfg.OversizePolicy)
+	}
+	if cfg.PropertyTypesMode != "off" && cfg.PropertyTypesMode != "configured" && cfg.PropertyTypesMode != "learned" {
+		return fmt.Errorf("PROPERTY_TYPES_MODE must be off, configured or learned, got %s", cfg.PropertyTypesMode)
+	}
+	if cfg.PropertySamples < 0 {
+		return errors.New("PROPERTY_CATALOG_SAMPLES must be zero or greater")
+	}
+	if cfg.QuotaResources < 0 || cfg.QuotaEdges < 0 || cfg.QuotaDataBytes < 0 {
+		return errors.New("QUOTA_RESOURCES, QUOTA_EDGES and QUOTA_DATA_BYTES must be zero or greater")
+	}
+	if cfg.ResyncMode != "inplace" && cfg.ResyncMode != "atomic" {
+		return fmt.Errorf("RESYNC_MODE must be inplace or atomic, got %s", cfg.ResyncMode)
+	}
+	if cfg.SlowQueryExplainPct < 0 || cfg.SlowQueryExplainPct > 100 {
+		return errors.New("SLOW_QUERY_EXPLAIN_PCT must be between 0 and 100")
+	}
+	// Same rules as leaderelection.NewLeaderElector(), which panics on invalid values.
+	if cfg.RetryPeriodMS <= 0 {
+		return errors.New("RETRY_PERIOD_MS must be greater than zero")
+	}
+	if cfg.LeaseDurationMS <= cfg.RenewDeadlineMS {
+		return errors.New("LEASE_DURATION_MS must be greater than RENEW_DEADLINE_MS")
+	}
+	if float64(cfg.RenewDeadlineMS) <= leaderelection.JitterFactor*float64(cfg.RetryPeriodMS) {
+		return fmt.Errorf("RENEW_DEADLINE_MS must be greater than %.1f times RETRY_PERIOD_MS", leaderelection.JitterFactor)
+	}
 	return nil
 }
@@ -108,3 +108,178 @@ func Test_Validate(t *testing.T) {
 		t.Errorf("Expected %s Got: %s", "required environment DB_NAME is not set", result)
 	}
 }
+
+// A configuration that passes Validate. Tests change only the field under test.
+func validConfig() *Config {
+	return &Config{DBName: "test", DBUser: "test", DBPass: "test", DBPartitionMode: "none", EdgeValidation: "off",
+		LogFormat: "text", OversizePolicy: "reject", PropertyTypesMode: "off", ResyncMode: "inplace",
+		ClusterDeleteChunkSize: 10000, LeaseDurationMS: 15000, RenewDeadlineMS: 10000, RetryPeriodMS: 2000}
+}
+
+// Should validate the leader election parameters.
+func Test_Validate_LeaderElection(t *testing.T) {
+	conf := validConfig()
+	if result := conf.Validate(); result != nil {
+		t.Errorf("Expected %v Got: %+v", nil, result)
+	}
+
+	conf.RenewDeadlineMS = 15000
+	result := conf.Validate()
+	if result == nil || result.Error() != "LEASE_DURATION_MS must be greater than RENEW_DEADLINE_MS" {
+		t.Errorf("Expected error for RENEW_DEADLINE_MS. Got: %v", result)
+	}
+
+	conf.RenewDeadlineMS = 2000
+	result = conf.Validate()
+	if result == nil || result.Error() != "RENEW_DEADLINE_MS must be greater than 1.2 times RETRY_PERIOD_MS" {
+		t.Errorf("Expected error for RETRY_PERIOD_MS. Got: %v", result)
+	}
+}
+
+func Test_Validate_LogFormat(t *testing.T) {
+	conf := validConfig()
+	conf.LogFormat = "json"
+	if result := conf.Validate(); result != nil {
+		t.Errorf("Expected %v Got: %+v", nil, result)
+	}
+
+	conf.LogFormat = "yaml"
+	result := conf.Validate()
+	if result == nil || result.Error() != "LOG_FORMAT must be text or json, got yaml" {
+		t.Errorf("Expected error for LOG_FORMAT. Got: %v", result)
+	}
+}
+
+func Test_Validate_ResyncMode(t *testing.T) {
+	conf := validConfig()
+	conf.ResyncMode = "atomic"
+	if result := conf.Validate(); result != nil {
+		t.Errorf("Expected %v Got: %+v", nil, result)
+	}
+
+	conf.ResyncMode = "staged"
+	result := conf.Validate()
+	if result == nil || result.Error() != "RESYNC_MODE must be inplace or atomic, got staged" {
+		t.Errorf("Expected error for RESYNC_MODE. Got: %v", result)
+	}
+}
+
+func Test_Validate_PartitionMode(t *testing.T) {
+	conf := validConfig()
+	conf.DBPartitionMode = "list"
+	if result := conf.Validate(); result != nil {
+		t.Errorf("Expected %v Got: %+v", nil, result)
+	}
+
+	conf.DBPartitionMode = "range"
+	result := conf.Validate()
+	if result == nil || result.Error() != "DB_PARTITION_MODE must be none, list or hash, got range" {
+		t.Errorf("Expected error for DB_PARTITION_MODE. Got: %v", result)
+	}
+
+	conf.DBPartitionMode = "hash"
+	result = conf.Validate()
+	if result == nil || result.Error() != "DB_HASH_PARTITIONS must be greater than zero" {
+		t.Errorf("Expected error for DB_HASH_PARTITIONS. Got: %v", result)
+	}
+}
+
+func Test_Validate_PropertyTypesMode(t *testing.T) {
+	conf := validConfig()
+	conf.PropertyTypesMode = "learned"
+	if result := conf.Validate(); result != nil {
+		t.Errorf("Expected %v Got: %+v", nil, result)
+	}
+
+	conf.PropertyTypesMode = "strict"
+	result := conf.Validate()
+	if result == nil || result.Error() != "PROPERTY_TYPES_MODE must be off, configured or learned, got strict" {
+		t.Errorf("Expected error for PROPERTY_TYPES_MODE. Got: %v", result)
+	}
+
+	conf.PropertyTypesMode = "off"
+	conf.PropertySamples = -1
+	result = conf.Validate()
+	if result == nil || result.Error() != "PROPERTY_CATALOG_SAMPLES must be zero or greater" {
+		t.Errorf("Expected error for PROPERTY_CATALOG_SAMPLES. Got: %v", result)
+	}
+}
+
+func Test_Validate_Quotas(t *testing.T) {
+	conf := validConfig()
+	conf.QuotaResources = 1000
+	if result := conf.Validate(); result != nil {
+		t.Errorf("Expected %v Got: %+v", nil, result)
+	}
+
+	conf.QuotaEdges = -1
+	result := conf.Validate()
+	if result == nil || result.Error() != "QUOTA_RESOURCES, QUOTA_EDGES and QUOTA_DATA_BYTES must be zero or greater" {
+		t.Errorf("Expected error for QUOTA_EDGES. Got: %v", result)
+	}
+}
+
+func Test_Validate_SizeLimits(t *testing.T) {
+	conf := validConfig()
+	conf.MaxPropertyBytes = 1024
+	conf.OversizePolicy = "truncate"
+	if result := conf.Validate(); result != nil {
+		t.Errorf("Expected %v Got: %+v", nil, result)
+	}
+
+	conf.OversizePolicy = "shrink"
+	result := conf.Validate()
+	if result == nil || result.Error() != "OVERSIZE_POLICY must be reject, truncate or drop, got shrink" {
+		t.Errorf("Expected error for OVERSIZE_POLICY. Got: %v", result)
+	}
+
+	conf.OversizePolicy = "drop"
+	conf.MaxResourceBytes = -1
+	result = conf.Validate()
+	if result == nil || result.Error() != "MAX_PROPERTY_BYTES and MAX_RESOURCE_BYTES must be zero or greater" {
+		t.Errorf("Expected error for MAX_RESOURCE_BYTES. Got: %v", result)
+	}
+}
+
+func Test_Validate_EdgeValidation(t *testing.T) {
+	conf := validConfig()
+	conf.EdgeValidation = "strict"
+	if result := conf.Validate(); result != nil {
+		t.Errorf("Expected %v Got: %+v", nil, result)
+	}
+
+	conf.EdgeValidation = "on"
+	result := conf.Validate()
+	if result == nil || result.Error() != "EDGE_VALIDATION must be off, warn or strict, got on" {
+		t.Errorf("Expected error for EDGE_VALIDATION. Got: %v", result)
+	}
+}
+
+func Test_Validate_ClusterDeleteGrace(t *testing.T) {
+	conf := validConfig()
+	conf.ClusterDeleteGraceMS = -1
+	result := conf.Validate()
+	if result == nil || result.Error() != "CLUSTER_DELETE_GRACE_MS must be zero or greater" {
+		t.Errorf("Expected error for CLUSTER_DELETE_GRACE_MS. Got: %v", result)
+	}
+}
+
+func Test_Validate_ClusterDeleteQueue(t *testing.T) {
+	conf := validConfig()
+	conf.ClusterDeleteWorkers = 4
+	if result := conf.Validate(); result != nil {
+		t.Errorf("Expected %v Got: %+v", nil, result)
+	}
+
+	conf.ClusterDeleteChunkSize = 0
+	result := conf.Validate()
+	if result == nil || result.Error() != "CLUSTER_DELETE_CHUNK_SIZE must be greater than zero" {
+		t.Errorf("Expected error for CLUSTER_DELETE_CHUNK_SIZE. Got: %v", result)
+	}
+
+	conf.ClusterDeleteWorkers = -1
+	result = conf.Validate()
+	if result == nil || result.Error() != "CLUSTER_DELETE_WORKERS must be zero or greater" {
+		t.Errorf("Expected error for CLUSTER_DELETE_WORKERS. Got: %v", result)
+	}
+}
@@ -7,10 +7,14 @@ import (
 	"errors"
 	"strings"
 	"sync"
+	"time"
 
 	pgx "github.com/jackc/pgx/v4"
+	"github.com/stolostron/search-indexer/pkg/logging"
+	"github.com/stolostron/search-indexer/pkg/metrics"
 	"github.com/stolostron/search-indexer/pkg/model"
-	"k8s.io/klog/v2"
+	"github.com/stolostron/search-indexer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // This is a wrapper for pgx.Batch. It add the following.
@@ -32,6 +36,8 @@ type batchWithRetry struct {
 	dao          *DAO
 	wg           *sync.WaitGroup
 	syncResponse *model.SyncResponse
+	failedLock   *sync.Mutex
+	failed       map[string][]string // UIDs of the items that failed in the database, by action.
 }
 
 func NewBatchWithRetry(ctx context.Context, dao *DAO, syncResponse *model.SyncResponse) batchWithRetry {
@@ -41,6 +47,8 @@ func NewBatchWithRetry(ctx context.Context, dao *DAO, syncResponse *model.SyncRe
 		wg:           &sync.WaitGroup{},
 		dao:          dao,
 		syncResponse: syncResponse,
+		failedLock:   &sync.Mutex{},
+		failed:       map[string][]string{},
 	}
 	return batch
 }
@@ -56,40 +64,59 @@ func (b *batchWithRetry) Queue(item batchItem) error {
 		items := b.items               // Create a snapshot of the items to process.
 		b.items = make([]batchItem, 0) // Reset the queue.
 		b.wg.Add(1)
-		go b.sendBatch(items) // nolint: errcheck
+		metrics.DBBatchSize.Observe(float64(len(items)))
+		go b.sendBatch(items, 0) // nolint: errcheck
 	}
 	return nil
 }
 
 // Sends a batch to the database. If the batch results in an error, we divide
 // the batch into smaller batches and retry until we isolate the erroring query.
-func (b *batchWithRetry) sendBatch(items []batchItem) error {
+// The depth is the number of times the batch was divided.
+func (b *batchWithRetry) sendBatch(items []batchItem, depth int) error {
 	defer b.wg.Done()
+	ctx, span := tracing.StartSpan(b.ctx, "sendBatch",
+		attribute.Int("batch.size", len(items)), attribute.Int("batch.retry_depth", depth))
+	defer span.End()
+	logger := logging.Operation(ctx, "sendBatch")
 
 	batch := &pgx.Batch{}
 	for _, item := range items {
 		batch.Queue(item.query, item.args...)
 	}
-	br := b.dao.pool.SendBatch(b.ctx, batch)
+	queryTimer := metrics.QueryTimer("batch")
+	start := time.Now()
+	br := b.dao.pool.SendBatch(ctx, batch)
 	_, execErr := br.Exec()
 
 	closeErr := br.Close()
+	queryTimer()
+	duration := time.Since(start)
+	tracing.RecordError(span, execErr)
 	if closeErr != nil {
+		tracing.RecordError(span, closeErr)
 		if strings.Contains(closeErr.Error(), "unexpected EOF") || strings.Contains(closeErr.Error(), "failed to connect") {
 			b.connError = closeErr
-			klog.Error("Send batch failed because database is unavailable. Won't retry.")
+			logger.Error(closeErr, "Send batch failed because database is unavailable. Won't retry.")
 			return errors.New("failed to connect to database")
 		}
-		klog.Error("Error closing batch result. ", closeErr)
+		logger.Error(closeErr, "Error closing batch result")
 		return closeErr
 	}
 
+	if execErr == nil {
+		b.dao.explainSlowBatch(items, duration)
+	}
+
 	// Process errors.
 	// pgx.Batch is processed as a transaction, so in case of an error, the entire batch will fail.
 	if execErr != nil && len(items) == 1 {
 
 		errorItem := items[0]
-		klog.Errorf("ERROR processing batchItem. %+v", errorItem)
+		logger.Error(execErr, "Error processing batchItem", "action", errorItem.action, "uid", errorItem.uid,
+			"query", errorItem.query, "retryDepth", depth)
+		metrics.DBBatchFailedItems.WithLabelValues(errorItem.action).Inc()
+		metrics.DBBatchRetryDepth.Observe(float64(depth))
 
 		var errorArray *[]model.SyncError
 		switch errorItem.action {
@@ -104,10 +131,13 @@ func (b *batchWithRetry) sendBatch(items []batchItem) error {
 		case "deleteEdge":
 			errorArray = &b.syncResponse.DeleteEdgeErrors
 		default:
-			klog.Error("Unable to process sync error with type: ", errorItem.action)
+			logger.Error(nil, "Unable to process sync error", "action", errorItem.action)
 		}
 		*errorArray = append(*errorArray,
 			model.SyncError{ResourceUID: errorItem.uid, Message: "Resource generated an error while updating the database."})
+		b.failedLock.Lock()
+		b.failed[errorItem.action] = append(b.failed[errorItem.action], errorItem.uid)
+		b.failedLock.Unlock()
 
 		return nil // We have processed the error, so don't return an error here to stop the recursion.
 
@@ -116,8 +146,9 @@ func (b *batchWithRetry) sendBatch(items []batchItem) error {
 		// Use a binary search recursively until we find the error.
 
 		b.wg.Add(2)
-		err1 := b.sendBatch(items[:len(items)/2])
-		err2 := b.sendBatch(items[len(items)/2:])
+		metrics.DBBatchRetries.Inc()
+		err1 := b.sendBatch(items[:len(items)/2], depth+1)
+		err2 := b.sendBatch(items[len(items)/2:], depth+1)
 
 		// Returns error only if we fail processing either retry.
 		if err1 != nil && err2 != nil {
@@ -133,6 +164,15 @@ func (b *batchWithRetry) flush() {
 		items := b.items               // Create a snapshot of the items to process.
 		b.items = make([]batchItem, 0) // Reset the queue.
 		b.wg.Add(1)
-		go b.sendBatch(items) // nolint: errcheck
+		metrics.DBBatchSize.Observe(float64(len(items)))
+		go b.sendBatch(items, 0) // nolint: errcheck
 	}
 }
+
+// UIDs of the items with the action that failed in the database. Returns an empty slice, not nil, so it can be used
+// as a query parameter.
+func (b *batchWithRetry) failedUIDs(action string) []string {
+	b.failedLock.Lock()
+	defer b.failedLock.Unlock()
+	return append(make([]string, 0, len(b.failed[action])), b.failed[action]...)
+}
@@ -3,10 +3,20 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"testing"
 
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stolostron/search-indexer/pkg/testutils"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_QueueWithErrors(t *testing.T) {
@@ -18,3 +28,60 @@ func Test_QueueWithErrors(t *testing.T) {
 
 	assert.NotNil(t, result)
 }
+
+// Should split the batch to isolate the failing items and record the retry metrics.
+func Test_sendBatch_RetryMetrics(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	dao.batchSize = 2
+	br := &testutils.MockBatchResults{MockErrorOnExec: errors.New("mocking error on exec")}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(3)
+	defer testutils.SupressConsoleOutput()()
+
+	retries := testutil.ToFloat64(metrics.DBBatchRetries)
+	failedItems := testutil.ToFloat64(metrics.DBBatchFailedItems.WithLabelValues("addEdge"))
+
+	response := &model.SyncResponse{}
+	batch := NewBatchWithRetry(context.Background(), &dao, response)
+	_ = batch.Queue(batchItem{action: "addEdge", uid: "uid1"})
+	_ = batch.Queue(batchItem{action: "addEdge", uid: "uid2"})
+	batch.wg.Wait()
+
+	assert.Equal(t, 2, len(response.AddEdgeErrors))
+	assert.ElementsMatch(t, []string{"uid1", "uid2"}, batch.failedUIDs("addEdge"))
+	assert.Equal(t, []string{}, batch.failedUIDs("addResource"))
+	assert.Equal(t, retries+1, testutil.ToFloat64(metrics.DBBatchRetries))
+	assert.Equal(t, failedItems+2, testutil.ToFloat64(metrics.DBBatchFailedItems.WithLabelValues("addEdge")))
+}
+
+// Should record a span for each batch sent, with the size and retry depth.
+func Test_sendBatch_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previousProvider)
+
+	dao, mockPool := buildMockDAO(t)
+	dao.batchSize = 2
+	br := &testutils.MockBatchResults{MockErrorOnExec: errors.New("mocking error on exec")}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(3)
+	defer testutils.SupressConsoleOutput()()
+
+	batch := NewBatchWithRetry(context.Background(), &dao, &model.SyncResponse{})
+	_ = batch.Queue(batchItem{action: "addEdge", uid: "uid1"})
+	_ = batch.Queue(batchItem{action: "addEdge", uid: "uid2"})
+	batch.wg.Wait()
+
+	spans := recorder.Ended()
+	assert.Equal(t, 3, len(spans))
+	sizes := map[int64]int64{}
+	for _, span := range spans {
+		assert.Equal(t, "sendBatch", span.Name())
+		assert.Equal(t, codes.Error, span.Status().Code)
+		attrs := map[string]int64{}
+		for _, attr := range span.Attributes() {
+			attrs[string(attr.Key)] = attr.Value.AsInt64()
+		}
+		sizes[attrs["batch.retry_depth"]] += attrs["batch.size"]
+	}
+	assert.Equal(t, map[int64]int64{0: 2, 1: 2}, sizes)
+}
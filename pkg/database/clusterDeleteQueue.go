@@ -0,0 +1,306 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/klog/v2"
+)
+
+// Cluster delete queue, enabled with CLUSTER_DELETE_WORKERS.
+//
+// Deleting hundreds of clusters at once, like when an environment is torn down, runs a transaction for each cluster
+// that deletes all its rows and retries until it succeeds. With the queue, DeleteClusterAndResources records the
+// cluster in search.cluster_deletes and returns. The leader deletes up to CLUSTER_DELETE_WORKERS clusters at a time,
+// in statements of CLUSTER_DELETE_CHUNK_SIZE rows, or by partition with DB_PARTITION_MODE=list. A failed delete stays
+// in the table and is retried by the next poll, so the pending deletes survive errors, restarts, and a new leader.
+// A cluster queued again while it's deleted is deleted again, because its row is only removed if it wasn't queued
+// again. The chunks aren't deleted in a transaction, so the row is checked before each chunk, and the delete stops
+// when it was canceled, like when the search addon is created again and the collector resyncs, or queued again.
+
+// Time between reads of the pending deletes from search.cluster_deletes.
+var clusterDeletePollInterval = 30 * time.Second
+
+// Clusters known to be in search.cluster_deletes, and the clusters being deleted.
+type clusterDeleteQueue struct {
+	lock    sync.Mutex
+	pending map[string]struct{}
+	running map[string]struct{}
+	notify  chan struct{}
+}
+
+var clusterDeletes = &clusterDeleteQueue{
+	pending: map[string]struct{}{},
+	running: map[string]struct{}{},
+	notify:  make(chan struct{}, 1),
+}
+
+// Returned when the delete of a cluster stops because it was canceled or queued again.
+var errClusterDeleteStopped = errors.New("the cluster delete was canceled or queued again")
+
+// A cluster waiting in search.cluster_deletes.
+type clusterDelete struct {
+	cluster    string
+	deleteNode bool
+	queued     time.Time
+}
+
+// Record the delete in search.cluster_deletes and notify the workers. A pending delete keeps the Cluster node only if
+// both deletes keep it.
+func (dao *DAO) queueClusterDelete(ctx context.Context, clusterName string, deleteClusterNode bool) error {
+	defer metrics.QueryTimer("queueClusterDelete")()
+	_, err := dao.pool.Exec(ctx, `INSERT INTO search.cluster_deletes (cluster, delete_node) VALUES ($1, $2)
+		ON CONFLICT (cluster) DO UPDATE SET delete_node=search.cluster_deletes.delete_node OR excluded.delete_node,
+		queued=now()`, clusterName, deleteClusterNode)
+	if err != nil {
+		return err
+	}
+	clusterDeletes.lock.Lock()
+	clusterDeletes.pending[clusterName] = struct{}{}
+	clusterDeletes.lock.Unlock()
+	select {
+	case clusterDeletes.notify <- struct{}{}:
+	default: // Already notified.
+	}
+	klog.V(2).Infof("Queued the delete of cluster %s.", clusterName)
+	return nil
+}
+
+// CancelClusterDelete removes a pending delete that keeps the Cluster node, used when the search addon of the cluster
+// is created again. A delete that already started stops before its next chunk.
+func (dao *DAO) CancelClusterDelete(ctx context.Context, clusterName string) {
+	clusterDeletes.lock.Lock()
+	_, pending := clusterDeletes.pending[clusterName]
+	clusterDeletes.lock.Unlock()
+	if config.Cfg.ClusterDeleteWorkers <= 0 || !pending {
+		return
+	}
+	defer metrics.QueryTimer("cancelClusterDelete")()
+	res, err := dao.pool.Exec(ctx, "DELETE FROM search.cluster_deletes WHERE cluster=$1 AND NOT delete_node",
+		clusterName)
+	if err != nil {
+		klog.Warningf("Error canceling the queued delete of cluster %s. %s", clusterName, err)
+		return
+	}
+	if res.RowsAffected() > 0 {
+		clusterDeletes.lock.Lock()
+		delete(clusterDeletes.pending, clusterName)
+		clusterDeletes.lock.Unlock()
+		klog.V(2).Infof("Canceled the queued delete of cluster %s.", clusterName)
+	}
+}
+
+// StartClusterDeleteQueue deletes the queued clusters until the context is canceled. Runs on the leader.
+func (dao *DAO) StartClusterDeleteQueue(ctx context.Context) {
+	if config.Cfg.ClusterDeleteWorkers <= 0 {
+		return
+	}
+	workers := make(chan struct{}, config.Cfg.ClusterDeleteWorkers)
+	ticker := time.NewTicker(clusterDeletePollInterval)
+	defer ticker.Stop()
+	for {
+		dao.dispatchClusterDeletes(ctx, workers)
+		select {
+		case <-ctx.Done():
+			klog.Info("Exit cluster delete queue.")
+			return
+		case <-ticker.C:
+		case <-clusterDeletes.notify:
+		}
+	}
+}
+
+// Start a worker for each pending delete that isn't running, waiting for a free worker.
+func (dao *DAO) dispatchClusterDeletes(ctx context.Context, workers chan struct{}) {
+	deletes, err := dao.pendingClusterDeletes(ctx)
+	if err != nil {
+		klog.Warningf("Error reading the queued cluster deletes. %s", err)
+		return
+	}
+	for _, next := range deletes {
+		select {
+		case <-ctx.Done():
+			return
+		case workers <- struct{}{}:
+		}
+		// Skip the clusters deleted or canceled while waiting for the worker.
+		clusterDeletes.lock.Lock()
+		_, pending := clusterDeletes.pending[next.cluster]
+		_, running := clusterDeletes.running[next.cluster]
+		if !pending || running {
+			clusterDeletes.lock.Unlock()
+			<-workers
+			continue
+		}
+		clusterDeletes.running[next.cluster] = struct{}{}
+		metrics.ClusterDeleteQueue.WithLabelValues("running").Set(float64(len(clusterDeletes.running)))
+		clusterDeletes.lock.Unlock()
+		go func(next clusterDelete) {
+			defer func() {
+				clusterDeletes.lock.Lock()
+				delete(clusterDeletes.running, next.cluster)
+				metrics.ClusterDeleteQueue.WithLabelValues("running").Set(float64(len(clusterDeletes.running)))
+				clusterDeletes.lock.Unlock()
+				<-workers
+			}()
+			dao.processClusterDelete(ctx, next)
+		}(next)
+	}
+}
+
+// Read the pending deletes, oldest first.
+func (dao *DAO) pendingClusterDeletes(ctx context.Context) ([]clusterDelete, error) {
+	defer metrics.QueryTimer("pendingClusterDeletes")()
+	rows, err := dao.pool.Query(ctx,
+		"SELECT cluster, delete_node, queued FROM search.cluster_deletes ORDER BY queued")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deletes := make([]clusterDelete, 0)
+	pending := map[string]struct{}{}
+	for rows.Next() {
+		var next clusterDelete
+		if err = rows.Scan(&next.cluster, &next.deleteNode, &next.queued); err != nil {
+			return nil, err
+		}
+		deletes = append(deletes, next)
+		pending[next.cluster] = struct{}{}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	clusterDeletes.lock.Lock()
+	clusterDeletes.pending = pending
+	clusterDeletes.lock.Unlock()
+	metrics.ClusterDeleteQueue.WithLabelValues("pending").Set(float64(len(deletes)))
+	return deletes, nil
+}
+
+// Delete the cluster data and remove the cluster from search.cluster_deletes, unless it was queued again.
+func (dao *DAO) processClusterDelete(ctx context.Context, clusterDelete clusterDelete) {
+	clusterName := clusterDelete.cluster
+	start := time.Now()
+	err := dao.deleteClusterResourcesInChunks(ctx, clusterDelete)
+	if errors.Is(err, errClusterDeleteStopped) {
+		klog.V(2).Infof("Stopped the delete of cluster %s because it was canceled or queued again.", clusterName)
+		metrics.ClusterDeleteJobs.WithLabelValues("stopped").Inc()
+		return
+	}
+	if err == nil && clusterDelete.deleteNode {
+		clusterUID := "cluster__" + clusterName
+		if err = dao.DeleteClusterTxn(ctx, clusterUID); err == nil {
+			DeleteClustersCache(clusterUID)
+		}
+	} else if err == nil {
+		dao.clearPendingDeleteIfMarked(ctx, clusterName)
+	}
+	if err == nil {
+		queryTimer := metrics.QueryTimer("dequeueClusterDelete")
+		res, dequeueErr := dao.pool.Exec(ctx, "DELETE FROM search.cluster_deletes WHERE cluster=$1 AND queued=$2",
+			clusterName, clusterDelete.queued)
+		queryTimer()
+		err = dequeueErr
+		if err == nil && res.RowsAffected() > 0 {
+			clusterDeletes.lock.Lock()
+			delete(clusterDeletes.pending, clusterName)
+			clusterDeletes.lock.Unlock()
+		}
+	}
+	if err != nil {
+		klog.Warningf("Error deleting cluster %s. The delete is retried by the next poll. %s", clusterName, err)
+		metrics.ClusterDeleteJobs.WithLabelValues("error").Inc()
+		return
+	}
+	klog.V(2).Infof("Deleted cluster %s from the delete queue in %s.", clusterName, time.Since(start))
+	metrics.ClusterDeleteJobs.WithLabelValues("success").Inc()
+}
+
+// Delete the resources and edges of the cluster in statements of CLUSTER_DELETE_CHUNK_SIZE rows, without a long
+// transaction. With list partitions, the partitions of the cluster are truncated instead. Returns
+// errClusterDeleteStopped if the delete is canceled or queued again before a chunk.
+func (dao *DAO) deleteClusterResourcesInChunks(ctx context.Context, clusterDelete clusterDelete) (err error) {
+	clusterName := clusterDelete.cluster
+	ctx, span := tracing.StartSpan(ctx, "deleteClusterResourcesInChunks", attribute.String("cluster", clusterName))
+	defer span.End()
+	hub := dao.isHubCluster(ctx, clusterName)
+	// Only after all the chunks are deleted. A failed delete is retried by the next poll.
+	defer func() {
+		if err == nil {
+			dao.forgetClusterData(ctx, clusterName, hub)
+		}
+	}()
+	if partitionMode == "list" {
+		if err = dao.checkClusterDeleteQueued(ctx, clusterDelete); err != nil {
+			return err
+		}
+		return dao.truncateClusterPartitions(ctx, clusterName, true)
+	}
+	chunkSize := config.Cfg.ClusterDeleteChunkSize
+	statements := []struct {
+		table string
+		sql   string
+		args  []interface{}
+	}{
+		{"resources", `DELETE FROM search.resources WHERE cluster=$1 AND uid IN
+			(SELECT uid FROM search.resources WHERE cluster=$1 AND uid!=$2 LIMIT $3)`,
+			[]interface{}{clusterName, "cluster__" + clusterName, chunkSize}},
+		{"edges", `DELETE FROM search.edges WHERE cluster=$1 AND (sourceid, destid, edgetype) IN
+			(SELECT sourceid, destid, edgetype FROM search.edges WHERE cluster=$1 LIMIT $2)`,
+			[]interface{}{clusterName, chunkSize}},
+	}
+	for _, statement := range statements {
+		for {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := dao.checkClusterDeleteQueued(ctx, clusterDelete); err != nil {
+				return err
+			}
+			queryTimer := metrics.QueryTimer("deleteClusterChunk")
+			res, err := dao.pool.Exec(ctx, statement.sql, statement.args...)
+			queryTimer()
+			if err != nil {
+				return fmt.Errorf("error deleting the %s of cluster %s: %w", statement.table, clusterName, err)
+			}
+			metrics.ClusterDeleteRows.WithLabelValues(statement.table).Add(float64(res.RowsAffected()))
+			if res.RowsAffected() < int64(chunkSize) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Check that the delete is still in search.cluster_deletes as it was read. Returns errClusterDeleteStopped if it was
+// canceled or queued again.
+func (dao *DAO) checkClusterDeleteQueued(ctx context.Context, clusterDelete clusterDelete) error {
+	queryTimer := metrics.QueryTimer("checkClusterDelete")
+	rows, err := dao.pool.Query(ctx,
+		"SELECT EXISTS (SELECT 1 FROM search.cluster_deletes WHERE cluster=$1 AND queued=$2)",
+		clusterDelete.cluster, clusterDelete.queued)
+	queryTimer()
+	if err != nil {
+		return fmt.Errorf("error checking the queued delete of cluster %s: %w", clusterDelete.cluster, err)
+	}
+	defer rows.Close()
+	queued := false
+	if rows.Next() {
+		if err = rows.Scan(&queued); err != nil {
+			return fmt.Errorf("error checking the queued delete of cluster %s: %w", clusterDelete.cluster, err)
+		}
+	}
+	if !queued {
+		return errClusterDeleteStopped
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// Expect the check that the delete of the cluster is still queued.
+func expectClusterDeleteQueued(mockPool *pgxpoolmock.MockPgxPool, clusterName string, queued bool) *gomock.Call {
+	return mockPool.EXPECT().Query(gomock.Any(),
+		"SELECT EXISTS (SELECT 1 FROM search.cluster_deletes WHERE cluster=$1 AND queued=$2)", clusterName,
+		gomock.Any()).Return(pgxpoolmock.NewRows([]string{"exists"}).AddRow(queued).ToPgxRows(), nil)
+}
+
+// Enable the cluster delete queue and clear it after the test.
+func setClusterDeleteQueue(t *testing.T, workers, chunkSize int) {
+	savedWorkers, savedChunkSize := config.Cfg.ClusterDeleteWorkers, config.Cfg.ClusterDeleteChunkSize
+	config.Cfg.ClusterDeleteWorkers, config.Cfg.ClusterDeleteChunkSize = workers, chunkSize
+	t.Cleanup(func() {
+		config.Cfg.ClusterDeleteWorkers, config.Cfg.ClusterDeleteChunkSize = savedWorkers, savedChunkSize
+		clusterDeletes.pending = map[string]struct{}{}
+		clusterDeletes.running = map[string]struct{}{}
+		select {
+		case <-clusterDeletes.notify:
+		default:
+		}
+	})
+}
+
+// Should record the delete instead of deleting the cluster.
+func Test_DeleteClusterAndResources_queued(t *testing.T) {
+	setClusterDeleteQueue(t, 2, 100)
+	dao, mockPool := buildMockDAO(t)
+	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), "cluster1", true).Return(pgconn.CommandTag("INSERT 0 1"), nil)
+
+	dao.DeleteClusterAndResources(context.Background(), "cluster1", true)
+
+	assert.Contains(t, clusterDeletes.pending, "cluster1")
+	assert.Len(t, clusterDeletes.notify, 1)
+}
+
+// Should delete the rows in chunks, then the Cluster node, and remove the cluster from the queue.
+func Test_dispatchClusterDeletes(t *testing.T) {
+	setClusterDeleteQueue(t, 1, 2)
+	dao, mockPool := buildMockDAO(t)
+	queued := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	deletedResources := testutil.ToFloat64(metrics.ClusterDeleteRows.WithLabelValues("resources"))
+	succeeded := testutil.ToFloat64(metrics.ClusterDeleteJobs.WithLabelValues("success"))
+	rows := pgxpoolmock.NewRows([]string{"cluster", "delete_node", "queued"}).AddRow("cluster1", true, queued).
+		ToPgxRows()
+	gomock.InOrder(
+		mockPool.EXPECT().Query(gomock.Any(),
+			"SELECT cluster, delete_node, queued FROM search.cluster_deletes ORDER BY queued").Return(rows, nil),
+		expectClusterDeleteQueued(mockPool, "cluster1", true),
+		mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), "cluster1", "cluster__cluster1", 2).
+			Return(pgconn.CommandTag("DELETE 2"), nil),
+		expectClusterDeleteQueued(mockPool, "cluster1", true),
+		mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), "cluster1", "cluster__cluster1", 2).
+			Return(pgconn.CommandTag("DELETE 1"), nil),
+		expectClusterDeleteQueued(mockPool, "cluster1", true),
+		mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), "cluster1", 2).Return(pgconn.CommandTag("DELETE 0"), nil),
+		mockPool.EXPECT().Exec(gomock.Any(), `DELETE FROM "search"."resources" WHERE ("uid" = 'cluster__cluster1')`).
+			Return(pgconn.CommandTag("DELETE 1"), nil),
+		mockPool.EXPECT().Exec(gomock.Any(), "DELETE FROM search.cluster_deletes WHERE cluster=$1 AND queued=$2",
+			"cluster1", queued).Return(pgconn.CommandTag("DELETE 1"), nil),
+	)
+
+	workers := make(chan struct{}, 1)
+	dao.dispatchClusterDeletes(context.Background(), workers)
+	workers <- struct{}{} // Wait for the worker to finish.
+
+	assert.Equal(t, deletedResources+3, testutil.ToFloat64(metrics.ClusterDeleteRows.WithLabelValues("resources")))
+	assert.Equal(t, succeeded+1, testutil.ToFloat64(metrics.ClusterDeleteJobs.WithLabelValues("success")))
+	assert.NotContains(t, clusterDeletes.pending, "cluster1")
+	assert.Empty(t, clusterDeletes.running)
+}
+
+// Should keep the cluster in the queue if the delete fails.
+func Test_processClusterDelete_error(t *testing.T) {
+	setClusterDeleteQueue(t, 1, 2)
+	dao, mockPool := buildMockDAO(t)
+	setQuotas(t, 100, 0, 0)
+	clusterDeletes.pending["cluster1"] = struct{}{}
+	quotaUsage["cluster1"] = clusterUsage{resources: 5}
+	failed := testutil.ToFloat64(metrics.ClusterDeleteJobs.WithLabelValues("error"))
+	expectClusterDeleteQueued(mockPool, "cluster1", true)
+	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), "cluster1", "cluster__cluster1", 2).
+		Return(nil, errors.New("mock error"))
+
+	dao.processClusterDelete(context.Background(), clusterDelete{cluster: "cluster1"})
+
+	assert.Equal(t, failed+1, testutil.ToFloat64(metrics.ClusterDeleteJobs.WithLabelValues("error")))
+	assert.Contains(t, clusterDeletes.pending, "cluster1")
+	// The rows weren't deleted, so the usage of the cluster is kept.
+	assert.Contains(t, quotaUsage, "cluster1")
+}
+
+// Should stop deleting the chunks when the delete is canceled or queued again, and keep the data of the cluster.
+func Test_processClusterDelete_stopped(t *testing.T) {
+	setClusterDeleteQueue(t, 1, 2)
+	dao, mockPool := buildMockDAO(t)
+	setQuotas(t, 100, 0, 0)
+	quotaUsage["cluster1"] = clusterUsage{resources: 5}
+	queued := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	stopped := testutil.ToFloat64(metrics.ClusterDeleteJobs.WithLabelValues("stopped"))
+	gomock.InOrder(
+		expectClusterDeleteQueued(mockPool, "cluster1", true),
+		mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), "cluster1", "cluster__cluster1", 2).
+			Return(pgconn.CommandTag("DELETE 2"), nil),
+		expectClusterDeleteQueued(mockPool, "cluster1", false),
+	)
+
+	dao.processClusterDelete(context.Background(), clusterDelete{cluster: "cluster1", queued: queued})
+
+	assert.Equal(t, stopped+1, testutil.ToFloat64(metrics.ClusterDeleteJobs.WithLabelValues("stopped")))
+	assert.Contains(t, quotaUsage, "cluster1")
+}
+
+// Should only cancel the pending deletes that keep the Cluster node.
+func Test_CancelClusterDelete(t *testing.T) {
+	setClusterDeleteQueue(t, 1, 100)
+	dao, mockPool := buildMockDAO(t)
+	clusterDeletes.pending["cluster1"] = struct{}{}
+	clusterDeletes.running["cluster1"] = struct{}{} // A running delete stops before its next chunk.
+	mockPool.EXPECT().Exec(gomock.Any(), "DELETE FROM search.cluster_deletes WHERE cluster=$1 AND NOT delete_node",
+		"cluster1").Return(pgconn.CommandTag("DELETE 1"), nil)
+
+	dao.CancelClusterDelete(context.Background(), "cluster1")
+	dao.CancelClusterDelete(context.Background(), "cluster2") // Not pending, no query.
+
+	assert.NotContains(t, clusterDeletes.pending, "cluster1")
+}
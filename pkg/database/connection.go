@@ -13,6 +13,7 @@ import (
 	"github.com/jackc/pgx/v4"
 	pgxpool "github.com/jackc/pgx/v4/pgxpool"
 	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
 	"k8s.io/klog/v2"
 )
 
@@ -109,6 +110,9 @@ func initializePool() pgxpoolmock.PgxPool {
 		}
 	}
 
+	// Export the pool statistics with the indexer metrics.
+	metrics.PromRegistry.MustRegister(newPoolCollector(func() poolStat { return getPoolStat(conn) }))
+
 	return conn
 }
 
@@ -121,12 +125,58 @@ func (dao *DAO) InitializeTables(ctx context.Context) {
 
 	_, err := dao.pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS search")
 	checkError(err, "Error creating schema.")
+	if config.Cfg.DBPartitionMode != "none" && dao.initializePartitionedTables(ctx) {
+		partitionMode = config.Cfg.DBPartitionMode
+	} else {
+		_, err = dao.pool.Exec(ctx,
+			"CREATE TABLE IF NOT EXISTS search.resources (uid TEXT PRIMARY KEY, cluster TEXT, data JSONB)")
+		checkError(err, "Error creating table search.resources.")
+		_, err = dao.pool.Exec(ctx,
+			"CREATE TABLE IF NOT EXISTS search.edges (sourceId TEXT, sourceKind TEXT,destId TEXT,destKind TEXT,edgeType TEXT,cluster TEXT, PRIMARY KEY(sourceId, destId, edgeType))")
+		checkError(err, "Error creating table search.edges.")
+	}
+
+	// Resync generation. Rows with an older generation are deleted when a resync completes.
 	_, err = dao.pool.Exec(ctx,
-		"CREATE TABLE IF NOT EXISTS search.resources (uid TEXT PRIMARY KEY, cluster TEXT, data JSONB)")
-	checkError(err, "Error creating table search.resources.")
+		"ALTER TABLE search.resources ADD COLUMN IF NOT EXISTS generation BIGINT NOT NULL DEFAULT 0")
+	checkError(err, "Error adding column generation to search.resources.")
 	_, err = dao.pool.Exec(ctx,
-		"CREATE TABLE IF NOT EXISTS search.edges (sourceId TEXT, sourceKind TEXT,destId TEXT,destKind TEXT,edgeType TEXT,cluster TEXT, PRIMARY KEY(sourceId, destId, edgeType))")
-	checkError(err, "Error creating table search.edges.")
+		"ALTER TABLE search.edges ADD COLUMN IF NOT EXISTS generation BIGINT NOT NULL DEFAULT 0")
+	checkError(err, "Error adding column generation to search.edges.")
+	_, err = dao.pool.Exec(ctx,
+		"CREATE TABLE IF NOT EXISTS search.generations (cluster TEXT PRIMARY KEY, generation BIGINT NOT NULL)")
+	checkError(err, "Error creating table search.generations.")
+
+	// Staging tables for the atomic resync. Unlogged, the staged rows aren't needed after a crash.
+	if config.Cfg.ResyncMode == "atomic" {
+		_, err = dao.pool.Exec(ctx,
+			"CREATE UNLOGGED TABLE IF NOT EXISTS search.resources_staging (uid TEXT, cluster TEXT, data JSONB, generation BIGINT, PRIMARY KEY(cluster, generation, uid))")
+		checkError(err, "Error creating table search.resources_staging.")
+		_, err = dao.pool.Exec(ctx,
+			"CREATE UNLOGGED TABLE IF NOT EXISTS search.edges_staging (sourceId TEXT, sourceKind TEXT,destId TEXT,destKind TEXT,edgeType TEXT,cluster TEXT, generation BIGINT, PRIMARY KEY(cluster, generation, sourceId, destId, edgeType))")
+		checkError(err, "Error creating table search.edges_staging.")
+	}
+
+	// Types of the properties for each kind, used to normalize the property values.
+	if config.Cfg.PropertyTypesMode != "off" {
+		_, err = dao.pool.Exec(ctx,
+			"CREATE TABLE IF NOT EXISTS search.property_types (kind TEXT, property TEXT, type TEXT NOT NULL, configured BOOLEAN NOT NULL DEFAULT false, PRIMARY KEY(kind, property))")
+		checkError(err, "Error creating table search.property_types.")
+	}
+
+	// Property names and sample values for autocompletion.
+	if config.Cfg.PropertySamples > 0 {
+		_, err = dao.pool.Exec(ctx,
+			"CREATE TABLE IF NOT EXISTS search.property_catalog (cluster TEXT, kind TEXT, property TEXT, type TEXT, samples JSONB NOT NULL, cardinality INTEGER NOT NULL, PRIMARY KEY(cluster, kind, property))")
+		checkError(err, "Error creating table search.property_catalog.")
+	}
+
+	// Clusters waiting to be deleted by the cluster delete queue. Survives a new leader.
+	if config.Cfg.ClusterDeleteWorkers > 0 {
+		_, err = dao.pool.Exec(ctx,
+			"CREATE TABLE IF NOT EXISTS search.cluster_deletes (cluster TEXT PRIMARY KEY, delete_node BOOLEAN NOT NULL, queued TIMESTAMPTZ NOT NULL DEFAULT now())")
+		checkError(err, "Error creating table search.cluster_deletes.")
+	}
 
 	// Jsonb indexing data keys:
 	_, err = dao.pool.Exec(ctx,
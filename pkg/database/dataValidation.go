@@ -8,11 +8,18 @@ import (
 
 	"github.com/doug-martin/goqu/v9"
 	pgx "github.com/jackc/pgx/v4"
-	"k8s.io/klog/v2"
+	"github.com/stolostron/search-indexer/pkg/logging"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Query resource and edge count for a cluster. Used for data validation.
 func (dao *DAO) ClusterTotals(ctx context.Context, clusterName string) (resources int, edges int, e error) {
+	defer metrics.QueryTimer("clusterTotals")()
+	ctx, span := tracing.StartSpan(ctx, "ClusterTotals", attribute.String("cluster", clusterName))
+	defer span.End()
+	logger := logging.Operation(ctx, "clusterTotals")
 	batch := &pgx.Batch{}
 
 	// Sample query: SELECT count(*) FROM search.resources WHERE cluster=$1
@@ -25,8 +32,7 @@ func (dao *DAO) ClusterTotals(ctx context.Context, clusterName string) (resource
 
 	checkError(err, fmt.Sprintf("Error creating query to count resources in cluster %s:%s ",
 		clusterName, err))
-	klog.V(4).Infof("Data validation query for resource count in cluster %s - sql: %s args: %+v",
-		clusterName, resourceCountSql, params)
+	logger.V(4).Info("Data validation query for resource count", "sql", resourceCountSql, "args", params)
 	batch.Queue(resourceCountSql, params...)
 
 	// Sample query: SELECT count(*) FROM search.edges WHERE cluster=$1 and edgetype<>'interCluster'
@@ -34,8 +40,7 @@ func (dao *DAO) ClusterTotals(ctx context.Context, clusterName string) (resource
 		Select(goqu.COUNT("*")).
 		Where(goqu.C("cluster").Eq(clusterName),
 			goqu.C("edgetype").Neq("interCluster")).ToSQL()
-	klog.V(4).Infof("Data validation query for edge count in cluster %s - sql: %s args: %+v",
-		clusterName, edgeCountSql, params)
+	logger.V(4).Info("Data validation query for edge count", "sql", edgeCountSql, "args", params)
 	checkError(err, fmt.Sprintf("Error creating query to count edges in cluster %s:%s ",
 		clusterName, err))
 	batch.Queue(edgeCountSql, params...)
@@ -48,15 +53,16 @@ func (dao *DAO) ClusterTotals(ctx context.Context, clusterName string) (resource
 	resourcesRow := br.QueryRow()
 	resourcesErr := resourcesRow.Scan(&resources)
 	if resourcesErr != nil {
-		klog.Error("Error reading total resources for cluster ", clusterName, " err: ", resourcesErr)
+		logger.Error(resourcesErr, "Error reading total resources")
 		return resources, edges, resourcesErr
 	}
 	edgesRow := br.QueryRow()
 	edgesErr := edgesRow.Scan(&edges)
 	if edgesErr != nil {
-		klog.Error("Error reading total edges for cluster ", clusterName, " err: ", edgesErr)
+		logger.Error(edgesErr, "Error reading total edges")
 		return resources, edges, edgesErr
 	}
+	recordClusterTotals(clusterName, resources, edges)
 
 	return resources, edges, nil
 }
@@ -28,7 +28,7 @@ func Test_ClusterTotals(t *testing.T) {
 	batch.Queue(`SELECT COUNT(*) FROM "search"."resources" WHERE (("cluster" = 'cluster_foo') AND ("uid" != 'cluster__cluster_foo'))`, []interface{}{}...)
 	batch.Queue(`SELECT COUNT(*) FROM "search"."edges" WHERE (("cluster" = 'cluster_foo') AND ("edgetype" != 'interCluster'))`, []interface{}{}...)
 
-	mockPool.EXPECT().SendBatch(context.Background(), batch).Return(br).Times(2)
+	mockPool.EXPECT().SendBatch(gomock.Any(), batch).Return(br).Times(2)
 	// Execute function test.
 	resourceCount, edgeCount, err := dao.ClusterTotals(context.Background(), "cluster_foo")
 
@@ -49,7 +49,7 @@ func Test_ClusterTotals_withErrorQueryingResources(t *testing.T) {
 		},
 	}
 	// mock queries
-	mockPool.EXPECT().SendBatch(context.Background(), gomock.Any()).Return(br)
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br)
 
 	// Execute function test.
 	resourceCount, edgeCount, err := dao.ClusterTotals(context.Background(), "cluster_foo")
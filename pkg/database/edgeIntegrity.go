@@ -0,0 +1,230 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"k8s.io/klog/v2"
+)
+
+// Referential integrity of the edges.
+//
+// EDGE_VALIDATION checks that the source and destination of the added edges are resources of the request or are in
+// the database. A resync replaces the resources of the cluster, so it only reads the resources of other clusters and
+// the Cluster node from the database. With warn, edges to missing resources are written, logged, and counted. With
+// strict, they are rejected and returned in the add edge errors.
+//
+// Edges can still lose a resource, like the interCluster edges to a deleted cluster. With ORPHAN_EDGE_CLEANUP_MS, the
+// leader periodically deletes the edges whose source or destination doesn't exist. An edge can be written before its
+// resource when they are in different batches, so the orphan edges found are only deleted if they are still orphans
+// after orphanEdgeGrace.
+
+// Time between finding the orphan edges and deleting them.
+var orphanEdgeGrace = 30 * time.Second
+
+// Validates the edges of a sync or resync. A nil validator accepts all edges.
+type edgeValidator struct {
+	dao         *DAO
+	clusterName string
+	resync      bool
+	known       map[string]bool // Resources checked, true if they exist.
+}
+
+// Start validating the edges of a request. Returns nil when EDGE_VALIDATION is off.
+func (dao *DAO) newEdgeValidator(clusterName string, resync bool) *edgeValidator {
+	if config.Cfg.EdgeValidation != "warn" && config.Cfg.EdgeValidation != "strict" {
+		return nil
+	}
+	return &edgeValidator{dao: dao, clusterName: clusterName, resync: resync, known: map[string]bool{}}
+}
+
+// Record a resource written by the request.
+func (v *edgeValidator) addResource(uid string) {
+	if v != nil {
+		v.known[uid] = true
+	}
+}
+
+// Record a resource deleted by the request.
+func (v *edgeValidator) deleteResource(uid string) {
+	if v != nil {
+		v.known[uid] = false
+	}
+}
+
+// Read the resources of the edges that aren't known yet with a single query. If the query fails, the resources are
+// assumed to exist.
+func (v *edgeValidator) lookup(ctx context.Context, edges []model.Edge) {
+	if v == nil {
+		return
+	}
+	uids := make([]string, 0)
+	for _, edge := range edges {
+		for _, uid := range []string{edge.SourceUID, edge.DestUID} {
+			if _, ok := v.known[uid]; !ok {
+				v.known[uid] = false
+				uids = append(uids, uid)
+			}
+		}
+	}
+	if len(uids) == 0 {
+		return
+	}
+	defer metrics.QueryTimer("edgeResources")()
+	query := "SELECT uid FROM search.resources WHERE uid=ANY($1)"
+	args := []interface{}{uids}
+	if v.resync {
+		query += " AND (cluster!=$2 OR uid=$3)"
+		args = append(args, v.clusterName, "cluster__"+v.clusterName)
+	}
+	rows, err := v.dao.pool.Query(ctx, query, args...)
+	if err != nil {
+		klog.Warningf("Error reading the resources of the edges of cluster %s. The edges aren't validated. %s",
+			v.clusterName, err)
+		for _, uid := range uids {
+			v.known[uid] = true
+		}
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uid string
+		if err := rows.Scan(&uid); err == nil {
+			v.known[uid] = true
+		}
+	}
+}
+
+// Validate the source and destination of the edge. Returns an error if the edge is rejected.
+func (v *edgeValidator) validate(ctx context.Context, edge model.Edge) error {
+	if v == nil {
+		return nil
+	}
+	v.lookup(ctx, []model.Edge{edge})
+	missing := edge.SourceUID
+	if v.known[edge.SourceUID] {
+		if v.known[edge.DestUID] {
+			return nil
+		}
+		missing = edge.DestUID
+	}
+	if config.Cfg.EdgeValidation != "strict" {
+		metrics.EdgeValidationFailures.WithLabelValues("warn").Inc()
+		klog.V(3).Infof("Edge %s -%s-> %s of cluster %s references resource %s, which doesn't exist.",
+			edge.SourceUID, edge.EdgeType, edge.DestUID, v.clusterName, missing)
+		return nil
+	}
+	metrics.EdgeValidationFailures.WithLabelValues("reject").Inc()
+	return fmt.Errorf("edge %s -%s-> %s references resource %s, which doesn't exist", edge.SourceUID, edge.EdgeType,
+		edge.DestUID, missing)
+}
+
+// Edges whose source or destination doesn't exist.
+const findOrphanEdgesQuery = `SELECT sourceid, destid, edgetype, cluster FROM search.edges e
+	WHERE NOT EXISTS (SELECT 1 FROM search.resources r WHERE r.uid=e.sourceid)
+	OR NOT EXISTS (SELECT 1 FROM search.resources r WHERE r.uid=e.destid) LIMIT $1`
+
+// Delete the edges found earlier that are still orphans.
+const deleteOrphanEdgesQuery = `DELETE FROM search.edges e
+	USING unnest($1::text[], $2::text[], $3::text[], $4::text[]) AS o(sourceid, destid, edgetype, cluster)
+	WHERE e.sourceid=o.sourceid AND e.destid=o.destid AND e.edgetype=o.edgetype AND e.cluster=o.cluster
+	AND (NOT EXISTS (SELECT 1 FROM search.resources r WHERE r.uid=e.sourceid)
+	OR NOT EXISTS (SELECT 1 FROM search.resources r WHERE r.uid=e.destid)) RETURNING e.edgetype`
+
+// StartOrphanEdgeCleanup deletes the orphan edges every ORPHAN_EDGE_CLEANUP_MS until the context is canceled. Runs
+// on the leader.
+func (dao *DAO) StartOrphanEdgeCleanup(ctx context.Context) {
+	if config.Cfg.OrphanEdgeCleanupMS <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(config.Cfg.OrphanEdgeCleanupMS) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			klog.Info("Exit orphan edge cleanup.")
+			return
+		case <-ticker.C:
+			dao.deleteOrphanEdges(ctx)
+		}
+	}
+}
+
+// Delete the orphan edges in batches of DB_BATCH_SIZE. Returns the number of edges deleted.
+func (dao *DAO) deleteOrphanEdges(ctx context.Context) int {
+	deleted := 0
+	for {
+		sources, dests, edgeTypes, clusters, err := dao.findOrphanEdges(ctx)
+		if err != nil {
+			klog.Warningf("Error finding orphan edges. %s", err)
+			break
+		}
+		if len(sources) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return deleted
+		case <-time.After(orphanEdgeGrace):
+		}
+		batchDeleted, err := dao.deleteOrphanEdgeBatch(ctx, sources, dests, edgeTypes, clusters)
+		deleted += batchDeleted
+		if err != nil {
+			klog.Warningf("Error deleting orphan edges. %s", err)
+			break
+		}
+		// Stop when all the orphans were found, or none of them are orphans anymore.
+		if len(sources) < dao.batchSize || batchDeleted == 0 {
+			break
+		}
+	}
+	if deleted > 0 {
+		klog.Infof("Deleted %d orphan edges.", deleted)
+	}
+	return deleted
+}
+
+func (dao *DAO) findOrphanEdges(ctx context.Context) (sources, dests, edgeTypes, clusters []string, err error) {
+	defer metrics.QueryTimer("findOrphanEdges")()
+	rows, err := dao.pool.Query(ctx, findOrphanEdgesQuery, dao.batchSize)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source, dest, edgeType, cluster string
+		if err = rows.Scan(&source, &dest, &edgeType, &cluster); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		sources = append(sources, source)
+		dests = append(dests, dest)
+		edgeTypes = append(edgeTypes, edgeType)
+		clusters = append(clusters, cluster)
+	}
+	return sources, dests, edgeTypes, clusters, rows.Err()
+}
+
+func (dao *DAO) deleteOrphanEdgeBatch(ctx context.Context, sources, dests, edgeTypes, clusters []string) (int, error) {
+	defer metrics.QueryTimer("deleteOrphanEdges")()
+	rows, err := dao.pool.Query(ctx, deleteOrphanEdgesQuery, sources, dests, edgeTypes, clusters)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	deleted := 0
+	for rows.Next() {
+		var edgeType string
+		if err = rows.Scan(&edgeType); err != nil {
+			return deleted, err
+		}
+		metrics.OrphanEdgesDeleted.WithLabelValues(edgeType).Inc()
+		deleted++
+	}
+	return deleted, rows.Err()
+}
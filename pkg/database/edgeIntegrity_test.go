@@ -0,0 +1,120 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stolostron/search-indexer/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func setEdgeValidation(t *testing.T, mode string) {
+	saved := config.Cfg.EdgeValidation
+	config.Cfg.EdgeValidation = mode
+	t.Cleanup(func() { config.Cfg.EdgeValidation = saved })
+}
+
+// Should reject the edges to resources that aren't in the request or the database.
+func Test_edgeValidator_strict(t *testing.T) {
+	setEdgeValidation(t, "strict")
+	dao, mockPool := buildMockDAO(t)
+	rejected := testutil.ToFloat64(metrics.EdgeValidationFailures.WithLabelValues("reject"))
+	rows := pgxpoolmock.NewRows([]string{"uid"}).AddRow("cluster1/pod-2").ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), "SELECT uid FROM search.resources WHERE uid=ANY($1)",
+		[]string{"cluster1/pod-2", "cluster1/pod-3"}).Return(rows, nil)
+	edges := []model.Edge{
+		{SourceUID: "cluster1/pod-1", DestUID: "cluster1/pod-2", EdgeType: "runsOn"},
+		{SourceUID: "cluster1/pod-1", DestUID: "cluster1/pod-3", EdgeType: "runsOn"},
+		{SourceUID: "cluster1/pod-4", DestUID: "cluster1/pod-2", EdgeType: "runsOn"},
+	}
+
+	validator := dao.newEdgeValidator("cluster1", false)
+	validator.addResource("cluster1/pod-1")
+	validator.deleteResource("cluster1/pod-4")
+	validator.lookup(context.Background(), edges)
+
+	assert.Nil(t, validator.validate(context.Background(), edges[0]))
+	assert.EqualError(t, validator.validate(context.Background(), edges[1]),
+		"edge cluster1/pod-1 -runsOn-> cluster1/pod-3 references resource cluster1/pod-3, which doesn't exist")
+	assert.EqualError(t, validator.validate(context.Background(), edges[2]),
+		"edge cluster1/pod-4 -runsOn-> cluster1/pod-2 references resource cluster1/pod-4, which doesn't exist")
+	assert.Equal(t, rejected+2, testutil.ToFloat64(metrics.EdgeValidationFailures.WithLabelValues("reject")))
+}
+
+// Should accept the edges to missing resources with warn, and only read other clusters on resync.
+func Test_edgeValidator_warnResync(t *testing.T) {
+	setEdgeValidation(t, "warn")
+	dao, mockPool := buildMockDAO(t)
+	warned := testutil.ToFloat64(metrics.EdgeValidationFailures.WithLabelValues("warn"))
+	rows := pgxpoolmock.NewRows([]string{"uid"}).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(),
+		"SELECT uid FROM search.resources WHERE uid=ANY($1) AND (cluster!=$2 OR uid=$3)",
+		[]string{"cluster1/pod-1", "cluster__cluster1"}, "cluster1", "cluster__cluster1").Return(rows, nil)
+
+	validator := dao.newEdgeValidator("cluster1", true)
+	err := validator.validate(context.Background(),
+		model.Edge{SourceUID: "cluster1/pod-1", DestUID: "cluster__cluster1", EdgeType: "in"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, warned+1, testutil.ToFloat64(metrics.EdgeValidationFailures.WithLabelValues("warn")))
+}
+
+// Should return the rejected edges in the add edge errors.
+func Test_SyncData_edgeValidation(t *testing.T) {
+	setEdgeValidation(t, "strict")
+	dao, mockPool := buildMockDAO(t)
+	dao.batchSize = 1
+	br := &testutils.MockBatchResults{}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(6)
+	rows := pgxpoolmock.NewRows([]string{"uid"}).AddRow("local-cluster/00000000-0000-0000-0000-000000000881").
+		ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), "SELECT uid FROM search.resources WHERE uid=ANY($1)", []string{
+		"local-cluster/00000000-0000-0000-0000-000000000880", "local-cluster/00000000-0000-0000-0000-000000000881"}).
+		Return(rows, nil)
+
+	response := &model.SyncResponse{}
+	err := dao.SyncData(context.Background(), loadSimpleSyncEvent(t), "local-cluster", response)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, response.TotalEdgesAdded)
+	assert.Len(t, response.AddEdgeErrors, 1)
+	assert.Equal(t, "local-cluster/00000000-0000-0000-0000-000000000880", response.AddEdgeErrors[0].ResourceUID)
+}
+
+func Test_edgeValidator_off(t *testing.T) {
+	dao, _ := buildMockDAO(t)
+
+	validator := dao.newEdgeValidator("cluster1", false)
+
+	assert.Nil(t, validator)
+	assert.Nil(t, validator.validate(context.Background(), model.Edge{SourceUID: "a", DestUID: "b"}))
+}
+
+// Should delete the orphan edges that are still orphans after the grace period.
+func Test_deleteOrphanEdges(t *testing.T) {
+	savedGrace := orphanEdgeGrace
+	orphanEdgeGrace = 0
+	t.Cleanup(func() { orphanEdgeGrace = savedGrace })
+	dao, mockPool := buildMockDAO(t)
+	dao.batchSize = 2
+	deleted := testutil.ToFloat64(metrics.OrphanEdgesDeleted.WithLabelValues("interCluster"))
+	found := pgxpoolmock.NewRows([]string{"sourceid", "destid", "edgetype", "cluster"}).
+		AddRow("cluster1/pod-1", "cluster2/pod-1", "interCluster", "_interCluster").ToPgxRows()
+	deletedRows := pgxpoolmock.NewRows([]string{"edgetype"}).AddRow("interCluster").ToPgxRows()
+	gomock.InOrder(
+		mockPool.EXPECT().Query(gomock.Any(), findOrphanEdgesQuery, 2).Return(found, nil),
+		mockPool.EXPECT().Query(gomock.Any(), deleteOrphanEdgesQuery, []string{"cluster1/pod-1"},
+			[]string{"cluster2/pod-1"}, []string{"interCluster"}, []string{"_interCluster"}).Return(deletedRows, nil),
+	)
+
+	assert.Equal(t, 1, dao.deleteOrphanEdges(context.Background()))
+	assert.Equal(t, deleted+1, testutil.ToFloat64(metrics.OrphanEdgesDeleted.WithLabelValues("interCluster")))
+}
@@ -14,6 +14,8 @@ func useGoqu(query string, params []interface{}) (q string, p []interface{}, er
 	dialect := goqu.Dialect("postgres")
 	resources := goqu.S("search").Table("resources")
 	edges := goqu.S("search").Table("edges")
+	resourcesStaging := goqu.S("search").Table("resources_staging")
+	edgesStaging := goqu.S("search").Table("edges_staging")
 
 	validateParams := func(expectedParams int) bool {
 		if len(params) != expectedParams {
@@ -37,7 +39,7 @@ func useGoqu(query string, params []interface{}) (q string, p []interface{}, er
 		}
 		q, p, er = dialect.From(resources).Prepared(true).
 			Insert().Rows(goqu.Record{"uid": params[0], "cluster": params[1], "data": params[2]}).
-			OnConflict(goqu.DoUpdate("uid", goqu.C("data").Set(params[2])).
+			OnConflict(goqu.DoUpdate(resourcesConflictTarget(), goqu.C("data").Set(params[2])).
 				Where(resources.Col("data").Neq(params[2]))).ToSQL()
 
 	// Cluster-scoped resync variant: only overwrites the row when it belongs to this cluster.
@@ -47,9 +49,32 @@ func useGoqu(query string, params []interface{}) (q string, p []interface{}, er
 		}
 		q, p, er = dialect.From(resources).Prepared(true).
 			Insert().Rows(goqu.Record{"uid": params[0], "cluster": params[1], "data": params[2]}).
-			OnConflict(goqu.DoUpdate("uid", goqu.C("data").Set(params[2])).
+			OnConflict(goqu.DoUpdate(resourcesConflictTarget(), goqu.C("data").Set(params[2])).
 				Where(resources.Col("cluster").Eq(params[1]), resources.Col("data").Neq(params[2]))).ToSQL()
 
+	// Resync variant: marks the row with the resync generation even if the data didn't change. The data is only
+	// replaced when it changed.
+	case "INSERT into search.resources values($1,$2,$3,$4) ON CONFLICT (uid) DO UPDATE SET data=CASE WHEN r.data IS DISTINCT FROM $3 THEN $3 ELSE r.data END, generation=$4 WHERE r.cluster=$2":
+		if !validateParams(4) {
+			break
+		}
+		q, p, er = dialect.From(resources).Prepared(true).
+			Insert().Rows(goqu.Record{"uid": params[0], "cluster": params[1], "data": params[2], "generation": params[3]}).
+			OnConflict(goqu.DoUpdate(resourcesConflictTarget(), goqu.Record{
+				"data": goqu.L("CASE WHEN ? IS DISTINCT FROM ? THEN ? ELSE ? END", resources.Col("data"),
+					goqu.T("excluded").Col("data"), goqu.T("excluded").Col("data"), resources.Col("data")),
+				"generation": params[3]}).
+				Where(resources.Col("cluster").Eq(params[1]))).ToSQL()
+
+	// Atomic resync: stages the resource until the resync is swapped in.
+	case "INSERT into search.resources_staging values($1,$2,$3,$4) ON CONFLICT (cluster, generation, uid) DO UPDATE SET data=$3":
+		if !validateParams(4) {
+			break
+		}
+		q, p, er = dialect.From(resourcesStaging).Prepared(true).
+			Insert().Rows(goqu.Record{"uid": params[0], "cluster": params[1], "data": params[2], "generation": params[3]}).
+			OnConflict(goqu.DoUpdate("cluster, generation, uid", goqu.C("data").Set(params[2]))).ToSQL()
+
 	case "UPDATE search.resources SET data=$2 WHERE uid=$1 AND cluster=$3":
 		if !validateParams(3) {
 			break
@@ -58,18 +83,6 @@ func useGoqu(query string, params []interface{}) (q string, p []interface{}, er
 			Update().Set(goqu.Record{"data": params[1].(string)}).
 			Where(goqu.C("uid").Eq(params[0]), goqu.C("cluster").Eq(params[2])).ToSQL()
 
-	case "DELETE from search.resources WHERE cluster=$1 AND uid NOT IN ($2)":
-		q, p, er = dialect.From(resources).
-			Delete().Where(goqu.C("cluster").Eq(params[0]), goqu.C("uid").NotIn(params[1])).ToSQL()
-
-	case "DELETE from search.edges WHERE cluster=$1 AND sourceid NOT IN ($2) OR destid NOT IN ($2)":
-		q, p, er = dialect.From(edges).
-			Delete().Where(
-			goqu.C("cluster").Eq(params[0]),
-			goqu.Or(
-				goqu.C("sourceid").NotIn(params[1]),
-				goqu.C("destid").NotIn(params[1]))).ToSQL()
-
 	// Queries for EDGES table.
 	case "SELECT sourceid, edgetype, destid FROM search.edges WHERE edgetype!='interCluster' AND cluster=$1":
 		q, p, er = dialect.From(edges).Prepared(true).
@@ -82,6 +95,26 @@ func useGoqu(query string, params []interface{}) (q string, p []interface{}, er
 			Insert().Cols("sourceid", "sourcekind", "destid", "destkind", "edgetype", "cluster").Vals(params).
 			OnConflict(goqu.DoNothing()).ToSQL()
 
+	// Resync variant: marks the existing edge with the resync generation.
+	case "INSERT into search.edges values($1,$2,$3,$4,$5,$6,$7) ON CONFLICT (sourceid, destid, edgetype) DO UPDATE SET generation=$7 WHERE cluster=$6":
+		if !validateParams(7) {
+			break
+		}
+		q, p, er = dialect.From(edges).Prepared(true).
+			Insert().Cols("sourceid", "sourcekind", "destid", "destkind", "edgetype", "cluster", "generation").
+			Vals(params).
+			OnConflict(goqu.DoUpdate(edgesConflictTarget(), goqu.Record{"generation": params[6]}).
+				Where(edges.Col("cluster").Eq(params[5]))).ToSQL()
+
+	// Atomic resync: stages the edge until the resync is swapped in.
+	case "INSERT into search.edges_staging values($1,$2,$3,$4,$5,$6,$7) ON CONFLICT DO NOTHING":
+		if !validateParams(7) {
+			break
+		}
+		q, p, er = dialect.From(edgesStaging).Prepared(true).
+			Insert().Cols("sourceid", "sourcekind", "destid", "destkind", "edgetype", "cluster", "generation").
+			Vals(params).OnConflict(goqu.DoNothing()).ToSQL()
+
 	case "DELETE from search.edges WHERE sourceid=$1 AND destid=$2 AND edgetype=$3 AND cluster=$4":
 		if !validateParams(4) {
 			break
@@ -71,3 +71,41 @@ func Test_useGoqu_deleteEdge_wrongParamCount(t *testing.T) {
 	assert.Nil(t, p)
 	assert.NotNil(t, er)
 }
+
+// Test that the resync upserts mark the existing rows with the generation.
+func Test_useGoqu_resyncUpsert_generation(t *testing.T) {
+	q, p, er := useGoqu(
+		"INSERT into search.resources values($1,$2,$3,$4) ON CONFLICT (uid) DO UPDATE SET data=CASE WHEN r.data IS DISTINCT FROM $3 THEN $3 ELSE r.data END, generation=$4 WHERE r.cluster=$2",
+		[]interface{}{"my-cluster/abc-123", "my-cluster", `{"kind":"Pod"}`, int64(2)})
+
+	assert.Nil(t, er)
+	assert.Contains(t, q, `DO UPDATE SET "data"=CASE WHEN "search"."resources"."data" IS DISTINCT FROM "excluded"."data" THEN "excluded"."data" ELSE "search"."resources"."data" END,"generation"=$5`)
+	assert.Contains(t, p, int64(2))
+
+	q, p, er = useGoqu(
+		"INSERT into search.edges values($1,$2,$3,$4,$5,$6,$7) ON CONFLICT (sourceid, destid, edgetype) DO UPDATE SET generation=$7 WHERE cluster=$6",
+		[]interface{}{"my-cluster/src", "Pod", "my-cluster/dst", "Node", "runsOn", "my-cluster", int64(2)})
+
+	assert.Nil(t, er)
+	assert.Contains(t, q, `ON CONFLICT (sourceid, destid, edgetype) DO UPDATE SET "generation"`)
+	assert.Contains(t, p, int64(2))
+}
+
+// Test that the atomic resync writes to the staging tables.
+func Test_useGoqu_resyncStaging(t *testing.T) {
+	q, p, er := useGoqu(
+		"INSERT into search.resources_staging values($1,$2,$3,$4) ON CONFLICT (cluster, generation, uid) DO UPDATE SET data=$3",
+		[]interface{}{"my-cluster/abc-123", "my-cluster", `{"kind":"Pod"}`, int64(2)})
+
+	assert.Nil(t, er)
+	assert.Contains(t, q, `INSERT INTO "search"."resources_staging"`)
+	assert.Contains(t, p, int64(2))
+
+	q, p, er = useGoqu(
+		"INSERT into search.edges_staging values($1,$2,$3,$4,$5,$6,$7) ON CONFLICT DO NOTHING",
+		[]interface{}{"my-cluster/src", "Pod", "my-cluster/dst", "Node", "runsOn", "my-cluster", int64(2)})
+
+	assert.Nil(t, er)
+	assert.Contains(t, q, `INSERT INTO "search"."edges_staging"`)
+	assert.Equal(t, 7, len(p))
+}
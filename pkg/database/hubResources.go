@@ -0,0 +1,186 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jackc/pgx/v4"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"k8s.io/klog/v2"
+)
+
+// Hub resources and their edges are written by the indexer (clustersync), not by a collector. They use an empty
+// cluster, so they aren't affected by the resync or delete of any cluster.
+const hubResourceCluster = ""
+
+// Insert or update a hub resource and replace all edges from the resource with the given edges.
+func (dao *DAO) UpsertHubResource(ctx context.Context, resource model.Resource, edges []model.Edge) error {
+	defer metrics.QueryTimer("upsertHubResource")()
+	data, err := json.Marshal(resource.Properties)
+	if err != nil {
+		klog.Errorf("Error marshaling properties for hub resource %s. %s", resource.UID, err)
+		return err
+	}
+	upsertSql, upsertArgs, err := goquInsertUpdate("resources",
+		[]interface{}{resource.UID, hubResourceCluster, string(data)})
+	checkError(err, fmt.Sprintf("Error creating insert/update query for hub resource %s.", resource.UID))
+	if err != nil {
+		return err
+	}
+
+	// DELETE FROM search.edges WHERE sourceid = '<uid>'
+	deleteEdgesSql, deleteEdgesArgs, err := goqu.From(goqu.S("search").Table("edges")).
+		Delete().Where(goqu.C("sourceid").Eq(resource.UID)).ToSQL()
+	checkError(err, fmt.Sprintf("Error creating query to delete edges for hub resource %s.", resource.UID))
+	if err != nil {
+		return err
+	}
+
+	tx, err := dao.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		klog.Error("Error while beginning transaction block for hub resource ", resource.UID)
+		return err
+	}
+	if _, err = tx.Exec(ctx, upsertSql, upsertArgs...); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error inserting/updating hub resource %s.", resource.UID), tx, ctx)
+		return err
+	}
+	if _, err = tx.Exec(ctx, deleteEdgesSql, deleteEdgesArgs...); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error deleting edges for hub resource %s.", resource.UID), tx, ctx)
+		return err
+	}
+	if len(edges) > 0 {
+		insertEdgesSql, insertEdgesArgs, err := goquInsertEdges(edges, hubResourceCluster)
+		checkError(err, fmt.Sprintf("Error creating query to insert edges for hub resource %s.", resource.UID))
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+		if _, err = tx.Exec(ctx, insertEdgesSql, insertEdgesArgs...); err != nil {
+			checkErrorAndRollback(err, fmt.Sprintf("Error inserting edges for hub resource %s.", resource.UID), tx, ctx)
+			return err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error committing hub resource %s.", resource.UID), tx, ctx)
+		return err
+	}
+	klog.V(4).Infof("Upserted hub resource %s with %d edges.", resource.UID, len(edges))
+	return nil
+}
+
+// Delete a hub resource and all edges from or to the resource.
+func (dao *DAO) DeleteHubResource(ctx context.Context, uid string) error {
+	defer metrics.QueryTimer("deleteHubResource")()
+	deleteResourceSql, deleteResourceArgs, err := goquDelete("resources", "uid", uid)
+	checkError(err, fmt.Sprintf("Error creating query to delete hub resource %s.", uid))
+	if err != nil {
+		return err
+	}
+	// DELETE FROM search.edges WHERE sourceid = '<uid>' OR destid = '<uid>'
+	deleteEdgesSql, deleteEdgesArgs, err := goqu.From(goqu.S("search").Table("edges")).
+		Delete().Where(goqu.Or(goqu.C("sourceid").Eq(uid), goqu.C("destid").Eq(uid))).ToSQL()
+	checkError(err, fmt.Sprintf("Error creating query to delete edges for hub resource %s.", uid))
+	if err != nil {
+		return err
+	}
+
+	tx, err := dao.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		klog.Error("Error while beginning transaction block for deleting hub resource ", uid)
+		return err
+	}
+	if _, err = tx.Exec(ctx, deleteResourceSql, deleteResourceArgs...); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error deleting hub resource %s.", uid), tx, ctx)
+		return err
+	}
+	if _, err = tx.Exec(ctx, deleteEdgesSql, deleteEdgesArgs...); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error deleting edges for hub resource %s.", uid), tx, ctx)
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error committing delete of hub resource %s.", uid), tx, ctx)
+		return err
+	}
+	klog.V(4).Infof("Deleted hub resource %s.", uid)
+	return nil
+}
+
+// Replace the edges of the given type from a resource. Used for edges between Cluster nodes, which are written by
+// the indexer and aren't owned by the source cluster. An empty list deletes the edges.
+func (dao *DAO) ReplaceHubEdges(ctx context.Context, sourceUID, edgeType string, edges []model.Edge) error {
+	defer metrics.QueryTimer("replaceHubEdges")()
+	// DELETE FROM search.edges WHERE sourceid = '<uid>' AND edgetype = '<edgeType>' AND cluster = ''
+	deleteEdgesSql, deleteEdgesArgs, err := goqu.From(goqu.S("search").Table("edges")).
+		Delete().Where(goqu.C("sourceid").Eq(sourceUID), goqu.C("edgetype").Eq(edgeType),
+		goqu.C("cluster").Eq(hubResourceCluster)).ToSQL()
+	checkError(err, fmt.Sprintf("Error creating query to delete %s edges for %s.", edgeType, sourceUID))
+	if err != nil {
+		return err
+	}
+
+	tx, err := dao.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		klog.Errorf("Error while beginning transaction block for %s edges from %s.", edgeType, sourceUID)
+		return err
+	}
+	if _, err = tx.Exec(ctx, deleteEdgesSql, deleteEdgesArgs...); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error deleting %s edges for %s.", edgeType, sourceUID), tx, ctx)
+		return err
+	}
+	if len(edges) > 0 {
+		insertEdgesSql, insertEdgesArgs, err := goquInsertEdges(edges, hubResourceCluster)
+		checkError(err, fmt.Sprintf("Error creating query to insert %s edges for %s.", edgeType, sourceUID))
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+		if _, err = tx.Exec(ctx, insertEdgesSql, insertEdgesArgs...); err != nil {
+			checkErrorAndRollback(err, fmt.Sprintf("Error inserting %s edges for %s.", edgeType, sourceUID), tx, ctx)
+			return err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error committing %s edges for %s.", edgeType, sourceUID), tx, ctx)
+		return err
+	}
+	return nil
+}
+
+// Delete the edges of the given type to a resource. Used when the destination Cluster node is deleted, because
+// the edges are owned by the source clusters.
+func (dao *DAO) DeleteHubEdgesTo(ctx context.Context, destUID, edgeType string) error {
+	defer metrics.QueryTimer("deleteHubEdgesTo")()
+	// DELETE FROM search.edges WHERE destid = '<uid>' AND edgetype = '<edgeType>' AND cluster = ''
+	deleteEdgesSql, deleteEdgesArgs, err := goqu.From(goqu.S("search").Table("edges")).
+		Delete().Where(goqu.C("destid").Eq(destUID), goqu.C("edgetype").Eq(edgeType),
+		goqu.C("cluster").Eq(hubResourceCluster)).ToSQL()
+	checkError(err, fmt.Sprintf("Error creating query to delete %s edges to %s.", edgeType, destUID))
+	if err != nil {
+		return err
+	}
+	if _, err = dao.pool.Exec(ctx, deleteEdgesSql, deleteEdgesArgs...); err != nil {
+		klog.Errorf("Error deleting %s edges to %s. %s", edgeType, destUID, err)
+		return err
+	}
+	return nil
+}
+
+// Create the query to insert multiple edges.
+// Sample query:
+//
+//	INSERT INTO search.edges (sourceid, sourcekind, destid, destkind, edgetype, cluster) VALUES (...), (...)
+//	ON CONFLICT DO NOTHING
+func goquInsertEdges(edges []model.Edge, clusterName string) (string, []interface{}, error) {
+	ds := goqu.From(goqu.S("search").Table("edges")).Insert().
+		Cols("sourceid", "sourcekind", "destid", "destkind", "edgetype", "cluster")
+	for _, edge := range edges {
+		ds = ds.Vals(goqu.Vals{edge.SourceUID, edge.SourceKind, edge.DestUID, edge.DestKind, edge.EdgeType, clusterName})
+	}
+	return ds.OnConflict(goqu.DoNothing()).ToSQL()
+}
@@ -0,0 +1,137 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stolostron/search-indexer/pkg/model"
+)
+
+func Test_UpsertHubResource(t *testing.T) {
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+
+	resource := model.Resource{Kind: "ManagedClusterSet", UID: "managedclusterset__set1",
+		Properties: map[string]interface{}{"kind": "ManagedClusterSet", "name": "set1"}}
+	edges := []model.Edge{{SourceUID: "managedclusterset__set1", SourceKind: "ManagedClusterSet",
+		DestUID: "cluster__cluster1", DestKind: "Cluster", EdgeType: "contains"}}
+
+	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta(`INSERT INTO "search"."resources" AS "r" ("cluster", "data", "uid") VALUES ('', '{"kind":"ManagedClusterSet","name":"set1"}', 'managedclusterset__set1') ON CONFLICT (uid) DO UPDATE SET "data"='{"kind":"ManagedClusterSet","name":"set1"}' WHERE ("r".uid = 'managedclusterset__set1')`)).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE ("sourceid" = 'managedclusterset__set1')`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mockConn.ExpectExec(regexp.QuoteMeta(`INSERT INTO "search"."edges" ("sourceid", "sourcekind", "destid", "destkind", "edgetype", "cluster") VALUES ('managedclusterset__set1', 'ManagedClusterSet', 'cluster__cluster1', 'Cluster', 'contains', '') ON CONFLICT DO NOTHING`)).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockConn.ExpectCommit()
+
+	// Execute function test.
+	err = dao.UpsertHubResource(context.Background(), resource, edges)
+
+	AssertEqual(t, err, nil, "Expected no error.")
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+func Test_UpsertHubResource_Error(t *testing.T) {
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+
+	resource := model.Resource{Kind: "Placement", UID: "placement__ns/p1",
+		Properties: map[string]interface{}{"kind": "Placement"}}
+
+	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta(`INSERT INTO "search"."resources"`)).WillReturnError(errors.New("mock error"))
+	mockConn.ExpectRollback()
+
+	// Execute function test.
+	err = dao.UpsertHubResource(context.Background(), resource, nil)
+
+	AssertEqual(t, err.Error(), "mock error", "Expected error from insert.")
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+func Test_DeleteHubResource(t *testing.T) {
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+
+	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."resources" WHERE ("uid" = 'placement__ns/p1')`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE (("sourceid" = 'placement__ns/p1') OR ("destid" = 'placement__ns/p1'))`)).WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	mockConn.ExpectCommit()
+
+	// Execute function test.
+	err = dao.DeleteHubResource(context.Background(), "placement__ns/p1")
+
+	AssertEqual(t, err, nil, "Expected no error.")
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+func Test_ReplaceHubEdges(t *testing.T) {
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+
+	edges := []model.Edge{{SourceUID: "cluster__hosted", SourceKind: "Cluster",
+		DestUID: "cluster__hub", DestKind: "Cluster", EdgeType: "hostedBy"}}
+
+	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE (("sourceid" = 'cluster__hosted') AND ("edgetype" = 'hostedBy') AND ("cluster" = ''))`)).WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mockConn.ExpectExec(regexp.QuoteMeta(`INSERT INTO "search"."edges" ("sourceid", "sourcekind", "destid", "destkind", "edgetype", "cluster") VALUES ('cluster__hosted', 'Cluster', 'cluster__hub', 'Cluster', 'hostedBy', '') ON CONFLICT DO NOTHING`)).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockConn.ExpectCommit()
+
+	// Execute function test.
+	err = dao.ReplaceHubEdges(context.Background(), "cluster__hosted", "hostedBy", edges)
+
+	AssertEqual(t, err, nil, "Expected no error.")
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+func Test_DeleteHubEdgesTo(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+
+	mockPool.EXPECT().Exec(gomock.Any(),
+		gomock.Eq(`DELETE FROM "search"."edges" WHERE (("destid" = 'cluster__hub') AND ("edgetype" = 'hostedBy') AND ("cluster" = ''))`),
+		gomock.Eq([]interface{}{}),
+	).Return(pgconn.CommandTag("DELETE 2"), nil)
+
+	// Execute function test.
+	err := dao.DeleteHubEdgesTo(context.Background(), "cluster__hub", "hostedBy")
+
+	AssertEqual(t, err, nil, "Expected no error.")
+}
@@ -0,0 +1,273 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Property indexes configured in the INDEX_CONFIG_PATH file, usually mounted from a ConfigMap. The file is YAML or
+// JSON. Sample:
+//
+//	indexes:
+//	- keys: [label]                          # GIN index on data -> 'label'
+//	- keys: [status]
+//	  type: btree                            # btree index on data ->> 'status'
+//	- keys: [label, app.kubernetes.io/name]  # GIN index on data -> 'label' -> 'app.kubernetes.io/name'
+//	  name: data_label_app_idx
+//
+// The indexes are created by the leader with CREATE INDEX CONCURRENTLY, and marked with a comment. Marked indexes
+// that are removed from the file are dropped. Indexes created by InitializeTables aren't marked and never dropped.
+// An invalid index is only dropped and built again when no process is building it, like a previous leader.
+
+const managedIndexComment = "search-indexer: managed"
+const maxIdentifierLength = 63
+
+var indexNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+var invalidIndexNameChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// IndexConfig is the content of the INDEX_CONFIG_PATH file.
+type IndexConfig struct {
+	Indexes []PropertyIndex `json:"indexes"`
+}
+
+// PropertyIndex is an index on a JSONB path of search.resources data.
+type PropertyIndex struct {
+	Keys []string `json:"keys"`           // Path of the property in data.
+	Type string   `json:"type,omitempty"` // gin or btree. Default: gin
+	Name string   `json:"name,omitempty"` // Default: data_<keys>_idx
+}
+
+// LoadIndexConfig reads and validates the index configuration file.
+func LoadIndexConfig(path string) (IndexConfig, error) {
+	indexConfig := IndexConfig{}
+	content, err := os.ReadFile(path) // #nosec G304 -- Path is set by the administrator.
+	if err != nil {
+		return indexConfig, err
+	}
+	if err = yaml.UnmarshalStrict(content, &indexConfig); err != nil {
+		return indexConfig, fmt.Errorf("error parsing index configuration %s: %w", path, err)
+	}
+	names := map[string]struct{}{}
+	for i := range indexConfig.Indexes {
+		index := &indexConfig.Indexes[i]
+		if len(index.Keys) == 0 {
+			return indexConfig, fmt.Errorf("index %d in %s doesn't have keys", i, path)
+		}
+		for _, key := range index.Keys {
+			if key == "" {
+				return indexConfig, fmt.Errorf("index %d in %s has an empty key", i, path)
+			}
+		}
+		if index.Type == "" {
+			index.Type = "gin"
+		}
+		if index.Type != "gin" && index.Type != "btree" {
+			return indexConfig, fmt.Errorf("index %d in %s must be of type gin or btree, got %s", i, path, index.Type)
+		}
+		if index.Name == "" {
+			index.Name = defaultIndexName(index.Keys)
+		}
+		if !indexNamePattern.MatchString(index.Name) || len(index.Name) > maxIdentifierLength {
+			return indexConfig, fmt.Errorf("index name %s in %s must be up to %d lowercase letters, digits or _",
+				index.Name, path, maxIdentifierLength)
+		}
+		if _, ok := names[index.Name]; ok {
+			return indexConfig, fmt.Errorf("index name %s in %s is used more than once", index.Name, path)
+		}
+		names[index.Name] = struct{}{}
+	}
+	return indexConfig, nil
+}
+
+// Build the default name from the keys. Long names are shortened with a hash of the keys.
+func defaultIndexName(keys []string) string {
+	name := "data_" + invalidIndexNameChars.ReplaceAllString(strings.ToLower(strings.Join(keys, "_")), "_") + "_idx"
+	if len(name) <= maxIdentifierLength {
+		return name
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.Join(keys, "\x00")))
+	suffix := fmt.Sprintf("_%08x_idx", h.Sum32())
+	return name[:maxIdentifierLength-len(suffix)] + suffix
+}
+
+// Expression of the index. GIN indexes use the JSONB value, and btree indexes use the text value.
+func (index PropertyIndex) expression() string {
+	expression := "data"
+	for i, key := range index.Keys {
+		operator := "->"
+		if index.Type == "btree" && i == len(index.Keys)-1 {
+			operator = "->>"
+		}
+		expression += fmt.Sprintf(" %s %s", operator, quoteLiteral(key))
+	}
+	return fmt.Sprintf("(%s)", expression)
+}
+
+// CONCURRENTLY isn't supported on partitioned tables.
+func concurrently() string {
+	if partitionMode != "none" {
+		return ""
+	}
+	return " CONCURRENTLY"
+}
+
+// ReconcileIndexes creates the configured property indexes and drops the managed indexes that aren't configured.
+// Index builds can take a long time on large tables, so this should run in the background. Runs on the leader.
+func (dao *DAO) ReconcileIndexes(ctx context.Context) {
+	if config.Cfg.IndexConfigPath == "" {
+		return
+	}
+	indexConfig, err := LoadIndexConfig(config.Cfg.IndexConfigPath)
+	if err != nil {
+		klog.Errorf("Error loading the index configuration. Indexes won't be reconciled. %s", err)
+		return
+	}
+	defer metrics.QueryTimer("reconcileIndexes")()
+
+	existing, err := dao.existingIndexes(ctx)
+	if err != nil {
+		klog.Errorf("Error reading the indexes of search.resources. %s", err)
+		return
+	}
+
+	configured := map[string]struct{}{}
+	for _, index := range indexConfig.Indexes {
+		configured[index.Name] = struct{}{}
+		current, exists := existing[index.Name]
+		if exists && current.valid {
+			continue
+		}
+		if current.building {
+			klog.Infof("Index %s is being built by another process. Skipping it.", index.Name)
+			continue
+		}
+		identifier := pgx.Identifier{"search", index.Name}.Sanitize()
+		// A failed concurrent build leaves an invalid index that must be dropped before building it again.
+		if exists {
+			klog.Infof("Dropping invalid index %s.", index.Name)
+			if _, err = dao.pool.Exec(ctx, fmt.Sprintf("DROP INDEX%s IF EXISTS %s", concurrently(), identifier)); err != nil {
+				klog.Errorf("Error dropping invalid index %s. %s", index.Name, err)
+				continue
+			}
+		}
+		klog.Infof("Creating index %s on search.resources %s.", index.Name, index.expression())
+		start := time.Now()
+		_, err = dao.pool.Exec(ctx, fmt.Sprintf("CREATE INDEX%s IF NOT EXISTS %s ON search.resources USING %s (%s)",
+			concurrently(), pgx.Identifier{index.Name}.Sanitize(), strings.ToUpper(index.Type), index.expression()))
+		if err != nil {
+			klog.Errorf("Error creating index %s. %s", index.Name, err)
+			continue
+		}
+		if _, err = dao.pool.Exec(ctx, fmt.Sprintf("COMMENT ON INDEX %s IS %s", identifier,
+			quoteLiteral(managedIndexComment))); err != nil {
+			klog.Warningf("Error marking index %s as managed. %s", index.Name, err)
+		}
+		klog.Infof("Created index %s in %s.", index.Name, time.Since(start))
+	}
+
+	for name, index := range existing {
+		if _, ok := configured[name]; ok || !index.managed || index.building {
+			continue
+		}
+		klog.Infof("Dropping index %s because it was removed from the index configuration.", name)
+		_, err = dao.pool.Exec(ctx, fmt.Sprintf("DROP INDEX%s IF EXISTS %s", concurrently(),
+			pgx.Identifier{"search", name}.Sanitize()))
+		if err != nil {
+			klog.Errorf("Error dropping index %s. %s", name, err)
+		}
+	}
+}
+
+type existingIndex struct {
+	valid    bool
+	managed  bool
+	building bool // A CREATE INDEX is in progress. The index is invalid until it completes.
+}
+
+// Get the indexes of search.resources.
+func (dao *DAO) existingIndexes(ctx context.Context) (map[string]existingIndex, error) {
+	rows, err := dao.pool.Query(ctx, `SELECT c.relname, i.indisvalid, coalesce(obj_description(c.oid, 'pg_class'), ''),
+		EXISTS (SELECT 1 FROM pg_stat_progress_create_index p WHERE p.index_relid=c.oid)
+		FROM pg_index i JOIN pg_class c ON c.oid=i.indexrelid WHERE i.indrelid='search.resources'::regclass`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	indexes := map[string]existingIndex{}
+	for rows.Next() {
+		var name, comment string
+		var valid, building bool
+		if err = rows.Scan(&name, &valid, &comment, &building); err != nil {
+			return nil, err
+		}
+		indexes[name] = existingIndex{valid: valid, managed: comment == managedIndexComment, building: building}
+	}
+	return indexes, rows.Err()
+}
+
+// StartIndexUsageReport periodically reports the scans and size of the indexes in the search schema, and logs the
+// indexes that were never used since the statistics were reset. Runs on the leader.
+func (dao *DAO) StartIndexUsageReport(ctx context.Context) {
+	if config.Cfg.IndexReportIntervalMS <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(config.Cfg.IndexReportIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		dao.reportIndexUsage(ctx)
+		select {
+		case <-ctx.Done():
+			klog.Info("Exit index usage report.")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Read the index statistics. The statistics of partition indexes are added to their parent index.
+func (dao *DAO) reportIndexUsage(ctx context.Context) {
+	defer metrics.QueryTimer("indexUsage")()
+	rows, err := dao.pool.Query(ctx, `SELECT coalesce(p.relname, s.indexrelname), sum(s.idx_scan)::bigint,
+		sum(pg_relation_size(s.indexrelid))::bigint
+		FROM pg_stat_user_indexes s LEFT JOIN pg_inherits h ON h.inhrelid=s.indexrelid
+		LEFT JOIN pg_class p ON p.oid=h.inhparent WHERE s.schemaname='search' GROUP BY 1`)
+	if err != nil {
+		klog.Warningf("Error reading the index usage. %s", err)
+		return
+	}
+	defer rows.Close()
+	metrics.DBIndexScans.Reset()
+	metrics.DBIndexSize.Reset()
+	unused := make([]string, 0)
+	for rows.Next() {
+		var name string
+		var scans, size int64
+		if err = rows.Scan(&name, &scans, &size); err != nil {
+			klog.Warningf("Error reading the index usage. %s", err)
+			return
+		}
+		metrics.DBIndexScans.WithLabelValues(name).Set(float64(scans))
+		metrics.DBIndexSize.WithLabelValues(name).Set(float64(size))
+		// Primary keys enforce uniqueness even if they aren't scanned.
+		if scans == 0 && !strings.HasSuffix(name, "_pkey") {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		klog.Infof("Indexes not used since the database statistics were reset: %s", strings.Join(unused, ", "))
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// Write the index configuration to a temporary file.
+func writeIndexConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "indexes.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_LoadIndexConfig(t *testing.T) {
+	path := writeIndexConfig(t, `
+indexes:
+- keys: [label]
+- keys: [status]
+  type: btree
+- keys: [label, app.kubernetes.io/name]
+  name: data_label_app_idx
+`)
+
+	indexConfig, err := LoadIndexConfig(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []PropertyIndex{
+		{Keys: []string{"label"}, Type: "gin", Name: "data_label_idx"},
+		{Keys: []string{"status"}, Type: "btree", Name: "data_status_idx"},
+		{Keys: []string{"label", "app.kubernetes.io/name"}, Type: "gin", Name: "data_label_app_idx"},
+	}, indexConfig.Indexes)
+	assert.Equal(t, "(data -> 'label')", indexConfig.Indexes[0].expression())
+	assert.Equal(t, "(data ->> 'status')", indexConfig.Indexes[1].expression())
+	assert.Equal(t, "(data -> 'label' -> 'app.kubernetes.io/name')", indexConfig.Indexes[2].expression())
+}
+
+func Test_LoadIndexConfig_invalid(t *testing.T) {
+	tests := map[string]string{
+		"no keys":        "indexes:\n- type: gin\n",
+		"invalid type":   "indexes:\n- keys: [label]\n  type: hash\n",
+		"invalid name":   "indexes:\n- keys: [label]\n  name: Label-Idx\n",
+		"duplicate name": "indexes:\n- keys: [label]\n- keys: [label]\n  type: btree\n",
+		"unknown field":  "indexes:\n- keys: [label]\n  path: label\n",
+	}
+	for name, content := range tests {
+		_, err := LoadIndexConfig(writeIndexConfig(t, content))
+		assert.NotNil(t, err, name)
+	}
+}
+
+func Test_defaultIndexName_long(t *testing.T) {
+	keys := []string{"label", "a-very-long-label-key.example.com/with-a-name-longer-than-the-postgres-limit"}
+
+	name := defaultIndexName(keys)
+
+	assert.LessOrEqual(t, len(name), maxIdentifierLength)
+	assert.Regexp(t, indexNamePattern, name)
+	assert.NotEqual(t, name, defaultIndexName([]string{"label", "another-long-label-key.example.com/with-a-name-longer-than-the-limit"}))
+}
+
+// Should create the missing and invalid indexes, and drop the managed indexes removed from the configuration.
+func Test_ReconcileIndexes(t *testing.T) {
+	savedPath := config.Cfg.IndexConfigPath
+	config.Cfg.IndexConfigPath = writeIndexConfig(t, "indexes:\n- keys: [label]\n- keys: [status]\n  type: btree\n")
+	defer func() { config.Cfg.IndexConfigPath = savedPath }()
+	dao, mockPool := buildMockDAO(t)
+
+	existingRows := pgxpoolmock.NewRows([]string{"relname", "indisvalid", "comment", "building"}).
+		AddRow("data_kind_idx", true, "", false).
+		AddRow("data_status_idx", false, "", false).
+		AddRow("data_container_idx", true, managedIndexComment, false).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any()).Return(existingRows, nil)
+	gomock.InOrder(
+		mockPool.EXPECT().Exec(gomock.Any(),
+			`CREATE INDEX CONCURRENTLY IF NOT EXISTS "data_label_idx" ON search.resources USING GIN ((data -> 'label'))`).
+			Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(),
+			`COMMENT ON INDEX "search"."data_label_idx" IS 'search-indexer: managed'`).Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(), `DROP INDEX CONCURRENTLY IF EXISTS "search"."data_status_idx"`).
+			Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(),
+			`CREATE INDEX CONCURRENTLY IF NOT EXISTS "data_status_idx" ON search.resources USING BTREE ((data ->> 'status'))`).
+			Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(),
+			`COMMENT ON INDEX "search"."data_status_idx" IS 'search-indexer: managed'`).Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(), `DROP INDEX CONCURRENTLY IF EXISTS "search"."data_container_idx"`).
+			Return(nil, nil),
+	)
+
+	dao.ReconcileIndexes(context.Background())
+}
+
+// Should skip the invalid indexes that are still being built by another process.
+func Test_ReconcileIndexes_building(t *testing.T) {
+	savedPath := config.Cfg.IndexConfigPath
+	config.Cfg.IndexConfigPath = writeIndexConfig(t, "indexes:\n- keys: [label]\n")
+	defer func() { config.Cfg.IndexConfigPath = savedPath }()
+	dao, mockPool := buildMockDAO(t)
+
+	existingRows := pgxpoolmock.NewRows([]string{"relname", "indisvalid", "comment", "building"}).
+		AddRow("data_label_idx", false, "", true).
+		AddRow("data_container_idx", false, managedIndexComment, true).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any()).Return(existingRows, nil)
+
+	dao.ReconcileIndexes(context.Background()) // No drop or create is expected.
+}
+
+// Should export the index usage with the partition indexes added to their parent.
+func Test_reportIndexUsage(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	usageRows := pgxpoolmock.NewRows([]string{"index", "scans", "size"}).
+		AddRow("data_kind_idx", int64(12), int64(8192)).
+		AddRow("data_label_idx", int64(0), int64(4096)).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any()).Return(usageRows, nil)
+
+	dao.reportIndexUsage(context.Background())
+
+	assert.Equal(t, float64(12), testutil.ToFloat64(metrics.DBIndexScans.WithLabelValues("data_kind_idx")))
+	assert.Equal(t, float64(4096), testutil.ToFloat64(metrics.DBIndexSize.WithLabelValues("data_label_idx")))
+}
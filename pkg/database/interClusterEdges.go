@@ -0,0 +1,382 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"k8s.io/klog/v2"
+)
+
+// InterCluster edges, enabled with INTER_CLUSTER_EDGES_MS. The edges connect the resources of a managed cluster to
+// the hub resources (with _hubClusterResource) that deployed them:
+//
+//   - Resources with the _hostingSubscription property to the hub Subscription <namespace>/<name>.
+//   - Applications, Subscriptions, Placements, and PlacementRules to the hub resource with the same kind, apigroup,
+//     namespace, and name.
+//   - AppliedManifestWorks, named <hub hash>-<name>, to the hub ManifestWork <name> in the namespace of the cluster.
+//
+// The edges have the cluster of their source, so deleting the resources of a managed cluster deletes its edges. A
+// sync or resync of a managed cluster that changes these resources queues the cluster, and one of the hub queues the
+// changed hub kinds. The queue is processed INTER_CLUSTER_EDGES_MS after the first change, to batch the changes of
+// consecutive requests, and the edges of each cluster or hub kind are replaced in a transaction. Only hub resources
+// are the destination of an edge, so the deletes of every sync delete the edges to the deleted resources, without
+// knowing which cluster is the hub. The edges of all clusters are only computed by the leader, when it starts leading.
+//
+// Each replica computes the queue of the requests it received. The transactions take a lock for the interCluster
+// edges, so the replacements of overlapping edges by different replicas run one at a time instead of deadlocking.
+
+// Key of the advisory lock taken by the transactions that replace interCluster edges.
+const interClusterEdgesLock = int64(0x696e746572) // "inter"
+
+// Kinds of the hub resources that can be the destination of an interCluster edge.
+var interClusterHubKinds = map[string]struct{}{
+	"Application": {}, "ManifestWork": {}, "Placement": {}, "PlacementRule": {}, "Subscription": {},
+}
+
+// Kinds of the managed cluster resources that can be the source of an interCluster edge, besides the resources with
+// _hostingSubscription.
+var interClusterSourceKinds = map[string]struct{}{
+	"Application": {}, "AppliedManifestWork": {}, "Placement": {}, "PlacementRule": {}, "Subscription": {},
+}
+
+// Rule that inserts the interCluster edges to a hub kind. The query is a join on equal properties, with the kinds
+// filtered by the GIN index on data->'kind'. The %s in the query is replaced by the scope of the source resources.
+type interClusterRule struct {
+	hubKind string
+	query   string
+}
+
+func interClusterRuleQuery(hubKind, sourceFilter, join string) string {
+	return `INSERT INTO search.edges (sourceid, sourcekind, destid, destkind, edgetype, cluster)
+		SELECT DISTINCT s.uid, s.data->>'kind', h.uid, h.data->>'kind', 'interCluster', s.cluster
+		FROM search.resources h JOIN search.resources s ON ` + join + `
+		WHERE h.data ? '_hubClusterResource' AND h.data->'kind' @> '"` + hubKind + `"'
+		AND ` + sourceFilter + ` AND NOT s.data ? '_hubClusterResource' AND s.cluster!=h.cluster%s
+		ON CONFLICT DO NOTHING`
+}
+
+var interClusterRules = func() []interClusterRule {
+	rules := []interClusterRule{{"Subscription", interClusterRuleQuery("Subscription",
+		"s.data ? '_hostingSubscription'",
+		"s.data->>'_hostingSubscription'=concat(h.data->>'namespace', '/', h.data->>'name')")}}
+	for _, kind := range []string{"Application", "Placement", "PlacementRule", "Subscription"} {
+		rules = append(rules, interClusterRule{kind, interClusterRuleQuery(kind, `s.data->'kind' @> '"`+kind+`"'`,
+			"s.data->>'name'=h.data->>'name' AND s.data->>'namespace'=h.data->>'namespace' "+
+				"AND s.data->>'apigroup' IS NOT DISTINCT FROM h.data->>'apigroup'")})
+	}
+	return append(rules, interClusterRule{"ManifestWork", interClusterRuleQuery("ManifestWork",
+		`s.data->'kind' @> '"AppliedManifestWork"'`,
+		"s.cluster=h.data->>'namespace' "+
+			"AND right(s.data->>'name', length(h.data->>'name')+1)=concat('-', h.data->>'name')")})
+}()
+
+// Insert the interCluster edges to the hub kind, or to all hub kinds when the kind is empty. With oneCluster, only
+// the edges from the resources of the cluster $1 are inserted.
+func interClusterEdgesQueries(hubKind string, oneCluster bool) []string {
+	scope := ""
+	if oneCluster {
+		scope = " AND s.cluster=$1"
+	}
+	queries := make([]string, 0, len(interClusterRules))
+	for _, rule := range interClusterRules {
+		if hubKind == "" || rule.hubKind == hubKind {
+			queries = append(queries, fmt.Sprintf(rule.query, scope))
+		}
+	}
+	return queries
+}
+
+// Clusters and hub kinds waiting for their interCluster edges to be computed.
+type interClusterQueue struct {
+	lock     sync.Mutex
+	clusters map[string]struct{}
+	kinds    map[string]struct{} // Hub kinds whose edges are computed for all clusters.
+	notify   chan struct{}
+}
+
+var interClusterEdges = &interClusterQueue{
+	clusters: map[string]struct{}{},
+	kinds:    map[string]struct{}{},
+	notify:   make(chan struct{}, 1),
+}
+
+// Queue a cluster.
+func (queue *interClusterQueue) addCluster(clusterName string) {
+	queue.lock.Lock()
+	queue.clusters[clusterName] = struct{}{}
+	queue.lock.Unlock()
+	queue.wake()
+}
+
+// Queue hub kinds, or all hub kinds when none are given.
+func (queue *interClusterQueue) addKinds(kinds ...string) {
+	if len(kinds) == 0 {
+		for kind := range interClusterHubKinds {
+			kinds = append(kinds, kind)
+		}
+	}
+	queue.lock.Lock()
+	for _, kind := range kinds {
+		queue.kinds[kind] = struct{}{}
+	}
+	queue.lock.Unlock()
+	queue.wake()
+}
+
+func (queue *interClusterQueue) wake() {
+	select {
+	case queue.notify <- struct{}{}:
+	default: // Already notified.
+	}
+}
+
+// Take the queued clusters and hub kinds.
+func (queue *interClusterQueue) take() (map[string]struct{}, map[string]struct{}) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	clusters, kinds := queue.clusters, queue.kinds
+	queue.clusters, queue.kinds = map[string]struct{}{}, map[string]struct{}{}
+	return clusters, kinds
+}
+
+// Forget a cluster after its resources are deleted. The edges of its resources were deleted with them, and the
+// edges to the resources of a hub are computed again.
+func (queue *interClusterQueue) clusterDeleted(clusterName string, hub bool) {
+	if config.Cfg.InterClusterEdgesMS <= 0 {
+		return
+	}
+	queue.lock.Lock()
+	delete(queue.clusters, clusterName)
+	queue.lock.Unlock()
+	if hub {
+		queue.addKinds()
+	}
+}
+
+// True if the cluster has hub resources, read from the database because any replica can delete the hub. The Cluster
+// nodes also have _hubClusterResource, so they are excluded. Returns true if the query fails, because computing the
+// hub kinds again only costs time.
+func (dao *DAO) isHubCluster(ctx context.Context, clusterName string) bool {
+	if config.Cfg.InterClusterEdgesMS <= 0 {
+		return false
+	}
+	defer metrics.QueryTimer("isHubCluster")()
+	rows, err := dao.pool.Query(ctx, `SELECT EXISTS (SELECT 1 FROM search.resources WHERE cluster=$1
+		AND data ? '_hubClusterResource' AND data->>'kind'<>'Cluster')`, clusterName)
+	if err != nil {
+		klog.Warningf("Error checking if cluster %s is the hub. %s", clusterName, err)
+		return true
+	}
+	defer rows.Close()
+	hub := true
+	if rows.Next() {
+		if err = rows.Scan(&hub); err != nil {
+			klog.Warningf("Error checking if cluster %s is the hub. %s", clusterName, err)
+			return true
+		}
+	}
+	return hub
+}
+
+// Changes of a sync or resync that affect the interCluster edges. A nil value ignores the changes.
+type interClusterChanges struct {
+	hub        bool                // The request has hub resources.
+	hubKinds   map[string]struct{} // Hub kinds that can be the destination of an edge.
+	sourceKind bool                // Managed cluster resources that can be the source of an edge.
+}
+
+// Start tracking the changes of a request. Returns nil when the interCluster edges are disabled.
+func newInterClusterChanges() *interClusterChanges {
+	if config.Cfg.InterClusterEdgesMS <= 0 {
+		return nil
+	}
+	return &interClusterChanges{hubKinds: map[string]struct{}{}}
+}
+
+// Record an added or updated resource.
+func (changes *interClusterChanges) add(resource model.Resource) {
+	if changes == nil {
+		return
+	}
+	kind := resource.Kind
+	if kind == "" {
+		kind, _ = resource.Properties["kind"].(string)
+	}
+	if _, ok := resource.Properties["_hubClusterResource"]; ok {
+		changes.hub = true
+		if _, ok := interClusterHubKinds[kind]; ok {
+			changes.hubKinds[kind] = struct{}{}
+		}
+		return
+	}
+	_, hosted := resource.Properties["_hostingSubscription"]
+	if _, ok := interClusterSourceKinds[kind]; ok || hosted {
+		changes.sourceKind = true
+	}
+}
+
+// True if the request has hub resources.
+func (changes *interClusterChanges) fromHub() bool {
+	return changes != nil && changes.hub
+}
+
+// Queue the edges of the cluster, or of the changed kinds for the hub. A resync always queues them because the sweep
+// doesn't delete the interCluster edges, and all hub kinds for the hub.
+func (changes *interClusterChanges) queue(clusterName string, resync bool) {
+	if changes == nil {
+		return
+	}
+	if changes.hub {
+		if resync {
+			interClusterEdges.addKinds()
+		} else if len(changes.hubKinds) > 0 {
+			kinds := make([]string, 0, len(changes.hubKinds))
+			for kind := range changes.hubKinds {
+				kinds = append(kinds, kind)
+			}
+			interClusterEdges.addKinds(kinds...)
+		}
+	} else if changes.sourceKind || resync {
+		interClusterEdges.addCluster(clusterName)
+	}
+}
+
+// StartInterClusterEdges computes the interCluster edges of the queued clusters and hub kinds until the context is
+// canceled. After a failed computation, waits with a backoff before computing the queue again.
+func (dao *DAO) StartInterClusterEdges(ctx context.Context) {
+	if config.Cfg.InterClusterEdgesMS <= 0 {
+		return
+	}
+	delay := time.Duration(config.Cfg.InterClusterEdgesMS) * time.Millisecond
+	retry := 0
+	for {
+		select {
+		case <-ctx.Done():
+			klog.Info("Exit interCluster edges.")
+			return
+		case <-interClusterEdges.notify:
+		}
+		// Wait for the changes of consecutive requests.
+		select {
+		case <-ctx.Done():
+			klog.Info("Exit interCluster edges.")
+			return
+		case <-time.After(delay):
+		}
+		failed := false
+		clusters, kinds := interClusterEdges.take()
+		for kind := range kinds {
+			if err := dao.computeInterClusterEdges(ctx, "kind", kind); err != nil {
+				failed = true
+				interClusterEdges.addKinds(kind)
+			}
+		}
+		for clusterName := range clusters {
+			if err := dao.computeInterClusterEdges(ctx, "cluster", clusterName); err != nil {
+				failed = true
+				interClusterEdges.addCluster(clusterName)
+			}
+		}
+		if !failed {
+			retry = 0
+			continue
+		}
+		retry++
+		waitMS := int(math.Min(float64(retry*500), float64(config.Cfg.MaxBackoffMS)))
+		klog.V(2).Infof("Computing the interCluster edges again in %d ms.", waitMS)
+		select {
+		case <-ctx.Done():
+			klog.Info("Exit interCluster edges.")
+			return
+		case <-time.After(time.Duration(waitMS) * time.Millisecond):
+		}
+	}
+}
+
+// ReconcileInterClusterEdges computes the interCluster edges of all clusters, retrying with a backoff until it
+// succeeds. Catches up with the changes of replicas that stopped before processing their queue. Runs on the leader.
+func (dao *DAO) ReconcileInterClusterEdges(ctx context.Context) {
+	if config.Cfg.InterClusterEdgesMS <= 0 {
+		return
+	}
+	for retry := 1; dao.computeInterClusterEdges(ctx, "all", "") != nil; retry++ {
+		waitMS := int(math.Min(float64(retry*500), float64(config.Cfg.MaxBackoffMS)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(waitMS) * time.Millisecond):
+		}
+	}
+}
+
+// Replace the interCluster edges of the cluster or hub kind, or of all clusters for the scope "all".
+func (dao *DAO) computeInterClusterEdges(ctx context.Context, scope, name string) error {
+	defer metrics.QueryTimer("interClusterEdges")()
+	deleteSql := "DELETE FROM search.edges WHERE edgetype='interCluster'"
+	var deleteArgs, insertArgs []interface{}
+	var insertSqls []string
+	description := "all clusters"
+	switch scope {
+	case "cluster":
+		description = fmt.Sprintf("cluster %s", name)
+		deleteSql += " AND cluster=$1"
+		deleteArgs, insertArgs = []interface{}{name}, []interface{}{name}
+		insertSqls = interClusterEdgesQueries("", true)
+	case "kind":
+		description = fmt.Sprintf("hub kind %s", name)
+		deleteSql += " AND destkind=$1"
+		deleteArgs = []interface{}{name}
+		insertSqls = interClusterEdgesQueries(name, false)
+	default:
+		insertSqls = interClusterEdgesQueries("", false)
+	}
+
+	err := dao.replaceInterClusterEdges(ctx, deleteSql, deleteArgs, insertSqls, insertArgs, description)
+	if err != nil {
+		klog.Warningf("Error computing the interCluster edges of %s. %s", description, err)
+		metrics.InterClusterEdgeUpdates.WithLabelValues(scope, "error").Inc()
+		return err
+	}
+	metrics.InterClusterEdgeUpdates.WithLabelValues(scope, "success").Inc()
+	return nil
+}
+
+func (dao *DAO) replaceInterClusterEdges(ctx context.Context, deleteSql string, deleteArgs []interface{},
+	insertSqls []string, insertArgs []interface{}, description string) error {
+	tx, err := dao.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	// Wait for the replacements of other replicas, which can delete and insert the same edges in another order.
+	if _, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", interClusterEdgesLock); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	if _, err = tx.Exec(ctx, deleteSql, deleteArgs...); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	inserted := int64(0)
+	for _, insertSql := range insertSqls {
+		res, err := tx.Exec(ctx, insertSql, insertArgs...)
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+		inserted += res.RowsAffected()
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	klog.V(2).Infof("Computed %d interCluster edges of %s.", inserted, description)
+	return nil
+}
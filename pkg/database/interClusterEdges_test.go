@@ -0,0 +1,204 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// Enable the interCluster edges and clear the queue after the test.
+func setInterClusterEdges(t *testing.T) {
+	saved := config.Cfg.InterClusterEdgesMS
+	config.Cfg.InterClusterEdgesMS = 1000
+	t.Cleanup(func() {
+		config.Cfg.InterClusterEdgesMS = saved
+		interClusterEdges.take()
+		select {
+		case <-interClusterEdges.notify:
+		default:
+		}
+	})
+}
+
+// Should queue the managed clusters with changes to the source resources, and the changed hub kinds for the hub.
+func Test_interClusterChanges_queue(t *testing.T) {
+	setInterClusterEdges(t)
+
+	spoke := newInterClusterChanges()
+	spoke.add(model.Resource{Kind: "ConfigMap", Properties: map[string]interface{}{"_hostingSubscription": "ns/sub"}})
+	spoke.queue("cluster1", false)
+	unchanged := newInterClusterChanges()
+	unchanged.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{}})
+	unchanged.queue("cluster2", false)
+	newInterClusterChanges().queue("cluster3", true)
+
+	clusters, kinds := interClusterEdges.take()
+	assert.Equal(t, map[string]struct{}{"cluster1": {}, "cluster3": {}}, clusters)
+	assert.Empty(t, kinds)
+
+	hub := newInterClusterChanges()
+	hub.add(model.Resource{Kind: "Subscription", Properties: map[string]interface{}{"_hubClusterResource": true}})
+	hub.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{"_hubClusterResource": true}})
+	hub.queue("local-cluster", false)
+
+	clusters, kinds = interClusterEdges.take()
+	assert.Empty(t, clusters)
+	assert.Equal(t, map[string]struct{}{"Subscription": {}}, kinds)
+	assert.True(t, hub.fromHub())
+
+	hubResync := newInterClusterChanges()
+	hubResync.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{"_hubClusterResource": true}})
+	hubResync.queue("local-cluster", true)
+	_, kinds = interClusterEdges.take()
+	assert.Len(t, kinds, len(interClusterHubKinds))
+
+	interClusterEdges.clusterDeleted("local-cluster", true)
+	_, kinds = interClusterEdges.take()
+	assert.Len(t, kinds, len(interClusterHubKinds))
+	interClusterEdges.clusterDeleted("cluster1", false)
+	_, kinds = interClusterEdges.take()
+	assert.Empty(t, kinds)
+}
+
+// Should read from the database if the cluster is the hub, and assume it is when the query fails.
+func Test_isHubCluster(t *testing.T) {
+	setInterClusterEdges(t)
+	dao, mockPool := buildMockDAO(t)
+	gomock.InOrder(
+		mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "local-cluster").
+			Return(pgxpoolmock.NewRows([]string{"exists"}).AddRow(true).ToPgxRows(), nil),
+		mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster1").
+			Return(pgxpoolmock.NewRows([]string{"exists"}).AddRow(false).ToPgxRows(), nil),
+		mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster2").Return(nil, errors.New("unexpected EOF")),
+	)
+
+	assert.True(t, dao.isHubCluster(context.Background(), "local-cluster"))
+	assert.False(t, dao.isHubCluster(context.Background(), "cluster1"))
+	assert.True(t, dao.isHubCluster(context.Background(), "cluster2"))
+}
+
+// Should select the rules of the hub kind, and scope them to the cluster.
+func Test_interClusterEdgesQueries(t *testing.T) {
+	assert.Len(t, interClusterEdgesQueries("", false), 6)
+	subscription := interClusterEdgesQueries("Subscription", false)
+	assert.Len(t, subscription, 2)
+	assert.Contains(t, subscription[0], "s.data->>'_hostingSubscription'=concat(h.data->>'namespace', '/', h.data->>'name')")
+	assert.NotContains(t, subscription[0], "$1")
+	for _, query := range interClusterEdgesQueries("", true) {
+		assert.Contains(t, query, "s.cluster!=h.cluster AND s.cluster=$1")
+		assert.NotContains(t, query, " OR ")
+	}
+	manifestWork := interClusterEdgesQueries("ManifestWork", false)
+	assert.Len(t, manifestWork, 1)
+	assert.Contains(t, manifestWork[0], `h.data->'kind' @> '"ManifestWork"'`)
+}
+
+func Test_interClusterChanges_disabled(t *testing.T) {
+	changes := newInterClusterChanges()
+	changes.add(model.Resource{Kind: "Subscription", Properties: map[string]interface{}{}})
+	changes.queue("cluster1", true)
+
+	assert.Nil(t, changes)
+	assert.False(t, changes.fromHub())
+}
+
+// Should replace the interCluster edges of the cluster in a transaction.
+func Test_computeInterClusterEdges(t *testing.T) {
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+	updates := testutil.ToFloat64(metrics.InterClusterEdgeUpdates.WithLabelValues("cluster", "success"))
+
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock($1)")).WithArgs(interClusterEdgesLock).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockConn.ExpectExec(regexp.QuoteMeta("DELETE FROM search.edges WHERE edgetype='interCluster' AND cluster=$1")).
+		WithArgs("cluster1").WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	for _, query := range interClusterEdgesQueries("", true) {
+		mockConn.ExpectExec(regexp.QuoteMeta(query)).WithArgs("cluster1").
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	}
+	mockConn.ExpectCommit()
+
+	err = dao.computeInterClusterEdges(context.Background(), "cluster", "cluster1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, updates+1, testutil.ToFloat64(metrics.InterClusterEdgeUpdates.WithLabelValues("cluster", "success")))
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+// Should replace the interCluster edges to the hub kind with the rules of the kind.
+func Test_computeInterClusterEdges_kind(t *testing.T) {
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock($1)")).WithArgs(interClusterEdgesLock).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockConn.ExpectExec(regexp.QuoteMeta("DELETE FROM search.edges WHERE edgetype='interCluster' AND destkind=$1")).
+		WithArgs("ManifestWork").WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	mockConn.ExpectExec(regexp.QuoteMeta(interClusterEdgesQueries("ManifestWork", false)[0])).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	mockConn.ExpectCommit()
+
+	err = dao.computeInterClusterEdges(context.Background(), "kind", "ManifestWork")
+
+	assert.Nil(t, err)
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+// Should keep the edges of all clusters if an insert fails.
+func Test_computeInterClusterEdges_error(t *testing.T) {
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock($1)")).WithArgs(interClusterEdgesLock).
+		WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mockConn.ExpectExec(regexp.QuoteMeta("DELETE FROM search.edges WHERE edgetype='interCluster'")).
+		WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	mockConn.ExpectExec(regexp.QuoteMeta(interClusterEdgesQueries("", false)[0])).
+		WillReturnError(errors.New("mock error"))
+	mockConn.ExpectRollback()
+
+	err = dao.computeInterClusterEdges(context.Background(), "all", "")
+
+	assert.EqualError(t, err, "mock error")
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// Partitioning of search.resources and search.edges by cluster (DB_PARTITION_MODE).
+//
+//	none  Single tables. Default.
+//	list  One partition for each cluster, created on the first write from the cluster. Deleting a cluster truncates
+//	      its partitions instead of deleting the rows. Hub rows (cluster='') have their own partition, and rows that
+//	      can't get a partition are written to the default partition until the partition is created.
+//	hash  DB_HASH_PARTITIONS partitions created at startup. Deletes are row by row, as with none.
+//
+// Partitioned tables need the cluster in the primary key, so the upserts use (uid, cluster) and
+// (sourceid, destid, edgetype, cluster) as the conflict targets.
+
+// Partition mode in use. Set by InitializeTables, which falls back to none if the existing tables weren't created
+// with partitions.
+var partitionMode = "none"
+
+// Clusters with list partitions, checked or created by this process.
+var clusterPartitions = map[string]struct{}{}
+var clusterPartitionsLock = sync.Mutex{}
+
+// Conflict target for upserts to search.resources.
+func resourcesConflictTarget() string {
+	if partitionMode != "none" {
+		return "uid, cluster"
+	}
+	return "uid"
+}
+
+// Conflict target for upserts to search.edges.
+func edgesConflictTarget() string {
+	if partitionMode != "none" {
+		return "sourceid, destid, edgetype, cluster"
+	}
+	return "sourceid, destid, edgetype"
+}
+
+// Name of the list partition of the table for the cluster. Cluster names can be longer than the identifier limit
+// once prefixed, so the name uses a hash of the cluster name.
+func clusterPartitionName(table, clusterName string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(clusterName))
+	return fmt.Sprintf("%s_c%016x", table, h.Sum64())
+}
+
+// Quote a string literal for DDL, which doesn't accept parameters.
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// Create the partitioned tables. Returns false if the tables exist without partitions.
+func (dao *DAO) initializePartitionedTables(ctx context.Context) bool {
+	mode := config.Cfg.DBPartitionMode
+	_, err := dao.pool.Exec(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS search.resources (uid TEXT, cluster TEXT, data JSONB, generation BIGINT NOT NULL DEFAULT 0, PRIMARY KEY(uid, cluster)) PARTITION BY %s (cluster)",
+		strings.ToUpper(mode)))
+	checkError(err, "Error creating partitioned table search.resources.")
+	_, err = dao.pool.Exec(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS search.edges (sourceId TEXT, sourceKind TEXT,destId TEXT,destKind TEXT,edgeType TEXT,cluster TEXT, generation BIGINT NOT NULL DEFAULT 0, PRIMARY KEY(sourceId, destId, edgeType, cluster)) PARTITION BY %s (cluster)",
+		strings.ToUpper(mode)))
+	checkError(err, "Error creating partitioned table search.edges.")
+
+	rows, err := dao.pool.Query(ctx,
+		"SELECT count(*) FROM pg_partitioned_table WHERE partrelid IN ('search.resources'::regclass, 'search.edges'::regclass)")
+	if err != nil {
+		klog.Errorf("Error checking the partitions of search.resources and search.edges. %s", err)
+		return false
+	}
+	defer rows.Close()
+	partitionedTables := 0
+	if rows.Next() {
+		if err = rows.Scan(&partitionedTables); err != nil {
+			klog.Errorf("Error checking the partitions of search.resources and search.edges. %s", err)
+			return false
+		}
+	}
+	if partitionedTables != 2 {
+		klog.Errorf("DB_PARTITION_MODE is %s, but search.resources and search.edges exist without partitions. "+
+			"Using the tables without partitions. Recreate the tables to use partitions.", mode)
+		return false
+	}
+
+	for _, table := range []string{"resources", "edges"} {
+		if mode == "hash" {
+			for i := 0; i < config.Cfg.DBHashPartitions; i++ {
+				_, err = dao.pool.Exec(ctx, fmt.Sprintf(
+					"CREATE TABLE IF NOT EXISTS search.%s_h%d PARTITION OF search.%s FOR VALUES WITH (MODULUS %d, REMAINDER %d)",
+					table, i, table, config.Cfg.DBHashPartitions, i))
+				checkError(err, fmt.Sprintf("Error creating hash partition %d of search.%s.", i, table))
+			}
+			continue
+		}
+		_, err = dao.pool.Exec(ctx, fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS search.%s_hub PARTITION OF search.%s FOR VALUES IN ('')", table, table))
+		checkError(err, fmt.Sprintf("Error creating hub partition of search.%s.", table))
+		_, err = dao.pool.Exec(ctx, fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS search.%s_default PARTITION OF search.%s DEFAULT", table, table))
+		checkError(err, fmt.Sprintf("Error creating default partition of search.%s.", table))
+	}
+	return true
+}
+
+// Create the list partitions for the cluster if they don't exist. The partitions are checked in the catalog before
+// they're created, and then remembered, because they're never dropped: a cluster delete truncates them. If the
+// partitions can't be created, the rows are written to the default partition and the create is tried again on the
+// next write from the cluster.
+func (dao *DAO) ensureClusterPartitions(ctx context.Context, clusterName string) {
+	if partitionMode != "list" || clusterName == "" {
+		return
+	}
+	clusterPartitionsLock.Lock()
+	defer clusterPartitionsLock.Unlock()
+	if _, ok := clusterPartitions[clusterName]; ok {
+		return
+	}
+
+	defer metrics.QueryTimer("createClusterPartitions")()
+	existing, err := dao.existingClusterPartitions(ctx, clusterName)
+	if err != nil {
+		klog.Warningf("Error checking the partitions for cluster %s. Using the default partition. %s", clusterName, err)
+		return
+	}
+	for _, table := range []string{"resources", "edges"} {
+		if existing[table] {
+			continue
+		}
+		if err = dao.createClusterPartition(ctx, table, clusterName); err != nil {
+			klog.Warningf("Error creating partition of search.%s for cluster %s. Using the default partition. %s",
+				table, clusterName, err)
+			return
+		}
+		klog.V(3).Infof("Created partition of search.%s for cluster %s.", table, clusterName)
+	}
+	clusterPartitions[clusterName] = struct{}{}
+}
+
+// Create the list partition of the table for the cluster. The create fails if the default partition has rows of the
+// cluster, written while the partition couldn't be created. Then the rows are moved from the default partition to a
+// new table, which is attached as the partition, in one transaction.
+func (dao *DAO) createClusterPartition(ctx context.Context, table, clusterName string) error {
+	partition := pgx.Identifier{"search", clusterPartitionName(table, clusterName)}.Sanitize()
+	_, err := dao.pool.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF search.%s FOR VALUES IN (%s)",
+		partition, table, quoteLiteral(clusterName)))
+	// 23514 is check_violation, from the rows of the cluster in the default partition.
+	var pgErr *pgconn.PgError
+	if err == nil || !errors.As(err, &pgErr) || pgErr.Code != "23514" {
+		return err
+	}
+	klog.V(2).Infof("Moving the rows of cluster %s from the default partition of search.%s.", clusterName, table)
+	return dao.execPartitionTxn(ctx, clusterName, []string{
+		fmt.Sprintf("CREATE TABLE %s (LIKE search.%s INCLUDING ALL)", partition, table),
+		fmt.Sprintf("WITH moved AS (DELETE FROM search.%s_default WHERE cluster=%s RETURNING *) INSERT INTO %s SELECT * FROM moved",
+			table, quoteLiteral(clusterName), partition),
+		fmt.Sprintf("ALTER TABLE search.%s ATTACH PARTITION %s FOR VALUES IN (%s)", table, partition,
+			quoteLiteral(clusterName)),
+	})
+}
+
+// Get the list partitions that exist for the cluster, keyed by table.
+func (dao *DAO) existingClusterPartitions(ctx context.Context, clusterName string) (map[string]bool, error) {
+	names := []string{clusterPartitionName("resources", clusterName), clusterPartitionName("edges", clusterName)}
+	rows, err := dao.pool.Query(ctx,
+		"SELECT c.relname FROM pg_class c JOIN pg_namespace n ON n.oid=c.relnamespace WHERE n.nspname='search' AND c.relname=ANY($1)",
+		names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if name == names[0] {
+			existing["resources"] = true
+		} else if name == names[1] {
+			existing["edges"] = true
+		}
+	}
+	return existing, rows.Err()
+}
+
+// Delete the rows of the cluster by truncating its list partitions in a single transaction. TRUNCATE only locks the
+// partitions of the cluster, so the syncs of the other clusters aren't blocked. The partitions are kept, empty, and
+// used again if the cluster comes back. With keepClusterNode, the Cluster node is copied back into the resources
+// partition. Rows of a cluster without partitions are deleted row by row.
+func (dao *DAO) truncateClusterPartitions(ctx context.Context, clusterName string, keepClusterNode bool) error {
+	defer metrics.QueryTimer("truncateClusterPartitions")()
+	existing, err := dao.existingClusterPartitions(ctx, clusterName)
+	if err != nil {
+		return err
+	}
+
+	clusterUID := quoteLiteral("cluster__" + clusterName)
+	statements := make([]string, 0, 4)
+	if existing["resources"] {
+		partition := pgx.Identifier{"search", clusterPartitionName("resources", clusterName)}.Sanitize()
+		if keepClusterNode {
+			statements = append(statements,
+				fmt.Sprintf("CREATE TEMP TABLE cluster_node ON COMMIT DROP AS SELECT * FROM %s WHERE uid=%s",
+					partition, clusterUID),
+				fmt.Sprintf("TRUNCATE %s", partition),
+				fmt.Sprintf("INSERT INTO %s SELECT * FROM cluster_node", partition))
+		} else {
+			statements = append(statements, fmt.Sprintf("TRUNCATE %s", partition))
+		}
+	} else if keepClusterNode {
+		statements = append(statements, fmt.Sprintf("DELETE FROM search.resources WHERE cluster=%s AND uid!=%s",
+			quoteLiteral(clusterName), clusterUID))
+	} else {
+		statements = append(statements, fmt.Sprintf("DELETE FROM search.resources WHERE uid=%s", clusterUID))
+	}
+	if existing["edges"] {
+		partition := pgx.Identifier{"search", clusterPartitionName("edges", clusterName)}.Sanitize()
+		statements = append(statements, fmt.Sprintf("TRUNCATE %s", partition))
+	} else {
+		statements = append(statements, fmt.Sprintf("DELETE FROM search.edges WHERE cluster=%s",
+			quoteLiteral(clusterName)))
+	}
+	return dao.execPartitionTxn(ctx, clusterName, statements)
+}
+
+// Run the partition statements in a transaction.
+func (dao *DAO) execPartitionTxn(ctx context.Context, clusterName string, statements []string) error {
+	tx, err := dao.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		klog.Error("Error while beginning transaction block for the partitions of cluster ", clusterName)
+		return err
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(ctx, statement); err != nil {
+			checkErrorAndRollback(err, fmt.Sprintf("Error updating the partitions of cluster %s. Statement: %s",
+				clusterName, statement), tx, ctx)
+			return err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error committing the partitions of cluster %s.", clusterName), tx, ctx)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// Set the partition mode used by the test.
+func setPartitionMode(t *testing.T, mode string) {
+	savedMode := partitionMode
+	partitionMode = mode
+	t.Cleanup(func() {
+		partitionMode = savedMode
+		clusterPartitions = map[string]struct{}{}
+	})
+}
+
+func Test_conflictTargets(t *testing.T) {
+	assert.Equal(t, "uid", resourcesConflictTarget())
+	assert.Equal(t, "sourceid, destid, edgetype", edgesConflictTarget())
+
+	setPartitionMode(t, "list")
+	assert.Equal(t, "uid, cluster", resourcesConflictTarget())
+	assert.Equal(t, "sourceid, destid, edgetype, cluster", edgesConflictTarget())
+}
+
+func Test_clusterPartitionName(t *testing.T) {
+	name := clusterPartitionName("resources", "a-very-long-cluster-name-that-is-close-to-the-63-character-limit")
+
+	assert.Equal(t, name, clusterPartitionName("resources", "a-very-long-cluster-name-that-is-close-to-the-63-character-limit"))
+	assert.NotEqual(t, name, clusterPartitionName("resources", "cluster1"))
+	assert.Regexp(t, "^resources_c[0-9a-f]{16}$", name)
+	assert.Equal(t, `'it''s'`, quoteLiteral("it's"))
+}
+
+// Should create the missing partitions for a cluster only once.
+func Test_ensureClusterPartitions(t *testing.T) {
+	setPartitionMode(t, "list")
+	dao, mockPool := buildMockDAO(t)
+
+	resources := clusterPartitionName("resources", "cluster1")
+	edges := clusterPartitionName("edges", "cluster1")
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), []string{resources, edges}).
+		Return(pgxpoolmock.NewRows([]string{"relname"}).AddRow(resources).ToPgxRows(), nil)
+	mockPool.EXPECT().Exec(gomock.Any(), `CREATE TABLE IF NOT EXISTS "search"."`+edges+
+		`" PARTITION OF search.edges FOR VALUES IN ('cluster1')`).Return(nil, nil)
+
+	dao.ensureClusterPartitions(context.Background(), "cluster1")
+	dao.ensureClusterPartitions(context.Background(), "cluster1")
+	dao.ensureClusterPartitions(context.Background(), "") // Hub rows use the hub partition.
+}
+
+// Should move the rows of the cluster out of the default partition when the partition can't be created.
+func Test_createClusterPartition_defaultRows(t *testing.T) {
+	setPartitionMode(t, "list")
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+
+	partition := `"search"."` + clusterPartitionName("resources", "cluster1") + `"`
+	mockPool.EXPECT().Exec(gomock.Any(), `CREATE TABLE IF NOT EXISTS `+partition+
+		` PARTITION OF search.resources FOR VALUES IN ('cluster1')`).Return(nil, &pgconn.PgError{Code: "23514"})
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
+	for _, statement := range []string{
+		`CREATE TABLE ` + partition + ` (LIKE search.resources INCLUDING ALL)`,
+		`WITH moved AS (DELETE FROM search.resources_default WHERE cluster='cluster1' RETURNING *) INSERT INTO ` +
+			partition + ` SELECT * FROM moved`,
+		`ALTER TABLE search.resources ATTACH PARTITION ` + partition + ` FOR VALUES IN ('cluster1')`,
+	} {
+		mockConn.ExpectExec(regexp.QuoteMeta(statement)).WillReturnResult(pgxmock.NewResult("CREATE", 0))
+	}
+	mockConn.ExpectCommit()
+
+	err = dao.createClusterPartition(context.Background(), "resources", "cluster1")
+
+	assert.Nil(t, err)
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+// Should truncate the partitions of the cluster and keep the Cluster node.
+func Test_DeleteClusterResourcesTxn_partitions(t *testing.T) {
+	setPartitionMode(t, "list")
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+
+	resources := clusterPartitionName("resources", "cluster1")
+	edges := clusterPartitionName("edges", "cluster1")
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), []string{resources, edges}).
+		Return(pgxpoolmock.NewRows([]string{"relname"}).AddRow(resources).AddRow(edges).ToPgxRows(), nil)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
+	for _, statement := range []string{
+		`CREATE TEMP TABLE cluster_node ON COMMIT DROP AS SELECT * FROM "search"."` + resources +
+			`" WHERE uid='cluster__cluster1'`,
+		`TRUNCATE "search"."` + resources + `"`,
+		`INSERT INTO "search"."` + resources + `" SELECT * FROM cluster_node`,
+		`TRUNCATE "search"."` + edges + `"`,
+	} {
+		mockConn.ExpectExec(regexp.QuoteMeta(statement)).WillReturnResult(pgxmock.NewResult("TRUNCATE", 0))
+	}
+	mockConn.ExpectCommit()
+
+	err = dao.DeleteClusterResourcesTxn(context.Background(), "cluster1")
+
+	assert.Nil(t, err)
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+// Should truncate the partitions of the cluster when the Cluster node is deleted.
+func Test_DeleteClusterTxn_partitions(t *testing.T) {
+	setPartitionMode(t, "list")
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+
+	resources := clusterPartitionName("resources", "cluster1")
+	edges := clusterPartitionName("edges", "cluster1")
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), []string{resources, edges}).
+		Return(pgxpoolmock.NewRows([]string{"relname"}).AddRow(resources).ToPgxRows(), nil)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta(`TRUNCATE "search"."` + resources + `"`)).
+		WillReturnResult(pgxmock.NewResult("TRUNCATE", 0))
+	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM search.edges WHERE cluster='cluster1'`)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mockConn.ExpectCommit()
+
+	err = dao.DeleteClusterTxn(context.Background(), "cluster__cluster1")
+
+	assert.Nil(t, err)
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+// Should use the tables without partitions if they were created without partitions.
+func Test_initializePartitionedTables_notPartitioned(t *testing.T) {
+	savedMode := config.Cfg.DBPartitionMode
+	config.Cfg.DBPartitionMode = "list"
+	defer func() { config.Cfg.DBPartitionMode = savedMode }()
+	dao, mockPool := buildMockDAO(t)
+
+	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any()).Return(nil, nil).Times(2)
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any()).
+		Return(pgxpoolmock.NewRows([]string{"count"}).AddRow(0).ToPgxRows(), nil)
+
+	assert.False(t, dao.initializePartitionedTables(context.Background()))
+}
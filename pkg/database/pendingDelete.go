@@ -0,0 +1,94 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// PendingDeleteProperty is the property with the time the data of a soft deleted cluster is purged. It's set on the
+// Cluster node and on every resource of the cluster, so consumers hide the resources with the filter
+// NOT data ? '_searchPendingDelete', without joining the Cluster node.
+const PendingDeleteProperty = "_searchPendingDelete"
+
+// MarkClusterPendingDelete sets the time to purge the data of the cluster on its Cluster node and its resources. A
+// cluster that is already pending delete keeps its time, so the grace period isn't extended by repeated deletes or a
+// new leader.
+// Returns the time to purge the data, and false if the Cluster node doesn't exist.
+func (dao *DAO) MarkClusterPendingDelete(ctx context.Context, clusterName string, purgeAt time.Time) (time.Time,
+	bool, error) {
+	data, found, err := dao.updateClusterNode(ctx, "markPendingDelete", `UPDATE search.resources
+		SET data=jsonb_set(data, '{`+PendingDeleteProperty+`}', to_jsonb(coalesce(data->>'`+PendingDeleteProperty+
+		`', $2::text))) WHERE uid=$1 RETURNING data`, "cluster__"+clusterName, purgeAt.UTC().Format(time.RFC3339))
+	if err != nil || !found {
+		return time.Time{}, false, err
+	}
+	value, _ := data[PendingDeleteProperty].(string)
+	if existing, err := time.Parse(time.RFC3339, value); err == nil {
+		purgeAt = existing
+	}
+	defer metrics.QueryTimer("markPendingDeleteResources")()
+	_, err = dao.pool.Exec(ctx, `UPDATE search.resources SET data=jsonb_set(data, '{`+PendingDeleteProperty+
+		`}', to_jsonb($2::text)) WHERE cluster=$1 AND NOT data ? '`+PendingDeleteProperty+`'`, clusterName,
+		purgeAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		klog.Warningf("Error marking the resources of cluster %s pending delete. %s", clusterName, err)
+		return time.Time{}, false, err
+	}
+	return purgeAt, true, nil
+}
+
+// ClearClusterPendingDelete removes the pending delete from the resources of the cluster and its Cluster node. The
+// Cluster node is cleared last, so a failure is retried while the cluster still looks pending delete. Returns true if
+// the cluster was pending delete.
+func (dao *DAO) ClearClusterPendingDelete(ctx context.Context, clusterName string) (bool, error) {
+	timer := metrics.QueryTimer("clearPendingDeleteResources")
+	_, err := dao.pool.Exec(ctx, `UPDATE search.resources SET data=data-'`+PendingDeleteProperty+
+		`' WHERE cluster=$1 AND uid!=$2 AND data ? '`+PendingDeleteProperty+`'`, clusterName, "cluster__"+clusterName)
+	timer()
+	if err != nil {
+		klog.Warningf("Error clearing the pending delete of the resources of cluster %s. %s", clusterName, err)
+		return false, err
+	}
+	_, found, err := dao.updateClusterNode(ctx, "clearPendingDelete", `UPDATE search.resources
+		SET data=data-'`+PendingDeleteProperty+`' WHERE uid=$1 AND data ? '`+PendingDeleteProperty+`' RETURNING data`,
+		"cluster__"+clusterName)
+	return found, err
+}
+
+// Clear the pending delete after the resources of the cluster are deleted, if the cached Cluster node has it.
+func (dao *DAO) clearPendingDeleteIfMarked(ctx context.Context, clusterName string) {
+	data, _ := ReadClustersCache("cluster__" + clusterName)
+	if props, _ := data.(map[string]interface{}); props[PendingDeleteProperty] == nil {
+		return
+	}
+	if _, err := dao.ClearClusterPendingDelete(ctx, clusterName); err != nil {
+		klog.Warningf("Error clearing the pending delete of cluster %s. %s", clusterName, err)
+	}
+}
+
+// Update the Cluster node and the clusters cache with the data returned by the query.
+func (dao *DAO) updateClusterNode(ctx context.Context, queryName, query, clusterUID string,
+	args ...interface{}) (map[string]interface{}, bool, error) {
+	defer metrics.QueryTimer(queryName)()
+	rows, err := dao.pool.Query(ctx, query, append([]interface{}{clusterUID}, args...)...)
+	if err != nil {
+		klog.Warningf("Error updating cluster node %s. %s", clusterUID, err)
+		return nil, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, false, rows.Err()
+	}
+	var data map[string]interface{}
+	if err = rows.Scan(&data); err != nil {
+		klog.Warningf("Error reading cluster node %s. %s", clusterUID, err)
+		return nil, false, err
+	}
+	UpdateClustersCache(clusterUID, data)
+	return data, true, nil
+}
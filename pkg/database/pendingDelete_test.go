@@ -0,0 +1,92 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+// Should keep the time to purge of a cluster that is already pending delete, mark the resources of the cluster with
+// the same time, and update the clusters cache.
+func Test_MarkClusterPendingDelete(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	t.Cleanup(func() { DeleteClustersCache("cluster__cluster1") })
+	data := map[string]interface{}{"name": "cluster1", PendingDeleteProperty: "2026-01-01T10:00:00Z"}
+	rows := pgxpoolmock.NewRows([]string{"data"}).AddRow(data).ToPgxRows()
+	gomock.InOrder(
+		mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster__cluster1", "2026-01-01T12:00:00Z").
+			Return(rows, nil),
+		mockPool.EXPECT().Exec(gomock.Any(),
+			`UPDATE search.resources SET data=jsonb_set(data, '{_searchPendingDelete}', to_jsonb($2::text)) `+
+				`WHERE cluster=$1 AND NOT data ? '_searchPendingDelete'`,
+			"cluster1", "2026-01-01T10:00:00Z").Return(pgconn.CommandTag("UPDATE 10"), nil),
+	)
+
+	purgeAt, found, err := dao.MarkClusterPendingDelete(context.Background(), "cluster1",
+		time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC), purgeAt)
+	cached, _ := ReadClustersCache("cluster__cluster1")
+	assert.Equal(t, data, cached)
+}
+
+// Should return false without a Cluster node.
+func Test_MarkClusterPendingDelete_noClusterNode(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	rows := pgxpoolmock.NewRows([]string{"data"}).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster__cluster1", gomock.Any()).Return(rows, nil)
+
+	_, found, err := dao.MarkClusterPendingDelete(context.Background(), "cluster1", time.Now())
+
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+// Should return the error when the resources can't be marked, so the caller deletes the data immediately.
+func Test_MarkClusterPendingDelete_resourcesError(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	t.Cleanup(func() { DeleteClustersCache("cluster__cluster1") })
+	data := map[string]interface{}{"name": "cluster1", PendingDeleteProperty: "2026-01-01T10:00:00Z"}
+	rows := pgxpoolmock.NewRows([]string{"data"}).AddRow(data).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster__cluster1", gomock.Any()).Return(rows, nil)
+	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), "cluster1", "2026-01-01T10:00:00Z").
+		Return(nil, errors.New("unexpected EOF"))
+
+	_, found, err := dao.MarkClusterPendingDelete(context.Background(), "cluster1", time.Now())
+
+	assert.NotNil(t, err)
+	assert.False(t, found)
+}
+
+func Test_ClearClusterPendingDelete(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	t.Cleanup(func() { DeleteClustersCache("cluster__cluster1") })
+	rows := pgxpoolmock.NewRows([]string{"data"}).AddRow(map[string]interface{}{"name": "cluster1"}).ToPgxRows()
+	gomock.InOrder(
+		mockPool.EXPECT().Exec(gomock.Any(),
+			`UPDATE search.resources SET data=data-'_searchPendingDelete' `+
+				`WHERE cluster=$1 AND uid!=$2 AND data ? '_searchPendingDelete'`,
+			"cluster1", "cluster__cluster1").Return(pgconn.CommandTag("UPDATE 10"), nil),
+		mockPool.EXPECT().Query(gomock.Any(),
+			`UPDATE search.resources
+		SET data=data-'_searchPendingDelete' WHERE uid=$1 AND data ? '_searchPendingDelete' RETURNING data`,
+			"cluster__cluster1").Return(rows, nil),
+	)
+
+	cleared, err := dao.ClearClusterPendingDelete(context.Background(), "cluster1")
+
+	assert.Nil(t, err)
+	assert.True(t, cleared)
+	cached, _ := ReadClustersCache("cluster__cluster1")
+	assert.Equal(t, map[string]interface{}{"name": "cluster1"}, cached)
+}
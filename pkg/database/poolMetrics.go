@@ -0,0 +1,85 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Snapshot of the pgxpool statistics.
+type poolStat struct {
+	acquiredConns     int32
+	idleConns         int32
+	totalConns        int32
+	maxConns          int32
+	acquireCount      int64
+	acquireWait       float64 // Seconds
+	emptyAcquireCount int64
+}
+
+func getPoolStat(pool *pgxpool.Pool) poolStat {
+	stat := pool.Stat()
+	return poolStat{
+		acquiredConns:     stat.AcquiredConns(),
+		idleConns:         stat.IdleConns(),
+		totalConns:        stat.TotalConns(),
+		maxConns:          stat.MaxConns(),
+		acquireCount:      stat.AcquireCount(),
+		acquireWait:       stat.AcquireDuration().Seconds(),
+		emptyAcquireCount: stat.EmptyAcquireCount(),
+	}
+}
+
+// Prometheus collector for the connection pool. The statistics are read from the pool when the metrics are scraped.
+type poolCollector struct {
+	stat              func() poolStat
+	acquiredConns     *prometheus.Desc
+	idleConns         *prometheus.Desc
+	totalConns        *prometheus.Desc
+	maxConns          *prometheus.Desc
+	acquireCount      *prometheus.Desc
+	acquireWait       *prometheus.Desc
+	emptyAcquireCount *prometheus.Desc
+}
+
+func newPoolCollector(stat func() poolStat) *poolCollector {
+	return &poolCollector{
+		stat: stat,
+		acquiredConns: prometheus.NewDesc("search_indexer_db_pool_acquired_conns",
+			"Connections currently acquired from the database pool.", nil, nil),
+		idleConns: prometheus.NewDesc("search_indexer_db_pool_idle_conns",
+			"Idle connections in the database pool.", nil, nil),
+		totalConns: prometheus.NewDesc("search_indexer_db_pool_total_conns",
+			"Total connections in the database pool.", nil, nil),
+		maxConns: prometheus.NewDesc("search_indexer_db_pool_max_conns",
+			"Maximum connections in the database pool (DB_MAX_CONNS).", nil, nil),
+		acquireCount: prometheus.NewDesc("search_indexer_db_pool_acquire_total",
+			"Total connections acquired from the database pool.", nil, nil),
+		acquireWait: prometheus.NewDesc("search_indexer_db_pool_acquire_wait_seconds_total",
+			"Total time (seconds) waiting to acquire a connection from the database pool.", nil, nil),
+		emptyAcquireCount: prometheus.NewDesc("search_indexer_db_pool_empty_acquire_total",
+			"Total acquires that waited because the database pool didn't have an idle connection.", nil, nil),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquireCount
+	ch <- c.acquireWait
+	ch <- c.emptyAcquireCount
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.stat()
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.acquiredConns))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.idleConns))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.totalConns))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.maxConns))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.acquireCount))
+	ch <- prometheus.MustNewConstMetric(c.acquireWait, prometheus.CounterValue, stat.acquireWait)
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquireCount, prometheus.CounterValue, float64(stat.emptyAcquireCount))
+}
@@ -0,0 +1,35 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_poolCollector(t *testing.T) {
+	collector := newPoolCollector(func() poolStat {
+		return poolStat{acquiredConns: 3, idleConns: 2, totalConns: 5, maxConns: 10, acquireCount: 100,
+			acquireWait: 1.5, emptyAcquireCount: 4}
+	})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	count, err := testutil.GatherAndCount(registry)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, count)
+
+	families, _ := registry.Gather()
+	values := map[string]float64{}
+	for _, family := range families {
+		metric := family.GetMetric()[0]
+		values[family.GetName()] = metric.GetGauge().GetValue() + metric.GetCounter().GetValue()
+	}
+	assert.Equal(t, 3.0, values["search_indexer_db_pool_acquired_conns"])
+	assert.Equal(t, 10.0, values["search_indexer_db_pool_max_conns"])
+	assert.Equal(t, 1.5, values["search_indexer_db_pool_acquire_wait_seconds_total"])
+	assert.Equal(t, 4.0, values["search_indexer_db_pool_empty_acquire_total"])
+}
@@ -0,0 +1,242 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"k8s.io/klog/v2"
+)
+
+// Property catalog for autocompletion, enabled with PROPERTY_CATALOG_SAMPLES.
+//
+// search.property_catalog has a row for each cluster, kind, and property, and a global row with cluster '*'. Each row
+// has the JSON type of the property, up to PROPERTY_CATALOG_SAMPLES distinct values, and the cardinality. The
+// cardinality is exact up to the number of samples; a cardinality above it means there are more values than samples.
+// Objects, like labels, are sampled as key=value.
+//
+// Syncs add the values of the added and updated resources to the catalog. Resyncs replace the rows of the cluster.
+// Global rows are only added to, so they keep the values of deleted resources. Every sync of every cluster adds to the
+// same global rows, so each replica merges its global changes in memory and upserts them at most once every
+// globalCatalogInterval. The rows are upserted in the order of (kind, property), so concurrent upserts lock the rows
+// in the same order and don't deadlock.
+
+// Cluster of the global rows. Kubernetes names can't include '*'.
+const globalCatalogCluster = "*"
+
+type catalogProperty struct {
+	dataType string
+	samples  map[string]struct{}
+	more     bool // More distinct values than samples.
+}
+
+// Properties of the resources in a sync or resync.
+type propertyCatalog map[propertyKey]*catalogProperty
+
+// Global properties waiting to be upserted, and the time of the last upsert.
+var globalCatalog = struct {
+	lock    sync.Mutex
+	pending propertyCatalog
+	saved   time.Time
+}{pending: propertyCatalog{}}
+var globalCatalogInterval = 30 * time.Second
+
+// Add the properties of the resource to the catalog.
+func (catalog propertyCatalog) add(resource model.Resource) {
+	if config.Cfg.PropertySamples <= 0 {
+		return
+	}
+	kind := resource.Kind
+	if kind == "" {
+		kind, _ = resource.Properties["kind"].(string)
+	}
+	for property, value := range resource.Properties {
+		dataType := valueType(value)
+		if dataType == "" {
+			continue
+		}
+		key := propertyKey{kind, property}
+		entry, ok := catalog[key]
+		if !ok {
+			entry = &catalogProperty{dataType: dataType, samples: map[string]struct{}{}}
+			catalog[key] = entry
+		}
+		for _, sample := range sampleValues(value) {
+			if _, ok := entry.samples[sample]; ok {
+				continue
+			}
+			if len(entry.samples) >= config.Cfg.PropertySamples {
+				entry.more = true
+				break
+			}
+			entry.samples[sample] = struct{}{}
+		}
+	}
+}
+
+// Merge the properties of another catalog, keeping up to PROPERTY_CATALOG_SAMPLES samples.
+func (catalog propertyCatalog) merge(other propertyCatalog) {
+	for key, otherEntry := range other {
+		entry, ok := catalog[key]
+		if !ok {
+			entry = &catalogProperty{dataType: otherEntry.dataType, samples: map[string]struct{}{}}
+			catalog[key] = entry
+		}
+		entry.more = entry.more || otherEntry.more
+		for sample := range otherEntry.samples {
+			if _, ok := entry.samples[sample]; ok {
+				continue
+			}
+			if len(entry.samples) >= config.Cfg.PropertySamples {
+				entry.more = true
+				break
+			}
+			entry.samples[sample] = struct{}{}
+		}
+	}
+}
+
+// Parameters of the upsert query, sorted by kind and property.
+func (catalog propertyCatalog) columns() (kinds, properties, dataTypes, samples []string, cardinalities []int) {
+	keys := make([]propertyKey, 0, len(catalog))
+	for key := range catalog {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].kind != keys[j].kind {
+			return keys[i].kind < keys[j].kind
+		}
+		return keys[i].property < keys[j].property
+	})
+	kinds = make([]string, 0, len(catalog))
+	properties = make([]string, 0, len(catalog))
+	dataTypes = make([]string, 0, len(catalog))
+	samples = make([]string, 0, len(catalog))
+	cardinalities = make([]int, 0, len(catalog))
+	for _, key := range keys {
+		entry := catalog[key]
+		values := make([]string, 0, len(entry.samples))
+		for value := range entry.samples {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		valuesJSON, _ := json.Marshal(values)
+		cardinality := len(values)
+		if entry.more {
+			cardinality++
+		}
+		kinds = append(kinds, key.kind)
+		properties = append(properties, key.property)
+		dataTypes = append(dataTypes, entry.dataType)
+		samples = append(samples, string(valuesJSON))
+		cardinalities = append(cardinalities, cardinality)
+	}
+	return kinds, properties, dataTypes, samples, cardinalities
+}
+
+// Values of a property used as samples.
+func sampleValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case []interface{}:
+		samples := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, ok := item.([]interface{}); !ok {
+				samples = append(samples, sampleValues(item)...)
+			}
+		}
+		return samples
+	case map[string]interface{}:
+		samples := make([]string, 0, len(v))
+		for key, item := range v {
+			samples = append(samples, fmt.Sprintf("%s=%v", key, item))
+		}
+		return samples
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// Upsert the properties of the cluster. Merges the samples of existing rows, or replaces them on resync.
+func catalogUpsertQuery(replace bool) string {
+	update := `samples=(SELECT coalesce(jsonb_agg(v), '[]'::jsonb) FROM (SELECT DISTINCT v
+			FROM jsonb_array_elements_text(c.samples || EXCLUDED.samples) AS e(v) ORDER BY v LIMIT $7) s),
+		cardinality=LEAST($7+1, GREATEST(c.cardinality, EXCLUDED.cardinality,
+			(SELECT count(DISTINCT v) FROM jsonb_array_elements_text(c.samples || EXCLUDED.samples) AS e(v))))`
+	if replace {
+		update = "type=EXCLUDED.type, samples=EXCLUDED.samples, cardinality=EXCLUDED.cardinality"
+	}
+	return `INSERT INTO search.property_catalog AS c (cluster, kind, property, type, samples, cardinality)
+		SELECT $1, k, p, t, s::jsonb, n FROM unnest($2::text[], $3::text[], $4::text[], $5::text[], $6::int[]) AS u(k, p, t, s, n)
+		ORDER BY k, p
+		ON CONFLICT (cluster, kind, property) DO UPDATE SET ` + update
+}
+
+// Delete the rows of the cluster for properties that weren't in the resync.
+const catalogDeleteMissingQuery = `DELETE FROM search.property_catalog WHERE cluster=$1
+	AND (kind, property) NOT IN (SELECT * FROM unnest($2::text[], $3::text[]))`
+
+// Save the properties of a sync or resync to the catalog. Errors are logged, the catalog is updated again on the
+// next sync.
+func (dao *DAO) savePropertyCatalog(ctx context.Context, clusterName string, catalog propertyCatalog, resync bool) {
+	if config.Cfg.PropertySamples <= 0 || (len(catalog) == 0 && !resync) {
+		return
+	}
+	defer metrics.QueryTimer("savePropertyCatalog")()
+	kinds, properties, dataTypes, samples, cardinalities := catalog.columns()
+	if _, err := dao.pool.Exec(ctx, catalogUpsertQuery(resync), clusterName, kinds, properties, dataTypes, samples,
+		cardinalities, config.Cfg.PropertySamples); err != nil {
+		klog.Warningf("Error saving the property catalog of cluster %s. %s", clusterName, err)
+		return
+	}
+	if resync {
+		if _, err := dao.pool.Exec(ctx, catalogDeleteMissingQuery, clusterName, kinds, properties); err != nil {
+			klog.Warningf("Error deleting old properties from the catalog of cluster %s. %s", clusterName, err)
+		}
+	}
+	dao.saveGlobalCatalog(ctx, catalog)
+}
+
+// Merge the properties into the global rows, and upsert them if the last upsert was at least globalCatalogInterval
+// ago. The properties are merged into the next upsert if it fails.
+func (dao *DAO) saveGlobalCatalog(ctx context.Context, catalog propertyCatalog) {
+	globalCatalog.lock.Lock()
+	globalCatalog.pending.merge(catalog)
+	if time.Since(globalCatalog.saved) < globalCatalogInterval || len(globalCatalog.pending) == 0 {
+		globalCatalog.lock.Unlock()
+		return
+	}
+	pending := globalCatalog.pending
+	globalCatalog.pending, globalCatalog.saved = propertyCatalog{}, time.Now()
+	globalCatalog.lock.Unlock()
+
+	kinds, properties, dataTypes, samples, cardinalities := pending.columns()
+	if _, err := dao.pool.Exec(ctx, catalogUpsertQuery(false), globalCatalogCluster, kinds, properties, dataTypes,
+		samples, cardinalities, config.Cfg.PropertySamples); err != nil {
+		klog.Warningf("Error saving the global property catalog. %s", err)
+		globalCatalog.lock.Lock()
+		globalCatalog.pending.merge(pending)
+		globalCatalog.lock.Unlock()
+	}
+}
+
+// Delete the catalog rows of the cluster.
+func (dao *DAO) deletePropertyCatalog(ctx context.Context, clusterName string) {
+	if config.Cfg.PropertySamples <= 0 {
+		return
+	}
+	if _, err := dao.pool.Exec(ctx, "DELETE FROM search.property_catalog WHERE cluster=$1", clusterName); err != nil {
+		klog.Warningf("Error deleting the property catalog of cluster %s. %s", clusterName, err)
+	}
+}
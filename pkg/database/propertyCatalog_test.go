@@ -0,0 +1,134 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func setPropertySamples(t *testing.T, samples int) {
+	saved := config.Cfg.PropertySamples
+	config.Cfg.PropertySamples = samples
+	resetGlobalCatalog := func() {
+		globalCatalog.pending, globalCatalog.saved = propertyCatalog{}, time.Time{}
+	}
+	resetGlobalCatalog()
+	t.Cleanup(func() {
+		config.Cfg.PropertySamples = saved
+		resetGlobalCatalog()
+	})
+}
+
+// Should sample up to PROPERTY_CATALOG_SAMPLES distinct values for each kind and property.
+func Test_propertyCatalog_add(t *testing.T) {
+	setPropertySamples(t, 2)
+	catalog := propertyCatalog{}
+
+	catalog.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{
+		"status": "Running", "restarts": float64(0), "label": map[string]interface{}{"app": "web"},
+		"container": []interface{}{"nginx", "envoy"}}})
+	catalog.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{
+		"status": "Running", "restarts": float64(1)}})
+	catalog.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{
+		"status": "Pending", "restarts": float64(2)}})
+
+	status := catalog[propertyKey{"Pod", "status"}]
+	assert.Equal(t, "string", status.dataType)
+	assert.Equal(t, map[string]struct{}{"Running": {}, "Pending": {}}, status.samples)
+	assert.False(t, status.more)
+	restarts := catalog[propertyKey{"Pod", "restarts"}]
+	assert.Equal(t, "number", restarts.dataType)
+	assert.Equal(t, map[string]struct{}{"0": {}, "1": {}}, restarts.samples)
+	assert.True(t, restarts.more)
+	assert.Equal(t, map[string]struct{}{"app=web": {}}, catalog[propertyKey{"Pod", "label"}].samples)
+	assert.Equal(t, "array", catalog[propertyKey{"Pod", "container"}].dataType)
+}
+
+func Test_propertyCatalog_disabled(t *testing.T) {
+	catalog := propertyCatalog{}
+	dao, _ := buildMockDAO(t)
+
+	catalog.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{"status": "Running"}})
+	dao.savePropertyCatalog(context.Background(), "cluster1", catalog, true)
+
+	assert.Empty(t, catalog)
+}
+
+// Should merge the samples of the cluster and global rows.
+func Test_savePropertyCatalog_sync(t *testing.T) {
+	setPropertySamples(t, 2)
+	dao, mockPool := buildMockDAO(t)
+	catalog := propertyCatalog{}
+	catalog.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{"status": "Running"}})
+
+	gomock.InOrder(
+		mockPool.EXPECT().Exec(gomock.Any(), catalogUpsertQuery(false), "cluster1", []string{"Pod"},
+			[]string{"status"}, []string{"string"}, []string{`["Running"]`}, []int{1}, 2).Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(), catalogUpsertQuery(false), "*", []string{"Pod"},
+			[]string{"status"}, []string{"string"}, []string{`["Running"]`}, []int{1}, 2).Return(nil, nil),
+	)
+
+	dao.savePropertyCatalog(context.Background(), "cluster1", catalog, false)
+}
+
+// Should replace the rows of the cluster and delete the properties that weren't in the resync.
+func Test_savePropertyCatalog_resync(t *testing.T) {
+	setPropertySamples(t, 2)
+	dao, mockPool := buildMockDAO(t)
+	catalog := propertyCatalog{}
+	catalog.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{"restarts": float64(3)}})
+
+	gomock.InOrder(
+		mockPool.EXPECT().Exec(gomock.Any(), catalogUpsertQuery(true), "cluster1", []string{"Pod"},
+			[]string{"restarts"}, []string{"number"}, []string{`["3"]`}, []int{1}, 2).Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(), catalogDeleteMissingQuery, "cluster1", []string{"Pod"},
+			[]string{"restarts"}).Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(), catalogUpsertQuery(false), "*", []string{"Pod"},
+			[]string{"restarts"}, []string{"number"}, []string{`["3"]`}, []int{1}, 2).Return(nil, nil),
+	)
+
+	dao.savePropertyCatalog(context.Background(), "cluster1", catalog, true)
+}
+
+// Should upsert the rows sorted by kind and property, and throttle the upserts of the global rows.
+func Test_savePropertyCatalog_globalThrottle(t *testing.T) {
+	setPropertySamples(t, 2)
+	dao, mockPool := buildMockDAO(t)
+	first := propertyCatalog{}
+	first.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{"status": "Running", "name": "a"}})
+	first.add(model.Resource{Kind: "Deployment", Properties: map[string]interface{}{"name": "b"}})
+	second := propertyCatalog{}
+	second.add(model.Resource{Kind: "Pod", Properties: map[string]interface{}{"status": "Pending"}})
+
+	kinds := []string{"Deployment", "Pod", "Pod"}
+	properties := []string{"name", "name", "status"}
+	gomock.InOrder(
+		mockPool.EXPECT().Exec(gomock.Any(), catalogUpsertQuery(false), "cluster1", kinds, properties,
+			gomock.Any(), gomock.Any(), gomock.Any(), 2).Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(), catalogUpsertQuery(false), "*", kinds, properties,
+			gomock.Any(), gomock.Any(), gomock.Any(), 2).Return(nil, nil),
+		mockPool.EXPECT().Exec(gomock.Any(), catalogUpsertQuery(false), "cluster2", []string{"Pod"},
+			[]string{"status"}, gomock.Any(), gomock.Any(), gomock.Any(), 2).Return(nil, nil),
+	)
+
+	dao.savePropertyCatalog(context.Background(), "cluster1", first, false)
+	dao.savePropertyCatalog(context.Background(), "cluster2", second, false)
+
+	assert.Equal(t, map[string]struct{}{"Pending": {}}, globalCatalog.pending[propertyKey{"Pod", "status"}].samples)
+}
+
+func Test_deletePropertyCatalog(t *testing.T) {
+	setPropertySamples(t, 10)
+	dao, mockPool := buildMockDAO(t)
+	mockPool.EXPECT().Exec(gomock.Any(), "DELETE FROM search.property_catalog WHERE cluster=$1", "cluster1").
+		Return(nil, nil)
+
+	dao.deletePropertyCatalog(context.Background(), "cluster1")
+}
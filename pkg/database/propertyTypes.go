@@ -0,0 +1,352 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Normalization of the property types (PROPERTY_TYPES_MODE).
+//
+//	off         Properties are stored as received. Default.
+//	configured  Properties with a type in the PROPERTY_TYPES_CONFIG_PATH file are coerced to that type.
+//	learned     Also coerces the other properties to the type first seen for the kind and property.
+//
+// The type of each kind and property is recorded in search.property_types, so all the indexer replicas learn the
+// same types. Values that can't be coerced are stored as received and reported in SyncResponse.PropertyErrors.
+//
+// Sample PROPERTY_TYPES_CONFIG_PATH file:
+//
+//	properties:
+//	- kind: Node          # Default: all kinds
+//	  property: memory
+//	  type: bytes         # Kubernetes quantity, like 16Gi, stored as a number of bytes
+//	- property: created
+//	  type: timestamp     # Stored as RFC3339 in UTC
+
+var propertyTypeNames = map[string]struct{}{
+	"string": {}, "number": {}, "bool": {}, "array": {}, "object": {}, "bytes": {}, "timestamp": {},
+}
+
+// Formats accepted for timestamps, besides unix seconds.
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05 -0700 MST", time.RFC1123Z, time.RFC1123}
+
+// PropertyTypesConfig is the content of the PROPERTY_TYPES_CONFIG_PATH file.
+type PropertyTypesConfig struct {
+	Properties []PropertyType `json:"properties"`
+}
+
+// PropertyType is the type of a property for a kind.
+type PropertyType struct {
+	Kind     string `json:"kind,omitempty"` // Default: all kinds
+	Property string `json:"property"`
+	Type     string `json:"type"` // string, number, bool, array, object, bytes or timestamp.
+}
+
+type propertyKey struct {
+	kind, property string
+}
+
+type catalogType struct {
+	dataType   string
+	configured bool
+}
+
+// Types of the properties, loaded from the configuration and search.property_types.
+type propertyTypes struct {
+	lock       sync.RWMutex
+	configured map[propertyKey]string      // Kind is "" for the properties configured for all kinds.
+	catalog    map[propertyKey]string      // Types recorded in search.property_types.
+	unsaved    map[propertyKey]catalogType // Types not yet recorded in search.property_types.
+}
+
+var propTypes = newPropertyTypes()
+
+func newPropertyTypes() *propertyTypes {
+	return &propertyTypes{
+		configured: map[propertyKey]string{},
+		catalog:    map[propertyKey]string{},
+		unsaved:    map[propertyKey]catalogType{},
+	}
+}
+
+// LoadPropertyTypesConfig reads and validates the property types configuration file.
+func LoadPropertyTypesConfig(path string) (PropertyTypesConfig, error) {
+	typesConfig := PropertyTypesConfig{}
+	content, err := os.ReadFile(path) // #nosec G304 -- Path is set by the administrator.
+	if err != nil {
+		return typesConfig, err
+	}
+	if err = yaml.UnmarshalStrict(content, &typesConfig); err != nil {
+		return typesConfig, fmt.Errorf("error parsing property types configuration %s: %w", path, err)
+	}
+	for i, propertyType := range typesConfig.Properties {
+		if propertyType.Property == "" {
+			return typesConfig, fmt.Errorf("property %d in %s doesn't have a name", i, path)
+		}
+		if _, ok := propertyTypeNames[propertyType.Type]; !ok {
+			return typesConfig, fmt.Errorf("property %s in %s has invalid type %q", propertyType.Property, path,
+				propertyType.Type)
+		}
+	}
+	return typesConfig, nil
+}
+
+// LoadPropertyTypes loads the configured types and the types recorded in search.property_types.
+func (dao *DAO) LoadPropertyTypes(ctx context.Context) {
+	if config.Cfg.PropertyTypesMode == "off" {
+		return
+	}
+	types := newPropertyTypes()
+	if config.Cfg.PropertyTypesPath != "" {
+		typesConfig, err := LoadPropertyTypesConfig(config.Cfg.PropertyTypesPath)
+		if err != nil {
+			klog.Errorf("Error loading the property types configuration. Only learned types are used. %s", err)
+		}
+		for _, propertyType := range typesConfig.Properties {
+			types.configured[propertyKey{propertyType.Kind, propertyType.Property}] = propertyType.Type
+		}
+	}
+
+	defer metrics.QueryTimer("loadPropertyTypes")()
+	rows, err := dao.pool.Query(ctx, "SELECT kind, property, type FROM search.property_types")
+	if err != nil {
+		klog.Errorf("Error reading search.property_types. Types will be learned again. %s", err)
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var kind, property, dataType string
+			if err = rows.Scan(&kind, &property, &dataType); err != nil {
+				klog.Errorf("Error reading search.property_types. %s", err)
+				break
+			}
+			types.catalog[propertyKey{kind, property}] = dataType
+		}
+	}
+	klog.Infof("Loaded %d configured and %d recorded property types.", len(types.configured), len(types.catalog))
+
+	propTypes.lock.Lock()
+	defer propTypes.lock.Unlock()
+	// Keep the types learned before loading until they are saved.
+	for key, dataType := range propTypes.unsaved {
+		types.catalog[key] = dataType.dataType
+	}
+	propTypes.configured, propTypes.catalog = types.configured, types.catalog
+}
+
+// Coerce the properties of the resource to their types. Returns the properties that couldn't be coerced, which keep
+// the value received.
+func normalizeProperties(resource *model.Resource) []model.SyncError {
+	if config.Cfg.PropertyTypesMode == "off" {
+		return nil
+	}
+	kind := resource.Kind
+	if kind == "" {
+		kind, _ = resource.Properties["kind"].(string)
+	}
+	var errs []model.SyncError
+	for property, value := range resource.Properties {
+		dataType, enforce := propTypes.typeOf(kind, property, value)
+		if !enforce || value == nil {
+			continue
+		}
+		coerced, err := coerceValue(value, dataType)
+		if err != nil {
+			errs = append(errs, model.SyncError{ResourceUID: resource.UID,
+				Message: fmt.Sprintf("property %s of kind %s: %s", property, kind, err)})
+			continue
+		}
+		resource.Properties[property] = coerced
+	}
+	return errs
+}
+
+// Get the type of the property, and whether values must be coerced to the type. The first type seen for a property
+// without a configured or recorded type is recorded.
+func (types *propertyTypes) typeOf(kind, property string, value interface{}) (string, bool) {
+	key := propertyKey{kind, property}
+	types.lock.RLock()
+	dataType, configured := types.configured[key]
+	if !configured {
+		dataType, configured = types.configured[propertyKey{"", property}]
+	}
+	recorded, isRecorded := types.catalog[key]
+	types.lock.RUnlock()
+
+	if configured {
+		if recorded != dataType {
+			types.record(key, catalogType{dataType: dataType, configured: true})
+		}
+		return dataType, true
+	}
+	if isRecorded {
+		return recorded, config.Cfg.PropertyTypesMode == "learned"
+	}
+	if observed := valueType(value); observed != "" {
+		types.record(key, catalogType{dataType: observed})
+	}
+	return "", false
+}
+
+func (types *propertyTypes) record(key propertyKey, dataType catalogType) {
+	types.lock.Lock()
+	defer types.lock.Unlock()
+	types.catalog[key] = dataType.dataType
+	types.unsaved[key] = dataType
+}
+
+// Record the new types in search.property_types. When replicas learn different types for a property, the type
+// recorded first is kept and returned to update the catalog.
+func (dao *DAO) savePropertyTypes(ctx context.Context) {
+	propTypes.lock.Lock()
+	unsaved := propTypes.unsaved
+	if len(unsaved) == 0 {
+		propTypes.lock.Unlock()
+		return
+	}
+	propTypes.unsaved = map[propertyKey]catalogType{}
+	propTypes.lock.Unlock()
+
+	kinds := make([]string, 0, len(unsaved))
+	properties := make([]string, 0, len(unsaved))
+	dataTypes := make([]string, 0, len(unsaved))
+	configured := make([]bool, 0, len(unsaved))
+	for key, dataType := range unsaved {
+		kinds = append(kinds, key.kind)
+		properties = append(properties, key.property)
+		dataTypes = append(dataTypes, dataType.dataType)
+		configured = append(configured, dataType.configured)
+	}
+
+	defer metrics.QueryTimer("savePropertyTypes")()
+	rows, err := dao.pool.Query(ctx, `INSERT INTO search.property_types AS t (kind, property, type, configured)
+		SELECT * FROM unnest($1::text[], $2::text[], $3::text[], $4::boolean[])
+		ON CONFLICT (kind, property) DO UPDATE
+		SET type=CASE WHEN EXCLUDED.configured THEN EXCLUDED.type ELSE t.type END, configured=t.configured OR EXCLUDED.configured
+		RETURNING kind, property, type`, kinds, properties, dataTypes, configured)
+	if err != nil {
+		klog.Warningf("Error saving %d property types. Will retry with the next sync. %s", len(unsaved), err)
+		propTypes.restore(unsaved)
+		return
+	}
+	defer rows.Close()
+	propTypes.lock.Lock()
+	defer propTypes.lock.Unlock()
+	for rows.Next() {
+		var kind, property, dataType string
+		if err = rows.Scan(&kind, &property, &dataType); err != nil {
+			klog.Warningf("Error reading the saved property types. %s", err)
+			return
+		}
+		propTypes.catalog[propertyKey{kind, property}] = dataType
+	}
+}
+
+// Keep the types that couldn't be saved, unless a newer type was recorded.
+func (types *propertyTypes) restore(unsaved map[propertyKey]catalogType) {
+	types.lock.Lock()
+	defer types.lock.Unlock()
+	for key, dataType := range unsaved {
+		if _, ok := types.unsaved[key]; !ok {
+			types.unsaved[key] = dataType
+		}
+	}
+}
+
+// Type of a JSON value.
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64, int, int64:
+		return "number"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return ""
+}
+
+// Coerce a JSON value to the type.
+func coerceValue(value interface{}, dataType string) (interface{}, error) {
+	switch dataType {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case int, int64:
+			return fmt.Sprint(v), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case "number":
+		switch v := value.(type) {
+		case float64, int, int64:
+			return v, nil
+		case string:
+			if number, err := strconv.ParseFloat(v, 64); err == nil {
+				return number, nil
+			}
+		}
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+	case "array":
+		switch v := value.(type) {
+		case []interface{}:
+			return v, nil
+		case map[string]interface{}:
+			// An object isn't an array element.
+		default:
+			return []interface{}{v}, nil
+		}
+	case "object":
+		if v, ok := value.(map[string]interface{}); ok {
+			return v, nil
+		}
+	case "bytes":
+		switch v := value.(type) {
+		case float64, int, int64:
+			return v, nil
+		case string:
+			if quantity, err := resource.ParseQuantity(v); err == nil {
+				return quantity.Value(), nil
+			}
+		}
+	case "timestamp":
+		switch v := value.(type) {
+		case float64:
+			return time.Unix(int64(v), 0).UTC().Format(time.RFC3339), nil
+		case string:
+			for _, layout := range timestampLayouts {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t.UTC().Format(time.RFC3339), nil
+				}
+			}
+		}
+	}
+	return value, fmt.Errorf("can't convert %v to %s", value, dataType)
+}
@@ -0,0 +1,153 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// Set the property types mode and start with empty types for the test.
+func setPropertyTypesMode(t *testing.T, mode string, configured map[propertyKey]string) {
+	savedMode := config.Cfg.PropertyTypesMode
+	savedTypes := propTypes
+	config.Cfg.PropertyTypesMode = mode
+	propTypes = newPropertyTypes()
+	for key, dataType := range configured {
+		propTypes.configured[key] = dataType
+	}
+	t.Cleanup(func() {
+		config.Cfg.PropertyTypesMode = savedMode
+		propTypes = savedTypes
+	})
+}
+
+func Test_LoadPropertyTypesConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "types.yaml")
+	_ = os.WriteFile(path, []byte("properties:\n- kind: Node\n  property: memory\n  type: bytes\n- property: created\n  type: timestamp\n"), 0600)
+
+	typesConfig, err := LoadPropertyTypesConfig(path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []PropertyType{{Kind: "Node", Property: "memory", Type: "bytes"},
+		{Property: "created", Type: "timestamp"}}, typesConfig.Properties)
+
+	_ = os.WriteFile(path, []byte("properties:\n- property: memory\n  type: quantity\n"), 0600)
+	_, err = LoadPropertyTypesConfig(path)
+	assert.EqualError(t, err, "property memory in "+path+" has invalid type \"quantity\"")
+}
+
+func Test_coerceValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		dataType string
+		expected interface{}
+	}{
+		{float64(2), "string", "2"},
+		{true, "string", "true"},
+		{"1.5", "number", 1.5},
+		{"true", "bool", true},
+		{"a", "array", []interface{}{"a"}},
+		{"16Gi", "bytes", int64(17179869184)},
+		{"500m", "bytes", int64(1)},
+		{"2024-05-01T10:00:00+02:00", "timestamp", "2024-05-01T08:00:00Z"},
+		{"2024-05-01 10:00:00 +0000 UTC", "timestamp", "2024-05-01T10:00:00Z"},
+		{float64(1714557600), "timestamp", "2024-05-01T10:00:00Z"},
+	}
+	for _, test := range tests {
+		coerced, err := coerceValue(test.value, test.dataType)
+		assert.Nil(t, err, test)
+		assert.Equal(t, test.expected, coerced, test)
+	}
+
+	invalid := []struct {
+		value    interface{}
+		dataType string
+	}{
+		{[]interface{}{"a"}, "string"}, {"abc", "number"}, {"abc", "bytes"}, {"yesterday", "timestamp"},
+		{map[string]interface{}{}, "array"}, {"a", "object"},
+	}
+	for _, test := range invalid {
+		coerced, err := coerceValue(test.value, test.dataType)
+		assert.NotNil(t, err, test)
+		assert.Equal(t, test.value, coerced, test)
+	}
+}
+
+// Should coerce configured properties and report the values that can't be coerced.
+func Test_normalizeProperties_configured(t *testing.T) {
+	setPropertyTypesMode(t, "configured", map[propertyKey]string{
+		{"Node", "memory"}: "bytes", {"", "created"}: "timestamp"})
+	resource := model.Resource{Kind: "Node", UID: "local-cluster/node-1", Properties: map[string]interface{}{
+		"kind": "Node", "memory": "2Ki", "created": "not a time", "cpu": "4"}}
+
+	errs := normalizeProperties(&resource)
+
+	assert.Equal(t, int64(2048), resource.Properties["memory"])
+	assert.Equal(t, "not a time", resource.Properties["created"])
+	assert.Equal(t, "4", resource.Properties["cpu"]) // Learned, but only enforced in learned mode.
+	assert.Equal(t, []model.SyncError{{ResourceUID: "local-cluster/node-1",
+		Message: "property created of kind Node: can't convert not a time to timestamp"}}, errs)
+	assert.Equal(t, catalogType{dataType: "bytes", configured: true}, propTypes.unsaved[propertyKey{"Node", "memory"}])
+	assert.Equal(t, catalogType{dataType: "string"}, propTypes.unsaved[propertyKey{"Node", "cpu"}])
+}
+
+// Should coerce properties to the type first seen for the kind.
+func Test_normalizeProperties_learned(t *testing.T) {
+	setPropertyTypesMode(t, "learned", nil)
+	first := model.Resource{Kind: "Pod", UID: "c1/pod-1", Properties: map[string]interface{}{"restarts": float64(1)}}
+	second := model.Resource{Kind: "Pod", UID: "c2/pod-2", Properties: map[string]interface{}{"restarts": "3"}}
+	third := model.Resource{Kind: "Job", UID: "c2/job-1", Properties: map[string]interface{}{"restarts": "3"}}
+
+	assert.Empty(t, normalizeProperties(&first))
+	assert.Empty(t, normalizeProperties(&second))
+	assert.Empty(t, normalizeProperties(&third))
+
+	assert.Equal(t, float64(3), second.Properties["restarts"])
+	assert.Equal(t, "3", third.Properties["restarts"]) // Types are learned for each kind.
+}
+
+// Should save the new types and update the catalog with the types returned.
+func Test_savePropertyTypes(t *testing.T) {
+	setPropertyTypesMode(t, "learned", nil)
+	propTypes.record(propertyKey{"Pod", "restarts"}, catalogType{dataType: "string"})
+	dao, mockPool := buildMockDAO(t)
+	rows := pgxpoolmock.NewRows([]string{"kind", "property", "type"}).AddRow("Pod", "restarts", "number").ToPgxRows()
+	var query string
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), []string{"Pod"}, []string{"restarts"}, []string{"string"},
+		[]bool{false}).DoAndReturn(func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+		query = sql
+		return rows, nil
+	})
+
+	dao.savePropertyTypes(context.Background())
+	dao.savePropertyTypes(context.Background()) // Nothing left to save.
+
+	// A learned type doesn't clear the configured flag saved by another replica.
+	assert.Contains(t, query, "configured=t.configured OR EXCLUDED.configured")
+	assert.Empty(t, propTypes.unsaved)
+	assert.Equal(t, "number", propTypes.catalog[propertyKey{"Pod", "restarts"}])
+}
+
+// Should load the types recorded by other replicas.
+func Test_LoadPropertyTypes(t *testing.T) {
+	setPropertyTypesMode(t, "learned", nil)
+	dao, mockPool := buildMockDAO(t)
+	rows := pgxpoolmock.NewRows([]string{"kind", "property", "type"}).AddRow("Pod", "restarts", "number").ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), "SELECT kind, property, type FROM search.property_types").Return(rows, nil)
+
+	dao.LoadPropertyTypes(context.Background())
+
+	resource := model.Resource{Kind: "Pod", UID: "c1/pod-1", Properties: map[string]interface{}{"restarts": "2"}}
+	assert.Empty(t, normalizeProperties(&resource))
+	assert.Equal(t, float64(2), resource.Properties["restarts"])
+}
@@ -0,0 +1,289 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// Per-cluster quotas on the resources, edges, and bytes of resource data. The defaults are QUOTA_RESOURCES,
+// QUOTA_EDGES, and QUOTA_DATA_BYTES, and can be overridden for a cluster with annotations on the ManagedCluster.
+//
+// A resync must fit in the quotas: the resources and edges after a quota is reached are rejected. A sync starts from
+// the usage of the cluster, read after each request with ClusterTotals, and adds the added resources and edges minus
+// the deleted. With a data bytes quota, the sync reads the stored size of the updated and deleted resources, so
+// deletes release their bytes and updates are checked with the change of size. Adds that fail in the database don't
+// use the quota. The usage is read again from the database after quotaRefreshSyncs syncs or quotaRefreshInterval, to
+// correct the drift of the approximate counts.
+//
+// The quotas are approximate. The usage is cached by each replica, so the requests of a cluster received by different
+// replicas, or processed concurrently by one replica, start from the same usage and can together exceed a quota
+// until the usage is read again. A re-add of an existing resource is also counted as a new resource.
+
+// ClusterQuota limits the data indexed for a cluster. Zero is unlimited. In overrides, a negative value uses the
+// default.
+type ClusterQuota struct {
+	Resources int
+	Edges     int
+	DataBytes int
+}
+
+// Usage of a cluster.
+type clusterUsage struct {
+	resources int
+	edges     int
+	dataBytes int
+	hasBytes  bool      // The data bytes were read or computed. ClusterTotals only reads the resources and edges.
+	readAt    time.Time // Time the usage was read or computed by a resync.
+	syncs     int       // Syncs since the usage was read.
+}
+
+// Number of syncs and time after which the usage of a cluster is read again from the database.
+var quotaRefreshSyncs = 100
+var quotaRefreshInterval = 10 * time.Minute
+
+var quotaOverrides = map[string]ClusterQuota{}
+var quotaUsage = map[string]clusterUsage{}
+var quotaLock = sync.RWMutex{}
+
+// SetClusterQuota sets the quota overrides for the cluster.
+func SetClusterQuota(clusterName string, quota ClusterQuota) {
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	quotaOverrides[clusterName] = quota
+}
+
+// DeleteClusterQuota deletes the quota overrides and usage of the cluster.
+func DeleteClusterQuota(clusterName string) {
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	delete(quotaOverrides, clusterName)
+	delete(quotaUsage, clusterName)
+}
+
+// Forget the usage of the cluster after its resources are deleted.
+func forgetClusterUsage(clusterName string) {
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	delete(quotaUsage, clusterName)
+}
+
+// Get the quota for the cluster, with the overrides applied to the defaults.
+func clusterQuota(clusterName string) ClusterQuota {
+	quota := ClusterQuota{
+		Resources: config.Cfg.QuotaResources,
+		Edges:     config.Cfg.QuotaEdges,
+		DataBytes: config.Cfg.QuotaDataBytes,
+	}
+	quotaLock.RLock()
+	override, ok := quotaOverrides[clusterName]
+	quotaLock.RUnlock()
+	if !ok {
+		return quota
+	}
+	if override.Resources >= 0 {
+		quota.Resources = override.Resources
+	}
+	if override.Edges >= 0 {
+		quota.Edges = override.Edges
+	}
+	if override.DataBytes >= 0 {
+		quota.DataBytes = override.DataBytes
+	}
+	return quota
+}
+
+// Record the resources and edges of the cluster read by ClusterTotals.
+func recordClusterTotals(clusterName string, resources, edges int) {
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	usage := quotaUsage[clusterName]
+	usage.resources, usage.edges = resources, edges
+	quotaUsage[clusterName] = usage
+}
+
+// Tracks the usage of a cluster while processing a request. A nil tracker doesn't enforce quotas.
+type quotaTracker struct {
+	clusterName string
+	quota       ClusterQuota
+	usage       clusterUsage
+	sizes       map[string]int // Stored data size of the updated and deleted resources, by UID.
+	added       map[string]int // Data size of the added resources, by UID.
+}
+
+// Start tracking the quotas of a request. A resync starts from zero because it replaces the data of the cluster.
+// Returns nil if the cluster doesn't have quotas, or if its usage can't be read.
+func (dao *DAO) newQuotaTracker(ctx context.Context, clusterName string, resync bool) *quotaTracker {
+	quota := clusterQuota(clusterName)
+	if quota.Resources == 0 && quota.Edges == 0 && quota.DataBytes == 0 {
+		return nil
+	}
+	tracker := &quotaTracker{clusterName: clusterName, quota: quota,
+		usage: clusterUsage{hasBytes: true, readAt: time.Now()}}
+	if resync {
+		return tracker
+	}
+	quotaLock.RLock()
+	usage, ok := quotaUsage[clusterName]
+	quotaLock.RUnlock()
+	if !ok || !usage.hasBytes || usage.syncs >= quotaRefreshSyncs || time.Since(usage.readAt) >= quotaRefreshInterval {
+		var err error
+		if usage, err = dao.readClusterUsage(ctx, clusterName); err != nil {
+			klog.Warningf("Error reading the usage of cluster %s. Quotas aren't enforced for this request. %s",
+				clusterName, err)
+			return nil
+		}
+	}
+	tracker.usage = usage
+	return tracker
+}
+
+// Read the usage of the cluster. Excludes the Cluster node and intercluster edges, like ClusterTotals.
+func (dao *DAO) readClusterUsage(ctx context.Context, clusterName string) (clusterUsage, error) {
+	defer metrics.QueryTimer("clusterUsage")()
+	usage := clusterUsage{hasBytes: true, readAt: time.Now()}
+	rows, err := dao.pool.Query(ctx, `SELECT
+		(SELECT count(*) FROM search.resources WHERE cluster=$1 AND uid!=$2),
+		(SELECT coalesce(sum(octet_length(data::text)), 0) FROM search.resources WHERE cluster=$1 AND uid!=$2),
+		(SELECT count(*) FROM search.edges WHERE cluster=$1 AND edgetype!='interCluster')`,
+		clusterName, "cluster__"+clusterName)
+	if err != nil {
+		return usage, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return usage, fmt.Errorf("no usage returned for cluster %s", clusterName)
+	}
+	if err = rows.Scan(&usage.resources, &usage.dataBytes, &usage.edges); err != nil {
+		return usage, err
+	}
+	return usage, rows.Err()
+}
+
+// Count a resource with the data size. Returns an error if the resource exceeds a quota.
+func (tracker *quotaTracker) addResource(uid string, dataSize int) error {
+	if tracker == nil {
+		return nil
+	}
+	if tracker.quota.Resources > 0 && tracker.usage.resources+1 > tracker.quota.Resources {
+		metrics.QuotaRejected.WithLabelValues("resources").Inc()
+		return fmt.Errorf("cluster %s exceeded its quota of %d resources", tracker.clusterName, tracker.quota.Resources)
+	}
+	if tracker.quota.DataBytes > 0 && tracker.usage.dataBytes+dataSize > tracker.quota.DataBytes {
+		metrics.QuotaRejected.WithLabelValues("dataBytes").Inc()
+		return fmt.Errorf("cluster %s exceeded its quota of %d bytes of resource data", tracker.clusterName,
+			tracker.quota.DataBytes)
+	}
+	tracker.usage.resources++
+	tracker.usage.dataBytes += dataSize
+	if tracker.added == nil {
+		tracker.added = map[string]int{}
+	}
+	tracker.added[uid] = dataSize
+	return nil
+}
+
+// Count an edge. Returns an error if the edge exceeds the quota.
+func (tracker *quotaTracker) addEdge() error {
+	if tracker == nil {
+		return nil
+	}
+	if tracker.quota.Edges > 0 && tracker.usage.edges+1 > tracker.quota.Edges {
+		metrics.QuotaRejected.WithLabelValues("edges").Inc()
+		return fmt.Errorf("cluster %s exceeded its quota of %d edges", tracker.clusterName, tracker.quota.Edges)
+	}
+	tracker.usage.edges++
+	return nil
+}
+
+// Read the stored data size of the resources updated or deleted by a sync. Only needed with a data bytes quota. If
+// the sizes can't be read, updates aren't checked and the usage is read again by the next request.
+func (tracker *quotaTracker) readSizes(ctx context.Context, dao *DAO, uids []string) {
+	if tracker == nil || tracker.quota.DataBytes == 0 || len(uids) == 0 {
+		return
+	}
+	queryTimer := metrics.QueryTimer("resourceSizes")
+	rows, err := dao.pool.Query(ctx, `SELECT uid, octet_length(data::text) FROM search.resources
+		WHERE cluster=$1 AND uid=ANY($2)`, tracker.clusterName, uids)
+	queryTimer()
+	if err != nil {
+		klog.Warningf("Error reading the resource sizes of cluster %s. %s", tracker.clusterName, err)
+		tracker.usage.hasBytes = false
+		return
+	}
+	defer rows.Close()
+	tracker.sizes = make(map[string]int, len(uids))
+	for rows.Next() {
+		var uid string
+		var size int
+		if err = rows.Scan(&uid, &size); err != nil {
+			klog.Warningf("Error reading the resource sizes of cluster %s. %s", tracker.clusterName, err)
+			tracker.sizes, tracker.usage.hasBytes = nil, false
+			return
+		}
+		tracker.sizes[uid] = size
+	}
+}
+
+// Count an updated resource with the new data size. Returns an error if the resource grows over the data bytes quota.
+func (tracker *quotaTracker) updateResource(uid string, dataSize int) error {
+	if tracker == nil || tracker.quota.DataBytes == 0 || tracker.sizes == nil {
+		return nil
+	}
+	oldSize := tracker.sizes[uid]
+	if dataSize > oldSize && tracker.usage.dataBytes-oldSize+dataSize > tracker.quota.DataBytes {
+		metrics.QuotaRejected.WithLabelValues("dataBytes").Inc()
+		return fmt.Errorf("cluster %s exceeded its quota of %d bytes of resource data", tracker.clusterName,
+			tracker.quota.DataBytes)
+	}
+	tracker.usage.dataBytes = max(tracker.usage.dataBytes-oldSize+dataSize, 0)
+	tracker.sizes[uid] = dataSize
+	return nil
+}
+
+// Release the quota of deleted resources and edges, with the stored data size of the resources.
+func (tracker *quotaTracker) delete(uids []string, edges int) {
+	if tracker == nil {
+		return
+	}
+	tracker.usage.resources = max(tracker.usage.resources-len(uids), 0)
+	tracker.usage.edges = max(tracker.usage.edges-edges, 0)
+	for _, uid := range uids {
+		tracker.usage.dataBytes = max(tracker.usage.dataBytes-tracker.sizes[uid], 0)
+		delete(tracker.sizes, uid)
+	}
+}
+
+// Release the quota of the added resources and edges that failed in the database. The edges are identified by their
+// source, so only their number is used.
+func (tracker *quotaTracker) releaseFailed(resourceUIDs, edgeSources []string) {
+	if tracker == nil {
+		return
+	}
+	for _, uid := range resourceUIDs {
+		if size, ok := tracker.added[uid]; ok {
+			tracker.usage.resources = max(tracker.usage.resources-1, 0)
+			tracker.usage.dataBytes = max(tracker.usage.dataBytes-size, 0)
+			delete(tracker.added, uid)
+		}
+	}
+	tracker.usage.edges = max(tracker.usage.edges-len(edgeSources), 0)
+}
+
+// Keep the usage for the next request of the cluster.
+func (tracker *quotaTracker) save() {
+	if tracker == nil {
+		return
+	}
+	quotaLock.Lock()
+	defer quotaLock.Unlock()
+	tracker.usage.syncs++
+	quotaUsage[tracker.clusterName] = tracker.usage
+}
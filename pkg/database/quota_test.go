@@ -0,0 +1,170 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stolostron/search-indexer/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Set the default quotas and clear the overrides and usage after the test.
+func setQuotas(t *testing.T, resources, edges, dataBytes int) {
+	saved := []int{config.Cfg.QuotaResources, config.Cfg.QuotaEdges, config.Cfg.QuotaDataBytes}
+	config.Cfg.QuotaResources, config.Cfg.QuotaEdges, config.Cfg.QuotaDataBytes = resources, edges, dataBytes
+	t.Cleanup(func() {
+		config.Cfg.QuotaResources, config.Cfg.QuotaEdges, config.Cfg.QuotaDataBytes = saved[0], saved[1], saved[2]
+		quotaOverrides = map[string]ClusterQuota{}
+		quotaUsage = map[string]clusterUsage{}
+	})
+}
+
+func Test_clusterQuota_overrides(t *testing.T) {
+	setQuotas(t, 100, 200, 0)
+	SetClusterQuota("cluster1", ClusterQuota{Resources: 0, Edges: -1, DataBytes: 1000})
+
+	assert.Equal(t, ClusterQuota{Resources: 0, Edges: 200, DataBytes: 1000}, clusterQuota("cluster1"))
+	assert.Equal(t, ClusterQuota{Resources: 100, Edges: 200, DataBytes: 0}, clusterQuota("cluster2"))
+
+	DeleteClusterQuota("cluster1")
+	assert.Equal(t, ClusterQuota{Resources: 100, Edges: 200, DataBytes: 0}, clusterQuota("cluster1"))
+}
+
+// Should read the usage of the cluster the first time, and then use the saved usage.
+func Test_newQuotaTracker(t *testing.T) {
+	setQuotas(t, 10, 0, 100)
+	dao, mockPool := buildMockDAO(t)
+	rows := pgxpoolmock.NewRows([]string{"resources", "bytes", "edges"}).AddRow(9, 60, 3).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster1", "cluster__cluster1").Return(rows, nil)
+
+	tracker := dao.newQuotaTracker(context.Background(), "cluster1", false)
+	assert.Nil(t, tracker.addResource("cluster1/uid2", 30))
+	assert.EqualError(t, tracker.addResource("cluster1/uid3", 1), "cluster cluster1 exceeded its quota of 10 resources")
+	tracker.delete([]string{"cluster1/uid1"}, 0)
+	assert.EqualError(t, tracker.addResource("cluster1/uid3", 20), "cluster cluster1 exceeded its quota of 100 bytes of resource data")
+	assert.Nil(t, tracker.addEdge())
+	tracker.save()
+
+	saved := dao.newQuotaTracker(context.Background(), "cluster1", false)
+	assert.Equal(t, []int{9, 4, 90, 1}, []int{saved.usage.resources, saved.usage.edges, saved.usage.dataBytes,
+		saved.usage.syncs})
+	resync := dao.newQuotaTracker(context.Background(), "cluster1", true)
+	assert.Equal(t, []int{0, 0, 0}, []int{resync.usage.resources, resync.usage.edges, resync.usage.dataBytes})
+	assert.True(t, resync.usage.hasBytes)
+}
+
+// Should read the usage again after quotaRefreshSyncs syncs, or after quotaRefreshInterval.
+func Test_newQuotaTracker_refresh(t *testing.T) {
+	setQuotas(t, 0, 0, 100)
+	dao, mockPool := buildMockDAO(t)
+	rows := pgxpoolmock.NewRows([]string{"resources", "bytes", "edges"}).AddRow(2, 40, 1).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster1", "cluster__cluster1").Return(rows, nil)
+	quotaUsage["cluster1"] = clusterUsage{resources: 5, dataBytes: 90, hasBytes: true, readAt: time.Now(),
+		syncs: quotaRefreshSyncs}
+
+	tracker := dao.newQuotaTracker(context.Background(), "cluster1", false)
+	assert.Equal(t, 40, tracker.usage.dataBytes)
+	assert.Equal(t, 0, tracker.usage.syncs)
+
+	rows = pgxpoolmock.NewRows([]string{"resources", "bytes", "edges"}).AddRow(2, 50, 1).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster1", "cluster__cluster1").Return(rows, nil)
+	quotaUsage["cluster1"] = clusterUsage{resources: 5, dataBytes: 90, hasBytes: true,
+		readAt: time.Now().Add(-quotaRefreshInterval)}
+
+	tracker = dao.newQuotaTracker(context.Background(), "cluster1", false)
+	assert.Equal(t, 50, tracker.usage.dataBytes)
+}
+
+// Should release the bytes of deleted resources, and check updates with the change of size.
+func Test_quotaTracker_updateAndDelete(t *testing.T) {
+	setQuotas(t, 0, 0, 100)
+	dao, mockPool := buildMockDAO(t)
+	rows := pgxpoolmock.NewRows([]string{"uid", "size"}).AddRow("cluster1/a", 30).AddRow("cluster1/b", 20).ToPgxRows()
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster1", []string{"cluster1/a", "cluster1/b"}).
+		Return(rows, nil)
+	quotaUsage["cluster1"] = clusterUsage{resources: 4, dataBytes: 90, hasBytes: true, readAt: time.Now()}
+
+	tracker := dao.newQuotaTracker(context.Background(), "cluster1", false)
+	tracker.readSizes(context.Background(), &dao, []string{"cluster1/a", "cluster1/b"})
+	assert.EqualError(t, tracker.updateResource("cluster1/a", 41),
+		"cluster cluster1 exceeded its quota of 100 bytes of resource data")
+	assert.Nil(t, tracker.updateResource("cluster1/a", 40))
+	assert.Equal(t, 100, tracker.usage.dataBytes)
+	tracker.delete([]string{"cluster1/b"}, 0)
+	assert.Equal(t, 80, tracker.usage.dataBytes)
+	assert.Equal(t, 3, tracker.usage.resources)
+	assert.Nil(t, tracker.updateResource("cluster1/a", 10))
+	assert.Equal(t, 50, tracker.usage.dataBytes)
+}
+
+// Should skip the check of updates if the sizes can't be read, and read the usage again for the next request.
+func Test_quotaTracker_readSizesError(t *testing.T) {
+	setQuotas(t, 0, 0, 100)
+	dao, mockPool := buildMockDAO(t)
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "cluster1", []string{"cluster1/a"}).
+		Return(nil, errors.New("connection refused"))
+	quotaUsage["cluster1"] = clusterUsage{dataBytes: 100, hasBytes: true, readAt: time.Now()}
+	defer testutils.SupressConsoleOutput()()
+
+	tracker := dao.newQuotaTracker(context.Background(), "cluster1", false)
+	tracker.readSizes(context.Background(), &dao, []string{"cluster1/a"})
+	assert.Nil(t, tracker.updateResource("cluster1/a", 50))
+	tracker.save()
+	assert.False(t, quotaUsage["cluster1"].hasBytes)
+}
+
+// The resources and edges that failed in the database don't use the quota.
+func Test_quotaTracker_releaseFailed(t *testing.T) {
+	setQuotas(t, 10, 10, 100)
+	tracker := &quotaTracker{clusterName: "cluster1", quota: clusterQuota("cluster1"),
+		usage: clusterUsage{hasBytes: true, readAt: time.Now()}}
+	assert.Nil(t, tracker.addResource("cluster1/uid1", 30))
+	assert.Nil(t, tracker.addResource("cluster1/uid2", 40))
+	assert.Nil(t, tracker.addEdge())
+	assert.Nil(t, tracker.addEdge())
+
+	tracker.releaseFailed([]string{"cluster1/uid2", "cluster1/unknown"}, []string{"cluster1/uid1"})
+
+	assert.Equal(t, []int{1, 1, 30}, []int{tracker.usage.resources, tracker.usage.edges, tracker.usage.dataBytes})
+	var unlimited *quotaTracker
+	unlimited.releaseFailed([]string{"cluster1/uid1"}, nil)
+}
+
+func Test_newQuotaTracker_unlimited(t *testing.T) {
+	setQuotas(t, 0, 0, 0)
+	dao, _ := buildMockDAO(t)
+
+	tracker := dao.newQuotaTracker(context.Background(), "cluster1", false)
+
+	assert.Nil(t, tracker)
+	assert.Nil(t, tracker.addResource("cluster1/uid1", 100))
+	assert.Nil(t, tracker.addEdge())
+}
+
+// Should reject the resources and edges over the quota of the cluster.
+func Test_SyncData_quota(t *testing.T) {
+	setQuotas(t, 0, 0, 0)
+	SetClusterQuota("local-cluster", ClusterQuota{Resources: 1, Edges: 0, DataBytes: -1})
+	quotaUsage["local-cluster"] = clusterUsage{resources: 1, hasBytes: true, readAt: time.Now()}
+	dao, mockPool := buildMockDAO(t)
+	dao.batchSize = 1
+	br := &testutils.MockBatchResults{}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(6)
+
+	response := &model.SyncResponse{}
+	err := dao.SyncData(context.Background(), loadSimpleSyncEvent(t), "local-cluster", response)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, response.TotalAdded)
+	assert.Len(t, response.AddErrors, 1)
+	assert.Equal(t, "cluster local-cluster exceeded its quota of 1 resources", response.AddErrors[0].Message)
+	assert.Equal(t, 1, quotaUsage["local-cluster"].resources)
+}
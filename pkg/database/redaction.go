@@ -0,0 +1,213 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Redaction of the resource properties, configured in the REDACTION_CONFIG_PATH file. Applied to the added and
+// updated resources before anything else reads the properties. Sample:
+//
+//	action: hash                       # drop or hash. Default: drop
+//	denyKeys:                          # Properties, and keys of object properties like label and annotation.
+//	- (?i)(token|password|secret)
+//	denyValues:                        # String values, including the elements of arrays and objects.
+//	- ^eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.
+//	allow:                             # Properties stored for the kind. Other kinds store all properties.
+//	  Secret: [type, created]
+//
+// Hashed values are replaced with sha256:<hex>, so equal values can still be matched. The properties that identify
+// a resource, and the internal properties starting with _, are always allowed.
+
+// Properties kept when the kind has an allow list.
+var requiredProperties = map[string]struct{}{
+	"kind": {}, "kind_plural": {}, "name": {}, "namespace": {}, "apigroup": {}, "apiversion": {},
+}
+
+// RedactionConfig is the content of the REDACTION_CONFIG_PATH file.
+type RedactionConfig struct {
+	Action     string              `json:"action,omitempty"` // drop or hash. Default: drop
+	DenyKeys   []string            `json:"denyKeys,omitempty"`
+	DenyValues []string            `json:"denyValues,omitempty"`
+	Allow      map[string][]string `json:"allow,omitempty"`
+}
+
+type redactionPolicy struct {
+	hash       bool
+	denyKeys   []*regexp.Regexp
+	denyValues []*regexp.Regexp
+	allow      map[string]map[string]struct{}
+}
+
+// Redaction policy in use. Nil when redaction is disabled.
+var redaction *redactionPolicy
+
+// LoadRedactionPolicy reads the REDACTION_CONFIG_PATH file. Returns an error if the file is invalid, so the indexer
+// doesn't store properties that should be redacted.
+func LoadRedactionPolicy() error {
+	if config.Cfg.RedactionConfigPath == "" {
+		return nil
+	}
+	policy, err := newRedactionPolicy(config.Cfg.RedactionConfigPath)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Loaded redaction policy with %d key rules, %d value rules, and allow lists for %d kinds.",
+		len(policy.denyKeys), len(policy.denyValues), len(policy.allow))
+	redaction = policy
+	return nil
+}
+
+func newRedactionPolicy(path string) (*redactionPolicy, error) {
+	redactionConfig := RedactionConfig{}
+	content, err := os.ReadFile(path) // #nosec G304 -- Path is set by the administrator.
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.UnmarshalStrict(content, &redactionConfig); err != nil {
+		return nil, fmt.Errorf("error parsing redaction policy %s: %w", path, err)
+	}
+	if redactionConfig.Action != "" && redactionConfig.Action != "drop" && redactionConfig.Action != "hash" {
+		return nil, fmt.Errorf("redaction action in %s must be drop or hash, got %s", path, redactionConfig.Action)
+	}
+
+	policy := &redactionPolicy{hash: redactionConfig.Action == "hash", allow: map[string]map[string]struct{}{}}
+	for _, pattern := range redactionConfig.DenyKeys {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denyKeys pattern %q in %s: %w", pattern, path, err)
+		}
+		policy.denyKeys = append(policy.denyKeys, re)
+	}
+	for _, pattern := range redactionConfig.DenyValues {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denyValues pattern %q in %s: %w", pattern, path, err)
+		}
+		policy.denyValues = append(policy.denyValues, re)
+	}
+	for kind, properties := range redactionConfig.Allow {
+		policy.allow[kind] = map[string]struct{}{}
+		for _, property := range properties {
+			policy.allow[kind][property] = struct{}{}
+		}
+	}
+	return policy, nil
+}
+
+// Apply the redaction policy to the properties of the resource.
+func redactProperties(resource *model.Resource) {
+	if redaction == nil {
+		return
+	}
+	redaction.redact(resource)
+}
+
+func (policy *redactionPolicy) redact(resource *model.Resource) {
+	kind := resource.Kind
+	if kind == "" {
+		kind, _ = resource.Properties["kind"].(string)
+	}
+	allowed, hasAllowList := policy.allow[kind]
+	for property, value := range resource.Properties {
+		if hasAllowList && !isAllowed(allowed, property) {
+			delete(resource.Properties, property)
+			metrics.RedactedProperties.WithLabelValues("drop", "allow").Inc()
+			continue
+		}
+		if matchesAny(policy.denyKeys, property) {
+			policy.apply(resource.Properties, property, "denyKeys")
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if matchesAny(policy.denyValues, v) {
+				policy.apply(resource.Properties, property, "denyValues")
+			}
+		case []interface{}:
+			resource.Properties[property] = policy.redactArray(v)
+		case map[string]interface{}:
+			for key, item := range v {
+				if matchesAny(policy.denyKeys, key) {
+					policy.apply(v, key, "denyKeys")
+				} else if s, ok := item.(string); ok && matchesAny(policy.denyValues, s) {
+					policy.apply(v, key, "denyValues")
+				}
+			}
+		}
+	}
+}
+
+// Redact the string elements of an array that match the denied values.
+func (policy *redactionPolicy) redactArray(values []interface{}) []interface{} {
+	redacted := make([]interface{}, 0, len(values))
+	for _, item := range values {
+		s, ok := item.(string)
+		if !ok || !matchesAny(policy.denyValues, s) {
+			redacted = append(redacted, item)
+			continue
+		}
+		if policy.hash {
+			redacted = append(redacted, hashValue(s))
+			metrics.RedactedProperties.WithLabelValues("hash", "denyValues").Inc()
+		} else {
+			metrics.RedactedProperties.WithLabelValues("drop", "denyValues").Inc()
+		}
+	}
+	return redacted
+}
+
+// Drop or hash the value of the key.
+func (policy *redactionPolicy) apply(values map[string]interface{}, key, rule string) {
+	if policy.hash {
+		values[key] = hashValue(values[key])
+		metrics.RedactedProperties.WithLabelValues("hash", rule).Inc()
+		return
+	}
+	delete(values, key)
+	metrics.RedactedProperties.WithLabelValues("drop", rule).Inc()
+}
+
+func isAllowed(allowed map[string]struct{}, property string) bool {
+	_, ok := allowed[property]
+	return ok || isRequiredProperty(property)
+}
+
+// The properties that identify a resource, and the internal properties starting with _.
+func isRequiredProperty(property string) bool {
+	_, required := requiredProperties[property]
+	return required || strings.HasPrefix(property, "_")
+}
+
+func matchesAny(patterns []*regexp.Regexp, value string) bool {
+	for _, re := range patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash of the value. Strings are hashed as is, other values as JSON.
+func hashValue(value interface{}) string {
+	s, ok := value.(string)
+	if !ok {
+		b, _ := json.Marshal(value)
+		s = string(b)
+	}
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,102 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const redactionConfig = `
+denyKeys:
+- (?i)token
+denyValues:
+- ^eyJ
+allow:
+  Secret: [type]
+`
+
+// Load the redaction policy from the content and restore the previous policy after the test.
+func setRedactionPolicy(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), "redaction.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	savedPath, savedPolicy := config.Cfg.RedactionConfigPath, redaction
+	config.Cfg.RedactionConfigPath = path
+	t.Cleanup(func() {
+		config.Cfg.RedactionConfigPath = savedPath
+		redaction = savedPolicy
+	})
+	if err := LoadRedactionPolicy(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_LoadRedactionPolicy_invalid(t *testing.T) {
+	tests := map[string]string{
+		"invalid action":  "action: mask\n",
+		"invalid pattern": "denyKeys:\n- (token\n",
+		"unknown field":   "deny:\n- token\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), "redaction.yaml")
+		_ = os.WriteFile(path, []byte(content), 0600)
+		_, err := newRedactionPolicy(path)
+		assert.NotNil(t, err, name)
+	}
+}
+
+// Should drop the denied keys and values, and the properties not allowed for the kind.
+func Test_redactProperties_drop(t *testing.T) {
+	setRedactionPolicy(t, redactionConfig)
+	dropped := testutil.ToFloat64(metrics.RedactedProperties.WithLabelValues("drop", "denyKeys"))
+	pod := model.Resource{Kind: "Pod", Properties: map[string]interface{}{
+		"name":       "pod-1",
+		"tokenHash":  "abc",
+		"annotation": map[string]interface{}{"example.com/token": "abc", "owner": "eyJhbGciOi", "team": "a"},
+		"container":  []interface{}{"nginx", "eyJhbGciOi"},
+	}}
+	secret := model.Resource{Kind: "Secret", Properties: map[string]interface{}{
+		"kind": "Secret", "name": "s", "namespace": "default", "type": "Opaque", "label": map[string]interface{}{},
+		"_uid": "uid"}}
+
+	redactProperties(&pod)
+	redactProperties(&secret)
+
+	assert.Equal(t, map[string]interface{}{
+		"name":       "pod-1",
+		"annotation": map[string]interface{}{"team": "a"},
+		"container":  []interface{}{"nginx"},
+	}, pod.Properties)
+	assert.Equal(t, map[string]interface{}{
+		"kind": "Secret", "name": "s", "namespace": "default", "type": "Opaque", "_uid": "uid"}, secret.Properties)
+	assert.Equal(t, dropped+2, testutil.ToFloat64(metrics.RedactedProperties.WithLabelValues("drop", "denyKeys")))
+}
+
+// Should replace the denied keys and values with their hash.
+func Test_redactProperties_hash(t *testing.T) {
+	setRedactionPolicy(t, "action: hash\n"+redactionConfig)
+	pod := model.Resource{Kind: "Pod", Properties: map[string]interface{}{
+		"token": "abc", "label": map[string]interface{}{"owner": "eyJhbGciOi"}}}
+
+	redactProperties(&pod)
+
+	assert.Equal(t, "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", pod.Properties["token"])
+	assert.Equal(t, hashValue("eyJhbGciOi"), pod.Properties["label"].(map[string]interface{})["owner"])
+}
+
+func Test_redactProperties_disabled(t *testing.T) {
+	pod := model.Resource{Kind: "Pod", Properties: map[string]interface{}{"token": "abc"}}
+
+	redactProperties(&pod)
+
+	assert.Equal(t, "abc", pod.Properties["token"])
+}
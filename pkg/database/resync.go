@@ -13,171 +13,208 @@ import (
 
 	"github.com/doug-martin/goqu/v9"
 	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/logging"
 	"github.com/stolostron/search-indexer/pkg/metrics"
 	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stolostron/search-indexer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/klog/v2"
 )
 
+// Delete the resources and edges of the cluster that weren't written by the resync generation.
+// Excludes the Cluster pseudo node and intercluster edges. The rows whose upsert failed in the database keep their
+// old generation, so they're excluded too: the resources by UID, and the edges by source UID.
+const sweepResourcesQuery = "DELETE FROM search.resources WHERE cluster=$1 AND generation<$2 AND uid!=$3 AND uid!=ALL($4)"
+const sweepEdgesQuery = "DELETE FROM search.edges WHERE cluster=$1 AND generation<$2 AND edgetype!='interCluster' AND sourceid!=ALL($3)"
+
+// The current resync generation of the cluster in the query parameter. SyncData writes its rows with it, so a resync
+// of the same cluster that is running doesn't sweep the rows added or updated by a sync during the resync.
+func currentGeneration(clusterParam int) string {
+	return fmt.Sprintf("COALESCE((SELECT generation FROM search.generations WHERE cluster=$%d), 0)", clusterParam)
+}
+
 // Reset data for the cluster to the incoming state.
+// Uses mark and sweep: the resync bumps the generation of the cluster, upserts all incoming resources and edges with
+// the new generation, and then deletes the rows with an older generation. The sweep only runs after all the upserts
+// complete, so an interrupted resync leaves the old data until the next resync completes.
 func (dao *DAO) ResyncData(ctx context.Context, clusterName string, syncResponse *model.SyncResponse, requestBody []byte) error {
 
-	defer metrics.SlowLog(fmt.Sprintf("Slow resync from %12s.", clusterName), 0)()
-	klog.Infof(
-		"Starting resync from %12s. This is normal, but it could be a problem if it happens often.", clusterName)
+	ctx, span := tracing.StartSpan(ctx, "ResyncData", attribute.String("cluster", clusterName))
+	defer span.End()
+	logger := logging.Operation(ctx, "resyncData")
+	defer metrics.SlowLog(ctx, "Slow resync", 0)()
+	logger.Info("Starting resync. This is normal, but it could be a problem if it happens often.")
 
-	// Reset resources
-	lastUpsertResource, err := dao.resetResources(ctx, clusterName, syncResponse, requestBody)
+	dao.ensureClusterPartitions(ctx, clusterName)
+	generation, err := dao.nextGeneration(ctx, clusterName)
 	if err != nil {
-		klog.Warningf("Error resyncing resources for cluster %12s. Error: %+v", clusterName, err)
+		logger.Error(err, "Error getting the resync generation")
+		tracing.RecordError(span, err)
 		return err
 	}
+	span.SetAttributes(attribute.Int64("generation", generation))
+
+	var lastUpsertResource model.Resource
+	state := &resyncState{catalog: propertyCatalog{}, quota: dao.newQuotaTracker(ctx, clusterName, true),
+		edges: dao.newEdgeValidator(clusterName, true), interCluster: newInterClusterChanges()}
+	if config.Cfg.ResyncMode == "atomic" {
+		// Stage the incoming state and swap it in a single transaction.
+		lastUpsertResource, err = dao.resyncAtomic(ctx, clusterName, generation, syncResponse, requestBody, state)
+		if err != nil {
+			logger.Error(err, "Error resyncing with atomic swap")
+			tracing.RecordError(span, err)
+			return err
+		}
+	} else {
+		// Reset resources
+		lastUpsertResource, err = dao.resetResources(ctx, clusterName, generation, syncResponse, requestBody, state)
+		if err != nil {
+			logger.Error(err, "Error resyncing resources")
+			tracing.RecordError(span, err)
+			return err
+		}
 
-	// Reset edges
-	err = dao.resetEdges(ctx, clusterName, syncResponse, requestBody)
-	if err != nil {
-		klog.Warningf("Error resyncing edges for cluster %12s. Error: %+v", clusterName, err)
-		return err
+		// Reset edges
+		err = dao.resetEdges(ctx, clusterName, generation, syncResponse, requestBody, state)
+		if err != nil {
+			logger.Error(err, "Error resyncing edges")
+			tracing.RecordError(span, err)
+			return err
+		}
 	}
 
+	dao.savePropertyTypes(ctx)
+	dao.savePropertyCatalog(ctx, clusterName, state.catalog, true)
+	state.quota.save()
+	state.interCluster.queue(clusterName, true)
+
 	if _, ok := lastUpsertResource.Properties["_hubClusterResource"]; ok {
 		go dao.hubClusterCleanUpWithRetry(context.Background(), clusterName) // #nosec G118 -- Background cleanup goroutine intentionally uses independent context
 	}
 
-	klog.V(1).Infof("Completed resync of cluster %12s.", clusterName)
+	logger.V(1).Info("Completed resync", "generation", generation)
 	return nil
 }
 
+// State of a resync shared by the functions writing its resources and edges.
+type resyncState struct {
+	catalog      propertyCatalog
+	quota        *quotaTracker
+	edges        *edgeValidator
+	interCluster *interClusterChanges
+}
+
+// Increment and return the resync generation of the cluster.
+func (dao *DAO) nextGeneration(ctx context.Context, clusterName string) (int64, error) {
+	defer metrics.QueryTimer("nextGeneration")()
+	rows, err := dao.pool.Query(ctx, `INSERT INTO search.generations AS g (cluster, generation) VALUES ($1, 1)
+		ON CONFLICT (cluster) DO UPDATE SET generation=g.generation+1 RETURNING generation`, clusterName)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var generation int64
+	if !rows.Next() {
+		return 0, fmt.Errorf("no generation returned for cluster %s", clusterName)
+	}
+	if err = rows.Scan(&generation); err != nil {
+		return 0, err
+	}
+	return generation, rows.Err()
+}
+
 // Reset Resources.
-// 1. Upsert each incoming resource. Keep the UID.
-// 2. Delete existing UIDs that don't match the incoming UIDs.
-func (dao *DAO) resetResources(ctx context.Context, clusterName string,
-	syncResponse *model.SyncResponse, resyncBody []byte) (model.Resource, error) {
+// 1. Upsert each incoming resource with the new generation.
+// 2. Delete the resources of the cluster with an older generation. Excludes the Cluster pseudo node and the resources
+// that failed to upsert.
+func (dao *DAO) resetResources(ctx context.Context, clusterName string, generation int64,
+	syncResponse *model.SyncResponse, resyncBody []byte, state *resyncState) (model.Resource, error) {
+	ctx, span := tracing.StartSpan(ctx, "resetResources")
+	defer span.End()
 
 	batch := NewBatchWithRetry(ctx, dao, syncResponse)
 
 	// UPSERT resources in the database.
-	incomingUIDs, resource, upsertErr := dao.upsertResources(ctx, resyncBody, clusterName, syncResponse, &batch)
-
-	// Add the uid of the Cluster pseudo node that is created by the indexer to exclude from deletion
-	incomingUIDs = append(incomingUIDs, fmt.Sprintf("cluster__%s", clusterName))
-
-	// DELETE resources that no longer exist.
-	query, params, err := useGoqu(
-		"DELETE from search.resources WHERE cluster=$1 AND uid NOT IN ($2)",
-		[]interface{}{clusterName, incomingUIDs})
-	if err == nil {
-		queueErr := batch.Queue(batchItem{
-			action: "deleteResource",
-			query:  query,
-			uid:    fmt.Sprintf("%s", incomingUIDs),
-			args:   params,
-		})
-		if queueErr != nil {
-			klog.Warningf("Error queuing resources for deletion. Error: %+v", queueErr)
-		}
-	}
-
-	// DELETE edges pointing to resources that no longer exist.
-	query, _, err = useGoqu(
-		"DELETE from search.edges WHERE cluster=$1 AND sourceid NOT IN ($2) OR destid NOT IN ($2)",
-		[]interface{}{clusterName, incomingUIDs})
-	if err == nil {
-		queueErr := batch.Queue(batchItem{
-			action: "deleteEdge",
-			query:  query,
-			uid:    fmt.Sprintf("%s", incomingUIDs),
-			args:   params,
-		})
-		if queueErr != nil {
-			klog.Warningf("Error queuing edges for deletion. Error: %+v", queueErr)
-		}
-	}
+	resource, upsertErr := dao.upsertResources(ctx, resyncBody, clusterName, generation, false, syncResponse,
+		&batch, state)
 	batch.flush()
 	batch.wg.Wait()
-
-	// we check and return upsertErr after deleting resources/edges to prevent continuous DB growth in case of err
+	if upsertErr == nil {
+		upsertErr = batch.connError
+	}
+	// Don't delete anything if the upsert didn't complete, so we keep the old state.
 	if upsertErr != nil {
 		return resource, upsertErr
 	}
 
-	return resource, batch.connError
+	// DELETE resources that weren't in the resync.
+	_, deleteSpan := tracing.StartSpan(ctx, "deleteResources")
+	defer deleteSpan.End()
+	queryTimer := metrics.QueryTimer("sweepResources")
+	result, err := dao.execWithExplain(ctx, "sweepResources", sweepResourcesQuery, clusterName, generation,
+		fmt.Sprintf("cluster__%s", clusterName), batch.failedUIDs("addResource"))
+	queryTimer()
+	if err != nil {
+		tracing.RecordError(deleteSpan, err)
+		return resource, err
+	}
+	syncResponse.TotalDeleted = int(result.RowsAffected())
+	deleteSpan.SetAttributes(attribute.Int("resources.deleted", syncResponse.TotalDeleted))
+
+	return resource, nil
 }
 
 // Reset Edges
-//  1. Get existing edges for the cluster. Excluding intercluster edges.
-//  2. For each incoming edge, INSERT if it doesn't exist.
-//  3. Delete any existing edges that aren't in the incoming resyncRequest.
-func (dao *DAO) resetEdges(ctx context.Context, clusterName string,
-	syncResponse *model.SyncResponse, resyncRequest []byte) error {
+//  1. Upsert each incoming edge with the new generation.
+//  2. Delete the edges of the cluster with an older generation. Excluding intercluster edges and the edges from the
+//     sources of the edges that failed to upsert.
+func (dao *DAO) resetEdges(ctx context.Context, clusterName string, generation int64,
+	syncResponse *model.SyncResponse, resyncRequest []byte, state *resyncState) error {
 	timer := time.Now()
+	ctx, span := tracing.StartSpan(ctx, "resetEdges")
+	defer span.End()
 
 	batch := NewBatchWithRetry(ctx, dao, syncResponse)
 
-	var queueErr error
-	existingEdgesMap := make(map[string]model.Edge)
-
-	// Get all existing edges for the cluster.
-	query, params, err := useGoqu(
-		"SELECT sourceid, edgetype, destid FROM search.edges WHERE edgetype!='interCluster' AND cluster=$1",
-		[]interface{}{clusterName})
-	if err == nil {
-		edgeRow, err := dao.pool.Query(ctx, query, params...)
-		if err != nil {
-			klog.Warningf("Error getting existing edges during resync of cluster %12s. Error: %+v", clusterName, err)
-		}
-
-		for edgeRow.Next() {
-			edge := model.Edge{}
-			err := edgeRow.Scan(&edge.SourceUID, &edge.EdgeType, &edge.DestUID)
-			if err != nil {
-				klog.Warningf("Error scanning edge row. Error: %+v", err)
-				continue
-			}
-			existingEdgesMap[edge.SourceUID+edge.EdgeType+edge.DestUID] = edge
-		}
-		edgeRow.Close()
-	}
-	metrics.LogStepDuration(&timer, clusterName, "Resync QUERY existing edges")
-
-	// Now insert edges from the reqeust that don't already exist
-	addErr := addEdges(resyncRequest, &existingEdgesMap, clusterName, syncResponse, &batch)
-
-	// Delete existing edges that are not in the resyncRequest.
-	// AND cluster=$4 scopes the delete to this cluster's rows only.
-	for _, edge := range existingEdgesMap {
-		query, params, err := useGoqu(
-			"DELETE from search.edges WHERE sourceid=$1 AND destid=$2 AND edgetype=$3 AND cluster=$4",
-			[]interface{}{edge.SourceUID, edge.DestUID, edge.EdgeType, clusterName})
-		if err == nil {
-			queueErr = batch.Queue(batchItem{
-				action: "deleteEdge",
-				query:  query,
-				uid:    edge.SourceUID,
-				args:   params,
-			})
-			if queueErr != nil {
-				klog.Warningf("Error queuing edges. Error: %+v", queueErr)
-				return queueErr
-			}
-			syncResponse.TotalEdgesDeleted++
-		}
-	}
-
+	addErr := addEdges(resyncRequest, clusterName, generation, false, syncResponse, &batch, state)
 	batch.flush()
 	batch.wg.Wait()
-	metrics.LogStepDuration(&timer, clusterName, fmt.Sprintf("Reset edges stats: INSERT [%d] DELETE [%d]",
-		syncResponse.TotalEdgesAdded, syncResponse.TotalEdgesDeleted))
-
+	if addErr == nil {
+		addErr = batch.connError
+	}
+	// Don't delete anything if the upsert didn't complete, so we keep the old state.
 	if addErr != nil {
 		return addErr
 	}
+	metrics.LogStepDuration(ctx, &timer, "Resync upsert edges")
 
-	return batch.connError
+	queryTimer := metrics.QueryTimer("sweepEdges")
+	result, err := dao.execWithExplain(ctx, "sweepEdges", sweepEdgesQuery, clusterName, generation,
+		batch.failedUIDs("addEdge"))
+	queryTimer()
+	if err != nil {
+		return err
+	}
+	syncResponse.TotalEdgesDeleted = int(result.RowsAffected())
+
+	span.SetAttributes(attribute.Int("edges.added", syncResponse.TotalEdgesAdded),
+		attribute.Int("edges.deleted", syncResponse.TotalEdgesDeleted))
+	metrics.LogStepDuration(ctx, &timer, "Reset edges",
+		"edgesAdded", syncResponse.TotalEdgesAdded, "edgesDeleted", syncResponse.TotalEdgesDeleted)
+
+	return nil
 }
 
-func (dao *DAO) upsertResources(ctx context.Context, resyncBody []byte, clusterName string, syncResponse *model.SyncResponse, batch *batchWithRetry) ([]interface{}, model.Resource, error) {
+// Upsert the incoming resources with the resync generation. When staging, the resources are written to the staging
+// table for the atomic swap. The properties of the resources are added to the catalog, and resources over the
+// quota of the cluster are rejected.
+func (dao *DAO) upsertResources(ctx context.Context, resyncBody []byte, clusterName string, generation int64,
+	staging bool, syncResponse *model.SyncResponse, batch *batchWithRetry, state *resyncState) (model.Resource, error) {
+	upsertQuery := "INSERT into search.resources values($1,$2,$3,$4) ON CONFLICT (uid) DO UPDATE SET data=CASE WHEN r.data IS DISTINCT FROM $3 THEN $3 ELSE r.data END, generation=$4 WHERE r.cluster=$2"
+	if staging {
+		upsertQuery = "INSERT into search.resources_staging values($1,$2,$3,$4) ON CONFLICT (cluster, generation, uid) DO UPDATE SET data=$3"
+	}
 	dec := json.NewDecoder(bytes.NewReader(resyncBody))
-	incomingUIDs := make([]interface{}, 0)
 	var resource model.Resource
 	for {
 		// read tokens until we get to addResources
@@ -188,24 +225,36 @@ func (dao *DAO) upsertResources(ctx context.Context, resyncBody []byte, clusterN
 		if field == "addResources" {
 			// read opening [
 			if _, err = dec.Token(); err != nil {
-				return incomingUIDs, resource, fmt.Errorf("error reading addResources opening token: %v", err)
+				return resource, fmt.Errorf("error reading addResources opening token: %v", err)
 			}
 			for dec.More() {
 				resource = model.Resource{}
 				if err = dec.Decode(&resource); err != nil {
-					return incomingUIDs, resource, fmt.Errorf("error decoding resource from request: %v", err)
+					return resource, fmt.Errorf("error decoding resource from request: %v", err)
 				}
 				uid := resource.UID
 				// Reject UIDs that don't belong to this cluster before they reach the DB.
 				if err := validateUIDPrefix(uid, clusterName); err != nil {
-					klog.Warningf("Rejecting resync resource from cluster [%s]: %v", clusterName, err)
+					logging.Warning(ctx, "Rejecting resync resource", "operation", "upsertResources", "uid", uid, "err", err)
 					syncResponse.AddErrors = append(syncResponse.AddErrors, model.SyncError{ResourceUID: uid, Message: err.Error()})
 					continue
 				}
-				data, _ := json.Marshal(resource.Properties)
-				query, params, err := useGoqu(
-					"INSERT into search.resources values($1,$2,$3) ON CONFLICT (uid) DO UPDATE SET data=$3 WHERE r.cluster=$2 AND data!=$3",
-					[]interface{}{uid, clusterName, string(data)})
+				redactProperties(&resource)
+				syncResponse.PropertyErrors = append(syncResponse.PropertyErrors, normalizeProperties(&resource)...)
+				data, propertyErrors, err := marshalProperties(&resource)
+				syncResponse.PropertyErrors = append(syncResponse.PropertyErrors, propertyErrors...)
+				if err != nil {
+					syncResponse.AddErrors = append(syncResponse.AddErrors, model.SyncError{ResourceUID: uid, Message: err.Error()})
+					continue
+				}
+				if err := state.quota.addResource(uid, len(data)); err != nil {
+					syncResponse.AddErrors = append(syncResponse.AddErrors, model.SyncError{ResourceUID: uid, Message: err.Error()})
+					continue
+				}
+				state.catalog.add(resource)
+				state.edges.addResource(uid)
+				state.interCluster.add(resource)
+				query, params, err := useGoqu(upsertQuery, []interface{}{uid, clusterName, string(data), generation})
 				if err == nil {
 					queueErr := batch.Queue(batchItem{
 						action: "addResource",
@@ -214,17 +263,16 @@ func (dao *DAO) upsertResources(ctx context.Context, resyncBody []byte, clusterN
 						args:   params,
 					})
 					if queueErr != nil {
-						klog.Warningf("Error queuing resources to add. Error: %+v", queueErr)
-						return incomingUIDs, resource, queueErr
+						logging.Operation(ctx, "upsertResources").Error(queueErr, "Error queuing resources to add")
+						return resource, queueErr
 					}
 					syncResponse.TotalAdded++
 				}
-				incomingUIDs = append(incomingUIDs, uid)
 			}
-			return incomingUIDs, resource, err
+			return resource, err
 		}
 	}
-	return incomingUIDs, resource, nil
+	return resource, nil
 }
 
 // hubClusterCleanUpWithRetry takes the known hub cluster name from the latest resync request and deletes all other
@@ -263,7 +311,9 @@ func (dao *DAO) checkHubClusterRename(ctx context.Context, requestCluster string
 		klog.Errorf("Error creating query to check existing hub cluster name")
 		return err
 	}
+	queryTimer := metrics.QueryTimer("getHubClusterNames")
 	rows, err := dao.pool.Query(ctx, sql, args...)
+	queryTimer()
 	if err != nil {
 		klog.Errorf("Error while fetching hub cluster name from database: %s", err.Error())
 		return err
@@ -305,7 +355,9 @@ func (dao *DAO) deleteOldHubClusterFromDBTable(ctx context.Context, oldHubCluste
 		klog.Errorf("Error creating query to delete old hub cluster %s: %s", table, err.Error())
 		return err
 	}
+	queryTimer := metrics.QueryTimer("deleteOldHubCluster")
 	res, err := dao.pool.Exec(ctx, sql, args...)
+	queryTimer()
 	if err != nil {
 		klog.Errorf("Error deleting old hub cluster %s: %s", table, err.Error())
 		return err
@@ -315,7 +367,14 @@ func (dao *DAO) deleteOldHubClusterFromDBTable(ctx context.Context, oldHubCluste
 	return nil
 }
 
-func addEdges(requestBody []byte, existingEdgesMap *map[string]model.Edge, clusterName string, syncResponse *model.SyncResponse, batch *batchWithRetry) error {
+// Upsert the incoming edges with the resync generation. When staging, the edges are written to the staging table for
+// the atomic swap. Edges over the quota of the cluster, or to missing resources with strict validation, are rejected.
+func addEdges(requestBody []byte, clusterName string, generation int64, staging bool, syncResponse *model.SyncResponse,
+	batch *batchWithRetry, state *resyncState) error {
+	upsertQuery := "INSERT into search.edges values($1,$2,$3,$4,$5,$6,$7) ON CONFLICT (sourceid, destid, edgetype) DO UPDATE SET generation=$7 WHERE cluster=$6"
+	if staging {
+		upsertQuery = "INSERT into search.edges_staging values($1,$2,$3,$4,$5,$6,$7) ON CONFLICT DO NOTHING"
+	}
 	dec := json.NewDecoder(bytes.NewReader(requestBody))
 
 	for {
@@ -334,15 +393,20 @@ func addEdges(requestBody []byte, existingEdgesMap *map[string]model.Edge, clust
 				if err = dec.Decode(&edge); err != nil {
 					return fmt.Errorf("error decoding edge from request: %v", err)
 				}
-				// If the edge already exists, do nothing.
-				if _, ok := (*existingEdgesMap)[edge.SourceUID+edge.EdgeType+edge.DestUID]; ok {
-					delete(*existingEdgesMap, edge.SourceUID+edge.EdgeType+edge.DestUID)
+				if err := state.edges.validate(batch.ctx, edge); err != nil {
+					syncResponse.AddEdgeErrors = append(syncResponse.AddEdgeErrors,
+						model.SyncError{ResourceUID: edge.SourceUID, Message: err.Error()})
+					continue
+				}
+				if err := state.quota.addEdge(); err != nil {
+					syncResponse.AddEdgeErrors = append(syncResponse.AddEdgeErrors,
+						model.SyncError{ResourceUID: edge.SourceUID, Message: err.Error()})
 					continue
 				}
-				// If the edge doesn't exist, add it.
-				query, params, err := useGoqu(
-					"INSERT into search.edges values($1,$2,$3,$4,$5,$6) ON CONFLICT (sourceid, destid, edgetype) DO NOTHING",
-					[]interface{}{edge.SourceUID, edge.SourceKind, edge.DestUID, edge.DestKind, edge.EdgeType, clusterName})
+				// Insert the edge, or mark the existing edge with the new generation.
+				query, params, err := useGoqu(upsertQuery,
+					[]interface{}{edge.SourceUID, edge.SourceKind, edge.DestUID, edge.DestKind, edge.EdgeType, clusterName,
+						generation})
 				if err == nil {
 					queueErr := batch.Queue(batchItem{
 						action: "addEdge",
@@ -351,7 +415,7 @@ func addEdges(requestBody []byte, existingEdgesMap *map[string]model.Edge, clust
 						args:   params,
 					})
 					if queueErr != nil {
-						klog.Warningf("Error queuing edges. Error: %+v", queueErr)
+						logging.Operation(batch.ctx, "addEdges").Error(queueErr, "Error queuing edges")
 						return queueErr
 					}
 					syncResponse.TotalEdgesAdded++
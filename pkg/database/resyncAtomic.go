@@ -0,0 +1,130 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/stolostron/search-indexer/pkg/logging"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stolostron/search-indexer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Atomic resync (RESYNC_MODE=atomic). The incoming state is written to the staging tables with the batches used by
+// the in-place resync, and then swapped into search.resources and search.edges in a single transaction. Readers see
+// either the old or the new state of the cluster, never a partially applied resync.
+
+// Move the staged rows of the resync generation into the live tables.
+func swapResourcesQuery() string {
+	return fmt.Sprintf(`INSERT INTO search.resources (uid, cluster, data, generation)
+	SELECT uid, cluster, data, generation FROM search.resources_staging WHERE cluster=$1 AND generation=$2
+	ON CONFLICT (%s) DO UPDATE SET data=CASE WHEN search.resources.data IS DISTINCT FROM EXCLUDED.data
+	THEN EXCLUDED.data ELSE search.resources.data END, generation=EXCLUDED.generation
+	WHERE search.resources.cluster=EXCLUDED.cluster`, resourcesConflictTarget())
+}
+
+func swapEdgesQuery() string {
+	return fmt.Sprintf(`INSERT INTO search.edges (sourceid, sourcekind, destid, destkind, edgetype, cluster, generation)
+	SELECT sourceid, sourcekind, destid, destkind, edgetype, cluster, generation FROM search.edges_staging
+	WHERE cluster=$1 AND generation=$2
+	ON CONFLICT (%s) DO UPDATE SET generation=EXCLUDED.generation
+	WHERE search.edges.cluster=EXCLUDED.cluster`, edgesConflictTarget())
+}
+
+// Delete the staged rows of the resync generation, and any left by an older resync that didn't complete.
+const clearResourcesStagingQuery = "DELETE FROM search.resources_staging WHERE cluster=$1 AND generation<=$2"
+const clearEdgesStagingQuery = "DELETE FROM search.edges_staging WHERE cluster=$1 AND generation<=$2"
+
+// Stage the incoming resources and edges, then swap them in. If staging fails, the live data isn't changed.
+func (dao *DAO) resyncAtomic(ctx context.Context, clusterName string, generation int64,
+	syncResponse *model.SyncResponse, resyncBody []byte, state *resyncState) (model.Resource, error) {
+	ctx, span := tracing.StartSpan(ctx, "resyncAtomic")
+	defer span.End()
+
+	batch := NewBatchWithRetry(ctx, dao, syncResponse)
+	resource, err := dao.upsertResources(ctx, resyncBody, clusterName, generation, true, syncResponse, &batch, state)
+	if err == nil {
+		err = addEdges(resyncBody, clusterName, generation, true, syncResponse, &batch, state)
+	}
+	batch.flush()
+	batch.wg.Wait()
+	if err == nil {
+		err = batch.connError
+	}
+	if err != nil {
+		tracing.RecordError(span, err)
+		dao.clearStaging(ctx, clusterName, generation)
+		return resource, err
+	}
+
+	if err = dao.swapStaging(ctx, clusterName, generation, syncResponse, batch.failedUIDs("addResource"),
+		batch.failedUIDs("addEdge")); err != nil {
+		tracing.RecordError(span, err)
+		dao.clearStaging(ctx, clusterName, generation)
+		return resource, err
+	}
+	return resource, nil
+}
+
+// Replace the cluster state with the staged rows in a single transaction. The resources and edges that failed to
+// stage are kept with their old state.
+func (dao *DAO) swapStaging(ctx context.Context, clusterName string, generation int64,
+	syncResponse *model.SyncResponse, failedResources, failedEdges []string) error {
+	ctx, span := tracing.StartSpan(ctx, "swapStaging")
+	defer span.End()
+	defer metrics.QueryTimer("swapStaging")()
+
+	tx, err := dao.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	steps := []struct {
+		description string
+		query       string
+		args        []interface{}
+		rows        *int
+	}{
+		{"upserting staged resources", swapResourcesQuery(), []interface{}{clusterName, generation}, nil},
+		{"deleting stale resources", sweepResourcesQuery,
+			[]interface{}{clusterName, generation, fmt.Sprintf("cluster__%s", clusterName), failedResources},
+			&syncResponse.TotalDeleted},
+		{"upserting staged edges", swapEdgesQuery(), []interface{}{clusterName, generation}, nil},
+		{"deleting stale edges", sweepEdgesQuery, []interface{}{clusterName, generation, failedEdges},
+			&syncResponse.TotalEdgesDeleted},
+		{"clearing staged resources", clearResourcesStagingQuery, []interface{}{clusterName, generation}, nil},
+		{"clearing staged edges", clearEdgesStagingQuery, []interface{}{clusterName, generation}, nil},
+	}
+	for _, step := range steps {
+		result, err := tx.Exec(ctx, step.query, step.args...)
+		if err != nil {
+			checkErrorAndRollback(err, fmt.Sprintf("Error %s for cluster %s.", step.description, clusterName), tx, ctx)
+			return err
+		}
+		if step.rows != nil {
+			*step.rows = int(result.RowsAffected())
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		checkErrorAndRollback(err, fmt.Sprintf("Error committing resync swap for cluster %s.", clusterName), tx, ctx)
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("resources.deleted", syncResponse.TotalDeleted),
+		attribute.Int("edges.deleted", syncResponse.TotalEdgesDeleted))
+	logging.Operation(ctx, "swapStaging").V(2).Info("Swapped staged resync",
+		"resourcesDeleted", syncResponse.TotalDeleted, "edgesDeleted", syncResponse.TotalEdgesDeleted)
+	return nil
+}
+
+// Best effort delete of the staged rows after a failed resync. Leftover rows are deleted by the next swap.
+func (dao *DAO) clearStaging(ctx context.Context, clusterName string, generation int64) {
+	for _, query := range []string{clearResourcesStagingQuery, clearEdgesStagingQuery} {
+		if _, err := dao.pool.Exec(ctx, query, clusterName, generation); err != nil {
+			logging.Operation(ctx, "clearStaging").Error(err, "Error clearing staged resync")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stolostron/search-indexer/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Should stage the resync and swap it in a single transaction.
+func Test_ResyncData_atomic(t *testing.T) {
+	savedMode := config.Cfg.ResyncMode
+	config.Cfg.ResyncMode = "atomic"
+	defer func() { config.Cfg.ResyncMode = savedMode }()
+
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func(mockConn pgxmock.PgxConnIface, ctx context.Context) {
+		_ = mockConn.Close(ctx)
+	}(mockConn, context.Background())
+	dao, mockPool := buildMockDAO(t)
+	testutils.MockDatabaseState(mockPool)
+	testutils.MockResyncGeneration(mockPool, 4)
+
+	br := &testutils.MockBatchResults{}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(1)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockConn.ExpectExec(regexp.QuoteMeta(swapResourcesQuery())).WithArgs("local-cluster", int64(4)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+	mockConn.ExpectExec(regexp.QuoteMeta(sweepResourcesQuery)).
+		WithArgs("local-cluster", int64(4), "cluster__local-cluster", []string{}).
+		WillReturnResult(pgxmock.NewResult("DELETE", 3))
+	mockConn.ExpectExec(regexp.QuoteMeta(swapEdgesQuery())).WithArgs("local-cluster", int64(4)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mockConn.ExpectExec(regexp.QuoteMeta(sweepEdgesQuery)).WithArgs("local-cluster", int64(4), []string{}).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mockConn.ExpectExec(regexp.QuoteMeta(clearResourcesStagingQuery)).WithArgs("local-cluster", int64(4)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	mockConn.ExpectExec(regexp.QuoteMeta(clearEdgesStagingQuery)).WithArgs("local-cluster", int64(4)).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mockConn.ExpectCommit()
+
+	data, _ := os.Open("./mocks/simple.json")
+	dataBytes, _ := io.ReadAll(data)
+	defer testutils.SupressConsoleOutput()()
+
+	response := &model.SyncResponse{}
+	err = dao.ResyncData(context.Background(), "local-cluster", response, dataBytes)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, response.TotalDeleted)
+	assert.Equal(t, 1, response.TotalEdgesDeleted)
+	if expectErr := mockConn.ExpectationsWereMet(); expectErr != nil {
+		t.Errorf("Unfulfilled expectations: %s", expectErr)
+	}
+}
+
+// Should clear the staged rows and not touch the live tables if staging fails.
+func Test_ResyncData_atomic_stagingError(t *testing.T) {
+	savedMode := config.Cfg.ResyncMode
+	config.Cfg.ResyncMode = "atomic"
+	defer func() { config.Cfg.ResyncMode = savedMode }()
+
+	dao, mockPool := buildMockDAO(t)
+	testutils.MockDatabaseState(mockPool)
+	testutils.MockResyncGeneration(mockPool, 4)
+
+	br := &testutils.MockBatchResults{MockErrorOnClose: errors.New("unexpected EOF")}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).AnyTimes()
+	mockPool.EXPECT().Exec(gomock.Any(), clearResourcesStagingQuery, "local-cluster", int64(4)).
+		Return(pgconn.CommandTag("DELETE 1"), nil)
+	mockPool.EXPECT().Exec(gomock.Any(), clearEdgesStagingQuery, "local-cluster", int64(4)).
+		Return(pgconn.CommandTag("DELETE 1"), nil)
+
+	data, _ := os.Open("./mocks/simple.json")
+	dataBytes, _ := io.ReadAll(data)
+	defer testutils.SupressConsoleOutput()()
+
+	err := dao.ResyncData(context.Background(), "local-cluster", &model.SyncResponse{}, dataBytes)
+
+	assert.NotNil(t, err)
+}
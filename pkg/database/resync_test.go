@@ -23,9 +23,12 @@ func Test_ResyncData(t *testing.T) {
 	dao, mockPool := buildMockDAO(t)
 
 	testutils.MockDatabaseState(mockPool) // Mock Postgres state and SELECT queries.
+	testutils.MockResyncGeneration(mockPool, 1)
 
 	br := &testutils.MockBatchResults{}
 	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(4)
+	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(pgconn.CommandTag("DELETE 0"), nil).Times(2)
 
 	// Prepare Request data.
 	data, _ := os.Open("./mocks/simple.json")
@@ -46,8 +49,9 @@ func Test_ResyncData_errors(t *testing.T) {
 	dao, mockPool := buildMockDAO(t)
 	// Mock Postgres state and SELECT queries.
 	testutils.MockDatabaseState(mockPool)
+	testutils.MockResyncGeneration(mockPool, 1)
 
-	// Mock error on INSERT.
+	// Mock error on INSERT. The sweep shouldn't run.
 	br := &testutils.MockBatchResults{MockErrorOnClose: errors.New("unexpected EOF")}
 	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(2)
 
@@ -146,3 +150,75 @@ func Test_HubClusterCleanupWithoutChangeWithRetry(t *testing.T) {
 	dao.hubClusterCleanUpWithRetry(context.Background(), "new-cluster")
 
 }
+
+// Should delete the rows with an older generation after the upserts complete.
+func Test_ResyncData_sweep(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	testutils.MockDatabaseState(mockPool)
+	testutils.MockResyncGeneration(mockPool, 3)
+
+	br := &testutils.MockBatchResults{}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(4)
+	mockPool.EXPECT().Exec(gomock.Any(),
+		"DELETE FROM search.resources WHERE cluster=$1 AND generation<$2 AND uid!=$3 AND uid!=ALL($4)",
+		"local-cluster", int64(3), "cluster__local-cluster", []string{}).Return(pgconn.CommandTag("DELETE 2"), nil)
+	mockPool.EXPECT().Exec(gomock.Any(),
+		"DELETE FROM search.edges WHERE cluster=$1 AND generation<$2 AND edgetype!='interCluster' AND sourceid!=ALL($3)",
+		"local-cluster", int64(3), []string{}).Return(pgconn.CommandTag("DELETE 5"), nil)
+
+	data, _ := os.Open("./mocks/simple.json")
+	dataBytes, _ := io.ReadAll(data)
+	defer testutils.SupressConsoleOutput()()
+
+	response := &model.SyncResponse{}
+	err := dao.ResyncData(context.Background(), "local-cluster", response, dataBytes)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, response.TotalDeleted)
+	assert.Equal(t, 5, response.TotalEdgesDeleted)
+}
+
+// Should keep the rows that failed to upsert, because they still have the old generation.
+func Test_ResyncData_sweepExcludesFailedUpserts(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	testutils.MockDatabaseState(mockPool)
+	testutils.MockResyncGeneration(mockPool, 3)
+
+	br := &testutils.MockBatchResults{MockErrorOnExec: errors.New("mocking error on exec")}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).AnyTimes()
+	mockPool.EXPECT().Exec(gomock.Any(),
+		"DELETE FROM search.resources WHERE cluster=$1 AND generation<$2 AND uid!=$3 AND uid!=ALL($4)",
+		"local-cluster", int64(3), "cluster__local-cluster", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			assert.ElementsMatch(t, []string{"local-cluster/e12c2ddd-4ac5-499d-b0e0-20242f508afd",
+				"local-cluster/13250bc4-865c-41db-a8f2-05bec0bd042b"}, args[3])
+			return pgconn.CommandTag("DELETE 0"), nil
+		})
+	mockPool.EXPECT().Exec(gomock.Any(),
+		"DELETE FROM search.edges WHERE cluster=$1 AND generation<$2 AND edgetype!='interCluster' AND sourceid!=ALL($3)",
+		"local-cluster", int64(3), []string{"local-cluster/00000000-0000-0000-0000-000000000880"}).
+		Return(pgconn.CommandTag("DELETE 0"), nil)
+
+	data, _ := os.Open("./mocks/simple.json")
+	dataBytes, _ := io.ReadAll(data)
+	defer testutils.SupressConsoleOutput()()
+
+	response := &model.SyncResponse{}
+	err := dao.ResyncData(context.Background(), "local-cluster", response, dataBytes)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(response.AddErrors))
+	assert.Equal(t, 1, len(response.AddEdgeErrors))
+}
+
+// Should return an error and skip the resync if the generation can't be incremented.
+func Test_ResyncData_generationError(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), "local-cluster").
+		Return(nil, errors.New("connection refused"))
+
+	defer testutils.SupressConsoleOutput()()
+	err := dao.ResyncData(context.Background(), "local-cluster", &model.SyncResponse{}, []byte("{}"))
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,134 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+)
+
+// Size limits on the resource data, measured as JSON. MAX_PROPERTY_BYTES limits each property value and
+// MAX_RESOURCE_BYTES limits the data of a resource. OVERSIZE_POLICY sets what happens to the data over a limit:
+//
+//	reject    The resource isn't indexed and is reported in the add or update errors.
+//	truncate  Strings over the property limit are truncated and end with "...[truncated]". Other values are dropped.
+//	drop      Properties over the property limit are dropped.
+//
+// With truncate and drop, the largest properties of a resource over the resource limit are dropped until it fits.
+// The properties that identify a resource are never dropped; the resource is rejected if it doesn't fit without
+// them. Truncated and dropped properties are reported in the property errors.
+
+// Appended to truncated strings.
+const truncatedMarker = "...[truncated]"
+
+// Marshal the properties of the resource, applying the size limits. Returns an error if the resource is rejected.
+func marshalProperties(resource *model.Resource) ([]byte, []model.SyncError, error) {
+	maxProperty, maxResource := config.Cfg.MaxPropertyBytes, config.Cfg.MaxResourceBytes
+	if maxProperty <= 0 && maxResource <= 0 {
+		data, _ := json.Marshal(resource.Properties)
+		return data, nil, nil
+	}
+	policy := config.Cfg.OversizePolicy
+	var errs []model.SyncError
+	sizes := make(map[string]int, len(resource.Properties))
+
+	for property, value := range resource.Properties {
+		size := jsonSize(value)
+		if maxProperty > 0 && size > maxProperty {
+			if policy == "reject" {
+				metrics.OversizedData.WithLabelValues("property", "reject").Inc()
+				return nil, errs, fmt.Errorf("property %s is %d bytes, over the limit of %d bytes", property, size,
+					maxProperty)
+			}
+			if s, ok := value.(string); ok && policy == "truncate" {
+				if truncated, ok := truncateString(s, maxProperty); ok {
+					resource.Properties[property] = truncated
+					metrics.OversizedData.WithLabelValues("property", "truncate").Inc()
+					errs = append(errs, model.SyncError{ResourceUID: resource.UID,
+						Message: fmt.Sprintf("property %s truncated from %d bytes to the limit of %d bytes", property,
+							size, maxProperty)})
+					sizes[property] = jsonSize(truncated)
+					continue
+				}
+			}
+			if isRequiredProperty(property) {
+				metrics.OversizedData.WithLabelValues("property", "reject").Inc()
+				return nil, errs, fmt.Errorf("property %s is %d bytes, over the limit of %d bytes", property, size,
+					maxProperty)
+			}
+			delete(resource.Properties, property)
+			metrics.OversizedData.WithLabelValues("property", "drop").Inc()
+			errs = append(errs, model.SyncError{ResourceUID: resource.UID,
+				Message: fmt.Sprintf("property %s dropped, %d bytes over the limit of %d bytes", property, size,
+					maxProperty)})
+			continue
+		}
+		sizes[property] = size
+	}
+
+	data, _ := json.Marshal(resource.Properties)
+	if maxResource <= 0 || len(data) <= maxResource {
+		return data, errs, nil
+	}
+	if policy == "reject" {
+		metrics.OversizedData.WithLabelValues("resource", "reject").Inc()
+		return nil, errs, fmt.Errorf("resource data is %d bytes, over the limit of %d bytes", len(data), maxResource)
+	}
+
+	// Drop the largest properties until the resource fits.
+	droppable := make([]string, 0, len(sizes))
+	for property := range sizes {
+		if !isRequiredProperty(property) {
+			droppable = append(droppable, property)
+		}
+	}
+	sort.Slice(droppable, func(i, j int) bool {
+		if sizes[droppable[i]] != sizes[droppable[j]] {
+			return sizes[droppable[i]] > sizes[droppable[j]]
+		}
+		return droppable[i] < droppable[j]
+	})
+	for _, property := range droppable {
+		delete(resource.Properties, property)
+		metrics.OversizedData.WithLabelValues("resource", "drop").Inc()
+		errs = append(errs, model.SyncError{ResourceUID: resource.UID,
+			Message: fmt.Sprintf("property %s dropped, the resource data is over the limit of %d bytes", property,
+				maxResource)})
+		if data, _ = json.Marshal(resource.Properties); len(data) <= maxResource {
+			return data, errs, nil
+		}
+	}
+	metrics.OversizedData.WithLabelValues("resource", "reject").Inc()
+	return nil, errs, fmt.Errorf("resource data is %d bytes without the optional properties, over the limit of %d bytes",
+		len(data), maxResource)
+}
+
+// Bytes of the value as JSON.
+func jsonSize(value interface{}) int {
+	data, _ := json.Marshal(value)
+	return len(data)
+}
+
+// Truncate the string at a rune boundary, with the marker, so it fits in the limit as JSON. Returns false if the
+// limit is too small for the marker.
+func truncateString(s string, limit int) (string, bool) {
+	n := min(len(s), limit-len(truncatedMarker)-2) // Quotes.
+	for n > 0 {
+		for n > 0 && n < len(s) && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		truncated := s[:n] + truncatedMarker
+		size := jsonSize(truncated)
+		if size <= limit {
+			return truncated, true
+		}
+		n -= max(1, (size-limit+5)/6) // An escaped byte is up to 6 bytes in JSON.
+	}
+	return "", false
+}
@@ -0,0 +1,132 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func setSizeLimits(t *testing.T, maxProperty, maxResource int, policy string) {
+	savedProperty, savedResource, savedPolicy := config.Cfg.MaxPropertyBytes, config.Cfg.MaxResourceBytes,
+		config.Cfg.OversizePolicy
+	config.Cfg.MaxPropertyBytes, config.Cfg.MaxResourceBytes, config.Cfg.OversizePolicy = maxProperty, maxResource,
+		policy
+	t.Cleanup(func() {
+		config.Cfg.MaxPropertyBytes, config.Cfg.MaxResourceBytes, config.Cfg.OversizePolicy = savedProperty,
+			savedResource, savedPolicy
+	})
+}
+
+func Test_marshalProperties_unlimited(t *testing.T) {
+	resource := model.Resource{UID: "uid-1", Properties: map[string]interface{}{"name": "pod-1"}}
+
+	data, errs, err := marshalProperties(&resource)
+
+	assert.Nil(t, err)
+	assert.Empty(t, errs)
+	assert.Equal(t, `{"name":"pod-1"}`, string(data))
+}
+
+func Test_marshalProperties_rejectProperty(t *testing.T) {
+	setSizeLimits(t, 20, 0, "reject")
+	rejected := testutil.ToFloat64(metrics.OversizedData.WithLabelValues("property", "reject"))
+	resource := model.Resource{UID: "uid-1", Properties: map[string]interface{}{
+		"name": "pod-1", "annotation": map[string]interface{}{"description": "a long description"}}}
+
+	data, _, err := marshalProperties(&resource)
+
+	assert.Nil(t, data)
+	assert.Equal(t, "property annotation is 36 bytes, over the limit of 20 bytes", err.Error())
+	assert.Equal(t, rejected+1, testutil.ToFloat64(metrics.OversizedData.WithLabelValues("property", "reject")))
+}
+
+// Should truncate the strings and drop the other values over the limit.
+func Test_marshalProperties_truncateProperty(t *testing.T) {
+	setSizeLimits(t, 20, 0, "truncate")
+	resource := model.Resource{UID: "uid-1", Properties: map[string]interface{}{
+		"name": "pod-1", "message": "éééééééééé", "container": []interface{}{"nginx", "envoy", "istio-proxy"}}}
+
+	data, errs, err := marshalProperties(&resource)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"message":"éé...[truncated]","name":"pod-1"}`, string(data))
+	assert.Equal(t, []model.SyncError{
+		{ResourceUID: "uid-1", Message: "property container dropped, 31 bytes over the limit of 20 bytes"},
+		{ResourceUID: "uid-1", Message: "property message truncated from 22 bytes to the limit of 20 bytes"},
+	}, sortedErrors(errs))
+}
+
+func Test_marshalProperties_dropProperty(t *testing.T) {
+	setSizeLimits(t, 20, 0, "drop")
+	resource := model.Resource{UID: "uid-1", Properties: map[string]interface{}{
+		"name": "pod-1", "message": strings.Repeat("x", 30)}}
+
+	data, errs, err := marshalProperties(&resource)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"pod-1"}`, string(data))
+	assert.Equal(t, "property message dropped, 32 bytes over the limit of 20 bytes", errs[0].Message)
+}
+
+// Should reject the resource instead of dropping a property that identifies it.
+func Test_marshalProperties_requiredProperty(t *testing.T) {
+	setSizeLimits(t, 20, 0, "drop")
+	resource := model.Resource{UID: "uid-1", Properties: map[string]interface{}{"name": strings.Repeat("x", 30)}}
+
+	_, _, err := marshalProperties(&resource)
+
+	assert.Equal(t, "property name is 32 bytes, over the limit of 20 bytes", err.Error())
+}
+
+// Should drop the largest optional properties until the resource fits.
+func Test_marshalProperties_dropFromResource(t *testing.T) {
+	setSizeLimits(t, 0, 70, "truncate")
+	resource := model.Resource{UID: "uid-1", Properties: map[string]interface{}{
+		"kind": "Pod", "name": "pod-1", "_uid": "uid-1", "status": "Running",
+		"label": map[string]interface{}{"app": "web", "team": "search"}}}
+
+	data, errs, err := marshalProperties(&resource)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"_uid":"uid-1","kind":"Pod","name":"pod-1","status":"Running"}`, string(data))
+	assert.Equal(t, []model.SyncError{
+		{ResourceUID: "uid-1", Message: "property label dropped, the resource data is over the limit of 70 bytes"},
+	}, errs)
+}
+
+func Test_marshalProperties_rejectResource(t *testing.T) {
+	setSizeLimits(t, 0, 30, "drop")
+	resource := model.Resource{UID: "uid-1", Properties: map[string]interface{}{
+		"kind": "Pod", "name": "pod-1", "namespace": "default", "status": "Running"}}
+
+	data, _, err := marshalProperties(&resource)
+
+	assert.Nil(t, data)
+	assert.Equal(t, "resource data is 51 bytes without the optional properties, over the limit of 30 bytes",
+		err.Error())
+}
+
+func Test_truncateString(t *testing.T) {
+	truncated, ok := truncateString(strings.Repeat("<", 20), 30)
+	assert.True(t, ok)
+	assert.LessOrEqual(t, jsonSize(truncated), 30)
+	assert.True(t, strings.HasSuffix(truncated, truncatedMarker))
+
+	_, ok = truncateString(strings.Repeat("x", 20), 10)
+	assert.False(t, ok)
+}
+
+// Errors sorted by message, because the properties are visited in random order.
+func sortedErrors(errs []model.SyncError) []model.SyncError {
+	sorted := append([]model.SyncError{}, errs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Message < sorted[j].Message })
+	return sorted
+}
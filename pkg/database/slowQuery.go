@@ -0,0 +1,207 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// Slow query diagnostics. When SLOW_QUERY_EXPLAIN_MS is set, a sample of the batches slower than the threshold
+// are explained with EXPLAIN (ANALYZE, BUFFERS). The plans are kept in a ring buffer and served from the
+// /admin/slow-queries endpoint.
+//
+// EXPLAIN ANALYZE executes the statement, so it runs in a transaction that is always rolled back. The statement
+// holds its locks until the rollback, so it runs with a short statement_timeout and lock_timeout. A statement that
+// exceeds them is explained again without ANALYZE, which doesn't execute it.
+
+const maxExplainedQueries = 3 // Distinct queries explained from each slow batch.
+const maxQueryLength = 2000   // Queries longer than this are truncated in the captured plan.
+const explainTimeout = 1 * time.Minute
+const explainStatementTimeout = "5s"
+const explainLockTimeout = "500ms"
+
+// QueryPlan is the EXPLAIN output for a statement from a slow batch.
+type QueryPlan struct {
+	Timestamp       time.Time `json:"timestamp"`
+	Action          string    `json:"action"`
+	Query           string    `json:"query"`
+	BatchSize       int       `json:"batchSize"`
+	BatchDurationMS int64     `json:"batchDurationMs"`
+	Plan            string    `json:"plan,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// Fixed size buffer that keeps the most recent plans.
+type planRing struct {
+	lock  sync.Mutex
+	plans []QueryPlan
+	next  int
+	full  bool
+}
+
+func (r *planRing) add(plan QueryPlan) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.plans == nil {
+		if config.Cfg.SlowQueryPlans <= 0 {
+			return
+		}
+		r.plans = make([]QueryPlan, config.Cfg.SlowQueryPlans)
+	}
+	r.plans[r.next] = plan
+	r.next = (r.next + 1) % len(r.plans)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Returns the plans, newest first.
+func (r *planRing) list() []QueryPlan {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	count := r.next
+	if r.full {
+		count = len(r.plans)
+	}
+	result := make([]QueryPlan, 0, count)
+	for i := 1; i <= count; i++ {
+		result = append(result, r.plans[(r.next-i+len(r.plans))%len(r.plans)])
+	}
+	return result
+}
+
+var slowQueryPlans = &planRing{}
+var explainRunning atomic.Bool // Only one batch is explained at a time to limit the load on the database.
+
+// SlowQueryPlans returns the captured query plans, newest first.
+func SlowQueryPlans() []QueryPlan {
+	return slowQueryPlans.list()
+}
+
+// Check if the batch is slow and sampled. If so, explain its queries in the background.
+func (dao *DAO) explainSlowBatch(items []batchItem, duration time.Duration) {
+	threshold := time.Duration(config.Cfg.SlowQueryExplainMS) * time.Millisecond
+	if threshold <= 0 || duration < threshold || rand.Intn(100) >= config.Cfg.SlowQueryExplainPct { // #nosec G404
+		return
+	}
+	if !explainRunning.CompareAndSwap(false, true) {
+		klog.V(3).Info("Skipping EXPLAIN of slow batch because another batch is being explained.")
+		return
+	}
+	go func() {
+		defer explainRunning.Store(false)
+		ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+		defer cancel()
+		dao.explainBatch(ctx, items, duration)
+	}()
+}
+
+// Explain the distinct queries in the batch and save the plans.
+func (dao *DAO) explainBatch(ctx context.Context, items []batchItem, duration time.Duration) {
+	explained := map[string]struct{}{}
+	for _, item := range items {
+		if len(explained) >= maxExplainedQueries {
+			break
+		}
+		if _, ok := explained[item.query]; ok {
+			continue
+		}
+		explained[item.query] = struct{}{}
+
+		query := item.query
+		if len(query) > maxQueryLength {
+			query = query[:maxQueryLength] + "..."
+		}
+		queryPlan := QueryPlan{
+			Timestamp:       time.Now(),
+			Action:          item.action,
+			Query:           query,
+			BatchSize:       len(items),
+			BatchDurationMS: duration.Milliseconds(),
+		}
+		plan, err := dao.explain(ctx, item.query, item.args)
+		if err != nil {
+			klog.Warningf("Error explaining slow %s query. %s", item.action, err)
+			queryPlan.Error = err.Error()
+		}
+		queryPlan.Plan = plan
+		slowQueryPlans.add(queryPlan)
+	}
+}
+
+// Explain the statement with ANALYZE, or without it if the statement exceeds the timeouts of EXPLAIN ANALYZE.
+func (dao *DAO) explain(ctx context.Context, query string, args []interface{}) (string, error) {
+	plan, err := dao.explainAnalyze(ctx, query, args)
+	var pgErr *pgconn.PgError
+	// 57014 is query_canceled, from statement_timeout. 55P03 is lock_not_available, from lock_timeout.
+	if errors.As(err, &pgErr) && (pgErr.Code == "57014" || pgErr.Code == "55P03") {
+		klog.V(3).Infof("EXPLAIN ANALYZE of slow query exceeded its timeout. Explaining without ANALYZE. %s", err)
+		rows, err := dao.pool.Query(ctx, "EXPLAIN "+query, args...)
+		if err != nil {
+			return "", err
+		}
+		return readPlan(rows)
+	}
+	return plan, err
+}
+
+// Run EXPLAIN (ANALYZE, BUFFERS) in a transaction that is rolled back, so the statement has no effect.
+func (dao *DAO) explainAnalyze(ctx context.Context, query string, args []interface{}) (string, error) {
+	tx, err := dao.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+			klog.Warning("Error rolling back EXPLAIN transaction. ", rollbackErr)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, "SET LOCAL statement_timeout = '"+explainStatementTimeout+"'"); err != nil {
+		return "", err
+	}
+	if _, err = tx.Exec(ctx, "SET LOCAL lock_timeout = '"+explainLockTimeout+"'"); err != nil {
+		return "", err
+	}
+	rows, err := tx.Query(ctx, "EXPLAIN (ANALYZE, BUFFERS) "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	return readPlan(rows)
+}
+
+// Read the lines of an EXPLAIN output.
+func readPlan(rows pgx.Rows) (string, error) {
+	defer rows.Close()
+	lines := make([]string, 0)
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// Run a statement that isn't batched, like the resync sweeps, and explain it like a batch if it's slow.
+func (dao *DAO) execWithExplain(ctx context.Context, action, query string, args ...interface{}) (pgconn.CommandTag,
+	error) {
+	start := time.Now()
+	result, err := dao.pool.Exec(ctx, query, args...)
+	if err == nil {
+		dao.explainSlowBatch([]batchItem{{query: query, args: args, action: action}}, time.Since(start))
+	}
+	return result, err
+}
@@ -0,0 +1,133 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package database
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/driftprogramming/pgxpoolmock"
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/pashagolub/pgxmock"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// Should keep the most recent plans, newest first.
+func Test_planRing(t *testing.T) {
+	savedSize := config.Cfg.SlowQueryPlans
+	config.Cfg.SlowQueryPlans = 3
+	defer func() { config.Cfg.SlowQueryPlans = savedSize }()
+
+	ring := &planRing{}
+	assert.Equal(t, 0, len(ring.list()))
+
+	for _, action := range []string{"a", "b"} {
+		ring.add(QueryPlan{Action: action})
+	}
+	assert.Equal(t, []QueryPlan{{Action: "b"}, {Action: "a"}}, ring.list())
+
+	for _, action := range []string{"c", "d", "e"} {
+		ring.add(QueryPlan{Action: action})
+	}
+	assert.Equal(t, []QueryPlan{{Action: "e"}, {Action: "d"}, {Action: "c"}}, ring.list())
+}
+
+func expectExplainTimeouts(mockConn pgxmock.PgxConnIface) {
+	mockConn.ExpectExec(regexp.QuoteMeta("SET LOCAL statement_timeout = '5s'")).
+		WillReturnResult(pgxmock.NewResult("SET", 0))
+	mockConn.ExpectExec(regexp.QuoteMeta("SET LOCAL lock_timeout = '500ms'")).
+		WillReturnResult(pgxmock.NewResult("SET", 0))
+}
+
+// Should explain each distinct query in a transaction that is rolled back.
+func Test_explainBatch(t *testing.T) {
+	savedPlans := slowQueryPlans
+	slowQueryPlans = &planRing{}
+	defer func() { slowQueryPlans = savedPlans }()
+
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func() { _ = mockConn.Close(context.Background()) }()
+	dao, mockPool := buildMockDAO(t)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil).Times(2)
+
+	deleteQuery := "DELETE from search.resources WHERE cluster=$1"
+	insertQuery := "INSERT into search.edges values($1)"
+	expectExplainTimeouts(mockConn)
+	mockConn.ExpectQuery(regexp.QuoteMeta("EXPLAIN (ANALYZE, BUFFERS) " + deleteQuery)).WithArgs("cluster1").
+		WillReturnRows(pgxmock.NewRows([]string{"QUERY PLAN"}).
+			AddRow("Delete on resources").AddRow("  ->  Seq Scan on resources"))
+	mockConn.ExpectRollback()
+	expectExplainTimeouts(mockConn)
+	mockConn.ExpectQuery(regexp.QuoteMeta("EXPLAIN (ANALYZE, BUFFERS) " + insertQuery)).WithArgs("edge1").
+		WillReturnRows(pgxmock.NewRows([]string{"QUERY PLAN"}).AddRow("Insert on edges"))
+	mockConn.ExpectRollback()
+
+	items := []batchItem{
+		{action: "deleteResource", query: deleteQuery, args: []interface{}{"cluster1"}},
+		{action: "addEdge", query: insertQuery, args: []interface{}{"edge1"}},
+		{action: "addEdge", query: insertQuery, args: []interface{}{"edge2"}},
+	}
+	dao.explainBatch(context.Background(), items, 2*time.Second)
+
+	assert.Nil(t, mockConn.ExpectationsWereMet())
+	plans := SlowQueryPlans()
+	assert.Equal(t, 2, len(plans))
+	assert.Equal(t, "addEdge", plans[0].Action)
+	assert.Equal(t, "Insert on edges", plans[0].Plan)
+	assert.Equal(t, "deleteResource", plans[1].Action)
+	assert.Equal(t, deleteQuery, plans[1].Query)
+	assert.Equal(t, "Delete on resources\n  ->  Seq Scan on resources", plans[1].Plan)
+	assert.Equal(t, 3, plans[1].BatchSize)
+	assert.Equal(t, int64(2000), plans[1].BatchDurationMS)
+}
+
+// Should explain without ANALYZE if the statement exceeds the timeouts of EXPLAIN ANALYZE.
+func Test_explain_timeout(t *testing.T) {
+	mockConn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer func() { _ = mockConn.Close(context.Background()) }()
+	dao, mockPool := buildMockDAO(t)
+	query := "DELETE FROM search.resources WHERE cluster=$1"
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
+	expectExplainTimeouts(mockConn)
+	mockConn.ExpectQuery(regexp.QuoteMeta("EXPLAIN (ANALYZE, BUFFERS) " + query)).WithArgs("cluster1").
+		WillReturnError(&pgconn.PgError{Code: "55P03", Message: "canceling statement due to lock timeout"})
+	mockConn.ExpectRollback()
+	mockPool.EXPECT().Query(gomock.Any(), "EXPLAIN "+query, "cluster1").
+		Return(pgxpoolmock.NewRows([]string{"QUERY PLAN"}).AddRow("Delete on resources").ToPgxRows(), nil)
+
+	plan, err := dao.explain(context.Background(), query, []interface{}{"cluster1"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Delete on resources", plan)
+	assert.Nil(t, mockConn.ExpectationsWereMet())
+}
+
+// Should not explain batches when disabled or faster than the threshold.
+func Test_explainSlowBatch_Skip(t *testing.T) {
+	savedThreshold, savedPct := config.Cfg.SlowQueryExplainMS, config.Cfg.SlowQueryExplainPct
+	defer func() { config.Cfg.SlowQueryExplainMS, config.Cfg.SlowQueryExplainPct = savedThreshold, savedPct }()
+	dao, _ := buildMockDAO(t) // The mock pool fails the test if the batch is explained.
+	items := []batchItem{{action: "addEdge", query: "INSERT into search.edges values($1)"}}
+
+	config.Cfg.SlowQueryExplainMS, config.Cfg.SlowQueryExplainPct = 0, 100
+	dao.explainSlowBatch(items, time.Minute)
+
+	config.Cfg.SlowQueryExplainMS = 1000
+	dao.explainSlowBatch(items, 999*time.Millisecond)
+
+	config.Cfg.SlowQueryExplainPct = 0
+	dao.explainSlowBatch(items, time.Minute)
+
+	assert.False(t, explainRunning.Load())
+}
@@ -4,13 +4,14 @@ package database
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strings"
 
+	"github.com/stolostron/search-indexer/pkg/logging"
 	"github.com/stolostron/search-indexer/pkg/metrics"
 	"github.com/stolostron/search-indexer/pkg/model"
-	"k8s.io/klog/v2"
+	"github.com/stolostron/search-indexer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // validateUIDPrefix rejects resource UIDs that don't begin with "<clusterName>/".
@@ -27,25 +28,64 @@ func validateUIDPrefix(uid, clusterName string) error {
 func (dao *DAO) SyncData(ctx context.Context, event model.SyncEvent,
 	clusterName string, syncResponse *model.SyncResponse) error {
 
-	defer metrics.SlowLog(fmt.Sprintf("Slow Sync from cluster %s.", clusterName), 0)()
+	ctx, span := tracing.StartSpan(ctx, "SyncData", attribute.String("cluster", clusterName),
+		attribute.Int("resources.added", len(event.AddResources)),
+		attribute.Int("resources.updated", len(event.UpdateResources)),
+		attribute.Int("resources.deleted", len(event.DeleteResources)))
+	defer span.End()
+	logger := logging.Operation(ctx, "syncData")
+	defer metrics.SlowLog(ctx, "Slow sync", 0)()
+	dao.ensureClusterPartitions(ctx, clusterName)
 	batch := NewBatchWithRetry(ctx, dao, syncResponse)
+	catalog := propertyCatalog{}
+	quota := dao.newQuotaTracker(ctx, clusterName, false)
+	sizeUIDs := make([]string, 0, len(event.UpdateResources)+len(event.DeleteResources))
+	deletedUIDs := make([]string, len(event.DeleteResources))
+	for _, resource := range event.UpdateResources {
+		sizeUIDs = append(sizeUIDs, resource.UID)
+	}
+	for i, resource := range event.DeleteResources {
+		sizeUIDs = append(sizeUIDs, resource.UID)
+		deletedUIDs[i] = resource.UID
+	}
+	quota.readSizes(ctx, dao, sizeUIDs)
+	quota.delete(deletedUIDs, len(event.DeleteEdges))
+	validator := dao.newEdgeValidator(clusterName, false)
+	interCluster := newInterClusterChanges()
 	var queueErr error
 
 	// ADD RESOURCES
 	// In case of conflict update only if data has changed AND the row is owned by this cluster.
 	// The cluster guard on the conflict target prevents a spoke from overwriting another spoke's
-	// row by submitting a resource with a colliding UID.
+	// row by submitting a resource with a colliding UID. Rows are written with the current resync generation of
+	// the cluster, see currentGeneration.
 	for _, resource := range event.AddResources {
 		if err := validateUIDPrefix(resource.UID, clusterName); err != nil {
-			klog.Warningf("Rejecting addResource from cluster [%s]: %v", clusterName, err)
+			logging.Warning(ctx, "Rejecting addResource", "operation", "syncData", "uid", resource.UID, "err", err)
+			syncResponse.AddErrors = append(syncResponse.AddErrors, model.SyncError{ResourceUID: resource.UID, Message: err.Error()})
+			continue
+		}
+		redactProperties(&resource)
+		syncResponse.PropertyErrors = append(syncResponse.PropertyErrors, normalizeProperties(&resource)...)
+		data, propertyErrors, err := marshalProperties(&resource)
+		syncResponse.PropertyErrors = append(syncResponse.PropertyErrors, propertyErrors...)
+		if err != nil {
+			syncResponse.AddErrors = append(syncResponse.AddErrors, model.SyncError{ResourceUID: resource.UID, Message: err.Error()})
+			continue
+		}
+		if err := quota.addResource(resource.UID, len(data)); err != nil {
 			syncResponse.AddErrors = append(syncResponse.AddErrors, model.SyncError{ResourceUID: resource.UID, Message: err.Error()})
 			continue
 		}
-		data, _ := json.Marshal(resource.Properties)
+		catalog.add(resource)
+		interCluster.add(resource)
+		validator.addResource(resource.UID)
 		queueErr = batch.Queue(batchItem{
 			action: "addResource",
-			query: `INSERT into search.resources as r values($1,$2,$3) ON CONFLICT (uid) 
-			DO UPDATE SET data=$3 WHERE r.uid=$1 AND r.cluster=$2 AND r.data IS DISTINCT FROM $3`,
+			query: fmt.Sprintf(`INSERT into search.resources as r (uid, cluster, data, generation) values($1,$2,$3,%s)
+			ON CONFLICT (%s) DO UPDATE SET data=$3, generation=EXCLUDED.generation WHERE r.uid=$1 AND r.cluster=$2
+			AND (r.data IS DISTINCT FROM $3 OR r.generation<EXCLUDED.generation)`,
+				currentGeneration(2), resourcesConflictTarget()),
 			uid:  resource.UID,
 			args: []interface{}{resource.UID, clusterName, string(data)},
 		})
@@ -57,14 +97,27 @@ func (dao *DAO) SyncData(ctx context.Context, event model.SyncEvent,
 	// AND cluster=$3 ensures a spoke can only update rows it owns.
 	for _, resource := range event.UpdateResources {
 		if err := validateUIDPrefix(resource.UID, clusterName); err != nil {
-			klog.Warningf("Rejecting updateResource from cluster [%s]: %v", clusterName, err)
+			logging.Warning(ctx, "Rejecting updateResource", "operation", "syncData", "uid", resource.UID, "err", err)
 			syncResponse.UpdateErrors = append(syncResponse.UpdateErrors, model.SyncError{ResourceUID: resource.UID, Message: err.Error()})
 			continue
 		}
-		data, _ := json.Marshal(resource.Properties)
+		redactProperties(&resource)
+		syncResponse.PropertyErrors = append(syncResponse.PropertyErrors, normalizeProperties(&resource)...)
+		data, propertyErrors, err := marshalProperties(&resource)
+		syncResponse.PropertyErrors = append(syncResponse.PropertyErrors, propertyErrors...)
+		if err != nil {
+			syncResponse.UpdateErrors = append(syncResponse.UpdateErrors, model.SyncError{ResourceUID: resource.UID, Message: err.Error()})
+			continue
+		}
+		if err := quota.updateResource(resource.UID, len(data)); err != nil {
+			syncResponse.UpdateErrors = append(syncResponse.UpdateErrors, model.SyncError{ResourceUID: resource.UID, Message: err.Error()})
+			continue
+		}
+		catalog.add(resource)
+		interCluster.add(resource)
 		queueErr = batch.Queue(batchItem{
 			action: "updateResource",
-			query:  "UPDATE search.resources SET data=$2 WHERE uid=$1 AND cluster=$3",
+			query:  fmt.Sprintf("UPDATE search.resources SET data=$2, generation=%s WHERE uid=$1 AND cluster=$3", currentGeneration(3)),
 			uid:    resource.UID,
 			args:   []interface{}{resource.UID, string(data), clusterName},
 		})
@@ -79,6 +132,7 @@ func (dao *DAO) SyncData(ctx context.Context, event model.SyncEvent,
 		for i, resource := range event.DeleteResources {
 			params[i] = fmt.Sprintf("$%d", i+2) // $2, $3, … (cluster is $1)
 			uids[i] = resource.UID
+			validator.deleteResource(resource.UID)
 		}
 		paramStr := strings.Join(params, ",")
 		args := append([]interface{}{clusterName}, uids...)
@@ -100,16 +154,39 @@ func (dao *DAO) SyncData(ctx context.Context, event model.SyncEvent,
 		if err != nil {
 			queueErr = err
 		}
+		// The interCluster edges to hub resources are stored with the cluster of their source. Deletes carry no
+		// _hubClusterResource, so the edges are deleted for every cluster. Only hub resources have these edges.
+		if interCluster != nil {
+			queueErr = batch.Queue(batchItem{
+				action: "deleteEdge",
+				query:  "DELETE from search.edges WHERE edgetype='interCluster' AND destid=ANY($1)",
+				uid:    fmt.Sprintf("%s", uids),
+				args:   []interface{}{deletedUIDs},
+			})
+		}
 	}
 
 	// ADD EDGES
-	// Nothing to update in case of conflict as resource kind cannot change
+	// In case of conflict only the generation is updated, as resource kind cannot change.
+	validator.lookup(ctx, event.AddEdges)
 	for _, edge := range event.AddEdges {
+		if err := validator.validate(ctx, edge); err != nil {
+			syncResponse.AddEdgeErrors = append(syncResponse.AddEdgeErrors,
+				model.SyncError{ResourceUID: edge.SourceUID, Message: err.Error()})
+			continue
+		}
+		if err := quota.addEdge(); err != nil {
+			syncResponse.AddEdgeErrors = append(syncResponse.AddEdgeErrors,
+				model.SyncError{ResourceUID: edge.SourceUID, Message: err.Error()})
+			continue
+		}
 		queueErr = batch.Queue(batchItem{
 			action: "addEdge",
-			query:  "INSERT into search.edges values($1,$2,$3,$4,$5,$6) ON CONFLICT (sourceid, destid, edgetype) DO NOTHING",
-			uid:    edge.SourceUID,
-			args:   []interface{}{edge.SourceUID, edge.SourceKind, edge.DestUID, edge.DestKind, edge.EdgeType, clusterName}})
+			query: fmt.Sprintf(`INSERT into search.edges as e (sourceid, sourcekind, destid, destkind, edgetype, cluster, generation)
+			values($1,$2,$3,$4,$5,$6,%s) ON CONFLICT (%s) DO UPDATE SET generation=EXCLUDED.generation
+			WHERE e.cluster=$6 AND e.generation<EXCLUDED.generation`, currentGeneration(6), edgesConflictTarget()),
+			uid:  edge.SourceUID,
+			args: []interface{}{edge.SourceUID, edge.SourceKind, edge.DestUID, edge.DestKind, edge.EdgeType, clusterName}})
 	}
 
 	// UPDATE EDGES
@@ -130,8 +207,13 @@ func (dao *DAO) SyncData(ctx context.Context, event model.SyncEvent,
 
 	// Wait for all batches to complete.
 	batch.wg.Wait()
+	dao.savePropertyTypes(ctx)
+	dao.savePropertyCatalog(ctx, clusterName, catalog, false)
+	quota.releaseFailed(batch.failedUIDs("addResource"), batch.failedUIDs("addEdge"))
+	quota.save()
+	interCluster.queue(clusterName, false)
 	if queueErr != nil {
-		klog.V(1).Infof("Completed sync of cluster %12s with errors.", clusterName)
+		logger.V(1).Info("Completed sync with errors", "err", queueErr)
 		return queueErr
 	}
 
@@ -142,6 +224,6 @@ func (dao *DAO) SyncData(ctx context.Context, event model.SyncEvent,
 	syncResponse.TotalEdgesAdded = len(event.AddEdges) - len(syncResponse.AddEdgeErrors)
 	syncResponse.TotalEdgesDeleted = len(event.DeleteEdges) - len(syncResponse.DeleteEdgeErrors)
 
-	klog.V(1).Infof("Completed sync of cluster %12s", clusterName)
+	logger.V(1).Info("Completed sync")
 	return batch.connError
 }
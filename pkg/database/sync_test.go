@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	pgx "github.com/jackc/pgx/v4"
 	"github.com/stolostron/search-indexer/pkg/model"
 	"github.com/stolostron/search-indexer/pkg/testutils"
 	"github.com/stretchr/testify/assert"
@@ -100,6 +104,63 @@ func Test_Sync_With_OnClose_Errors(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+// A sync that overlaps with a resync of the same cluster writes its rows with the current resync generation of the
+// cluster. The resync bumps the generation before it upserts, so the sweep (generation<$2) keeps the synced rows.
+func Test_SyncData_duringResync(t *testing.T) {
+	dao, mockPool := buildMockDAO(t)
+	dao.batchSize = 1
+
+	var lock sync.Mutex
+	generationQueries := 0
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Times(7).
+		DoAndReturn(func(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+			lock.Lock()
+			defer lock.Unlock()
+			// pgx.Batch doesn't export the queued queries.
+			items := reflect.ValueOf(b).Elem().FieldByName("items")
+			for i := 0; i < items.Len(); i++ {
+				query := items.Index(i).Elem().FieldByName("query").String()
+				if strings.Contains(query, "SELECT generation FROM search.generations WHERE cluster=") {
+					generationQueries++
+				}
+			}
+			return &testutils.MockBatchResults{}
+		})
+
+	response := &model.SyncResponse{}
+	err := dao.SyncData(context.Background(), loadSimpleSyncEvent(t), "local-cluster", response)
+
+	assert.Nil(t, err)
+	// 2 added resources, 1 updated resource and 1 added edge.
+	AssertEqual(t, generationQueries, 4, "Expected the added and updated rows to use the current generation.")
+	assert.Contains(t, sweepResourcesQuery, "generation<$2")
+	assert.Contains(t, sweepEdgesQuery, "generation<$2")
+}
+
+// Deletes don't carry _hubClusterResource, so a sync that only deletes resources deletes the interCluster edges to
+// them without knowing if the cluster is the hub.
+func Test_SyncData_deleteInterClusterEdges(t *testing.T) {
+	setInterClusterEdges(t)
+	dao, mockPool := buildMockDAO(t)
+
+	var queries []string
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+			// pgx.Batch doesn't export the queued queries.
+			items := reflect.ValueOf(b).Elem().FieldByName("items")
+			for i := 0; i < items.Len(); i++ {
+				queries = append(queries, items.Index(i).Elem().FieldByName("query").String())
+			}
+			return &testutils.MockBatchResults{}
+		})
+
+	syncEvent := model.SyncEvent{DeleteResources: []model.DeleteResourceEvent{{UID: "local-cluster/uid-1"}}}
+	err := dao.SyncData(context.Background(), syncEvent, "local-cluster", &model.SyncResponse{})
+
+	assert.Nil(t, err)
+	assert.Contains(t, queries, "DELETE from search.edges WHERE edgetype='interCluster' AND destid=ANY($1)")
+}
+
 // --- Security: UID prefix validation ---
 
 // Test_SyncData_RejectsWrongClusterUID verifies that resources whose UIDs belong to
@@ -6,21 +6,35 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/doug-martin/goqu/v9"
 	"github.com/doug-martin/goqu/v9/exp"
 	"github.com/jackc/pgx/v4"
 	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/metrics"
 	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stolostron/search-indexer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/klog/v2"
 )
 
 func (dao *DAO) DeleteClusterAndResources(ctx context.Context, clusterName string, deleteClusterNode bool) {
+	// With the cluster delete queue, the workers delete the cluster. If it can't be queued, delete it now.
+	if config.Cfg.ClusterDeleteWorkers > 0 {
+		err := dao.queueClusterDelete(ctx, clusterName, deleteClusterNode)
+		if err == nil {
+			return
+		}
+		klog.Warningf("Error queueing the delete of cluster %s. Deleting it now. %s", clusterName, err)
+	}
 	clusterUID := string("cluster__" + clusterName)
 	if err := dao.deleteWithRetry(dao.DeleteClusterResourcesTxn, ctx, clusterName); err == nil {
 		klog.V(2).Infof("Successfully deleted resources and edges for cluster %s from database!", clusterName)
-
+		if !deleteClusterNode {
+			dao.clearPendingDeleteIfMarked(ctx, clusterName)
+		}
 	}
 
 	if deleteClusterNode {
@@ -67,7 +81,21 @@ func (dao *DAO) deleteWithRetry(deleteFunction func(context.Context, string) err
 	return nil
 }
 
-func (dao *DAO) DeleteClusterResourcesTxn(ctx context.Context, clusterName string) error {
+func (dao *DAO) DeleteClusterResourcesTxn(ctx context.Context, clusterName string) (err error) {
+	defer metrics.QueryTimer("deleteClusterResources")()
+	ctx, span := tracing.StartSpan(ctx, "DeleteClusterResourcesTxn", attribute.String("cluster", clusterName))
+	defer span.End()
+	hub := dao.isHubCluster(ctx, clusterName)
+	// Only after the delete is committed. A failed delete is retried, and the rows are still there.
+	defer func() {
+		if err == nil {
+			dao.forgetClusterData(ctx, clusterName, hub)
+		}
+	}()
+	// With list partitions, truncate the partitions of the cluster instead of deleting the rows.
+	if partitionMode == "list" {
+		return dao.truncateClusterPartitions(ctx, clusterName, true)
+	}
 	start := time.Now()
 	var rowsDeleted, resourcesDeleted, edgesDeleted int64
 
@@ -131,13 +159,26 @@ func (dao *DAO) DeleteClusterResourcesTxn(ctx context.Context, clusterName strin
 	return nil
 }
 
+// Forget the data derived from the resources of a deleted cluster. The hub is checked before the delete.
+func (dao *DAO) forgetClusterData(ctx context.Context, clusterName string, hub bool) {
+	// The catalog of the cluster is built again by the next resync.
+	dao.deletePropertyCatalog(ctx, clusterName)
+	forgetClusterUsage(clusterName)
+	interClusterEdges.clusterDeleted(clusterName, hub)
+}
+
 func (dao *DAO) DeleteClusterTxn(ctx context.Context, clusterUID string) error {
+	defer metrics.QueryTimer("deleteCluster")()
 	start := time.Now()
 	var rowsDeleted int64
 
 	defer func() {
 		klog.V(4).Infof("Delete of %s took %s. Cluster Nodes Deleted: %d", clusterUID, time.Since(start), rowsDeleted)
 	}()
+	// With list partitions, the Cluster node is the last row of the partition, so truncate the partitions.
+	if partitionMode == "list" {
+		return dao.truncateClusterPartitions(ctx, strings.TrimPrefix(clusterUID, "cluster__"), false)
+	}
 	// Delete cluster node from DB.
 
 	// Create the query
@@ -174,7 +215,10 @@ func (dao *DAO) UpsertCluster(ctx context.Context, resource model.Resource) {
 	klog.V(4).Infof("Query to insert/update cluster for %s - sql: %s args: %+v", clusterName, sql, args)
 	// Insert cluster node if cluster does not exist in the DB
 	if !dao.clusterInDB(ctx, resource.UID) || !dao.clusterPropsUpToDate(resource.UID, resource) {
+		dao.ensureClusterPartitions(ctx, clusterName)
+		queryTimer := metrics.QueryTimer("upsertCluster")
 		_, err := dao.pool.Exec(ctx, sql, args...)
+		queryTimer()
 		if err != nil {
 			// we see these when a leader's lease fails to renew or server shutdown, not necessarily indicative of a problem
 			if err == context.Canceled || err == context.DeadlineExceeded {
@@ -213,7 +257,9 @@ func (dao *DAO) clusterInDB(ctx context.Context, clusterUID string) bool {
 		}
 		klog.V(4).Infof("Query to check if the cluster node %s is in the database - sql: %s args: %+v",
 			clusterUID, sql, args)
+		queryTimer := metrics.QueryTimer("getCluster")
 		rows, err := dao.pool.Query(ctx, sql, args...)
+		queryTimer()
 		if err != nil {
 			// we see these when a leader's lease fails to renew or server shutdown, not necessarily indicative of a problem
 			if err == context.Canceled || err == context.DeadlineExceeded {
@@ -297,7 +343,7 @@ func goquInsertUpdate(tableName string, args []interface{}) (string, []interface
 		goqu.S("search").Table(tableName).As("r")).
 		Insert().
 		Rows(goqu.Record{"uid": args[0], "cluster": args[1], "data": args[2]}).
-		OnConflict(goqu.DoUpdate("uid",
+		OnConflict(goqu.DoUpdate(resourcesConflictTarget(),
 			goqu.C("data").Set(args[2])).
 			Where(goqu.L(`"r".uid`).Eq(args[0]))).ToSQL()
 
@@ -306,6 +352,7 @@ func goquInsertUpdate(tableName string, args []interface{}) (string, []interface
 
 // Query database for managed clusters:
 func (dao *DAO) GetManagedClusters(ctx context.Context) ([]string, error) {
+	defer metrics.QueryTimer("getManagedClusters")()
 
 	schemaTable := goqu.S("search").Table("resources")
 	ds := goqu.From(schemaTable)
@@ -227,7 +227,7 @@ func Test_DelClusterResources(t *testing.T) {
 		_ = mockConn.Close(ctx)
 	}(mockConn, context.Background())
 	dao, mockPool := buildMockDAO(t)
-	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."resources" WHERE (("cluster" = 'name-foo') AND ("uid" != 'cluster__name-foo'))`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE ("cluster" = 'name-foo')`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 
@@ -254,7 +254,7 @@ func Test_DelCluster(t *testing.T) {
 		_ = mockConn.Close(ctx)
 	}(mockConn, context.Background())
 	dao, mockPool := buildMockDAO(t)
-	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Return(mockConn, nil)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(mockConn, nil)
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."resources" WHERE (("cluster" = 'name-foo') AND ("uid" != 'cluster__name-foo'))`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 	mockConn.ExpectExec(regexp.QuoteMeta(`DELETE FROM "search"."edges" WHERE ("cluster" = 'name-foo')`)).WillReturnResult(pgxmock.NewResult("DELETE", 1))
 
@@ -293,7 +293,7 @@ func Test_DelClusterResourcesError(t *testing.T) {
 	retryDel = 0 //count to keep track of failures/executions
 
 	// Expect BeginTx to be called twice. First time, return error. Second time, return success.
-	mockPool.EXPECT().BeginTx(context.Background(), pgx.TxOptions{}).Times(2).
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Times(2).
 		DoAndReturn(func(con context.Context, txo pgx.TxOptions) (pgxmock.PgxConnIface, error) {
 
 			if retryDel == 0 { // First try to begin transaction
@@ -532,3 +532,16 @@ func Test_deleteWithRetry_DeleteFunctionReturnsDeadlineExceeded(t *testing.T) {
 	assert.Equal(t, err, context.DeadlineExceeded, "deleteWithRetry should return context.DeadlineExceeded and not retry")
 	assert.Equal(t, callCount, 1, "deleteFunc should only be called once, not retried on context.DeadlineExceeded")
 }
+
+// Should keep the cached data of the cluster when the delete transaction fails.
+func Test_DeleteClusterResourcesTxn_errorKeepsClusterData(t *testing.T) {
+	setQuotas(t, 100, 0, 0)
+	quotaUsage["name-foo"] = clusterUsage{resources: 5}
+	dao, mockPool := buildMockDAO(t)
+	mockPool.EXPECT().BeginTx(gomock.Any(), pgx.TxOptions{}).Return(nil, errors.New("mock error"))
+
+	err := dao.DeleteClusterResourcesTxn(context.Background(), "name-foo")
+
+	assert.NotNil(t, err)
+	assert.Contains(t, quotaUsage, "name-foo")
+}
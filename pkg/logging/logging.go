@@ -0,0 +1,74 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package logging
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"k8s.io/klog/v2"
+)
+
+// Configure the log format. With LOG_FORMAT=json, klog writes each line as a JSON object through slog, so the
+// key-value pairs added to the request logger (cluster, requestID, syncMode, operation) become JSON fields.
+// Must be called after the klog flags are parsed.
+func Setup() {
+	if config.Cfg.LogFormat != "json" {
+		return
+	}
+	// Messages are filtered by the klog verbosity. Lower the slog level to match it, because logr V(n) maps
+	// to slog level -n.
+	verbosity := 0
+	if v := flag.Lookup("v"); v != nil {
+		verbosity, _ = strconv.Atoi(v.Value.String())
+	}
+	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+		Level:       slog.Level(-verbosity),
+		ReplaceAttr: replaceLevel,
+	})
+	klog.SetSlogLogger(slog.New(handler))
+}
+
+// Log V(n) messages with the DEBUG level instead of the slog default DEBUG+<4-n>.
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.LevelKey || len(groups) > 0 {
+		return a
+	}
+	if level, ok := a.Value.Any().(slog.Level); ok && level < slog.LevelInfo {
+		return slog.String(slog.LevelKey, slog.LevelDebug.String())
+	}
+	return a
+}
+
+// Get the logger from the context with the operation that is logging. The request logger added by Middleware
+// carries the cluster, request ID and sync mode. Without it, the global klog logger is used.
+func Operation(ctx context.Context, operation string) klog.Logger {
+	return klog.FromContext(ctx).WithValues("operation", operation)
+}
+
+// Log a message with the warning level. logr doesn't have a warning level, so with LOG_FORMAT=json the message is
+// written to the slog handler of the context logger, which keeps the request values. In text mode, it's written
+// with klog and the request values added by Middleware are printed before the key-value pairs.
+func Warning(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	if config.Cfg.LogFormat == "json" {
+		slog.New(logr.ToSlogHandler(klog.FromContext(ctx))).Warn(msg, keysAndValues...)
+		return
+	}
+	values, _ := ctx.Value(requestValuesKey{}).([]interface{})
+	var b strings.Builder
+	b.WriteString(strconv.Quote(msg))
+	for _, kv := range [][]interface{}{values, keysAndValues} {
+		for i := 0; i+1 < len(kv); i += 2 {
+			fmt.Fprintf(&b, " %v=%q", kv[i], fmt.Sprint(kv[i+1]))
+		}
+	}
+	klog.WarningDepth(1, b.String())
+}
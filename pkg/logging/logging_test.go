@@ -0,0 +1,51 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/klog/v2"
+)
+
+// Should log the V(n) messages with the DEBUG level.
+func Test_replaceLevel(t *testing.T) {
+	assert.Equal(t, "DEBUG", replaceLevel(nil, slog.Any(slog.LevelKey, slog.Level(-2))).Value.String())
+	assert.Equal(t, "INFO", replaceLevel(nil, slog.Any(slog.LevelKey, slog.LevelInfo)).Value.String())
+	assert.Equal(t, "ERROR", replaceLevel(nil, slog.Any(slog.LevelKey, slog.LevelError)).Value.String())
+	assert.Equal(t, "-2", replaceLevel(nil, slog.Int("v", -2)).Value.String())
+}
+
+// Should log warnings with klog and the request values in text mode.
+func Test_Warning_text(t *testing.T) {
+	var buf bytes.Buffer
+	klog.LogToStderr(false)
+	klog.SetOutput(&buf)
+	defer klog.LogToStderr(true)
+	ctx := context.WithValue(context.Background(), requestValuesKey{}, []interface{}{"cluster", "c1"})
+
+	Warning(ctx, "Rejecting addResource", "uid", "c1/uid")
+	klog.Flush()
+
+	assert.Regexp(t, `^W.* logging_test.go:\d+\] "Rejecting addResource" cluster="c1" uid="c1/uid"`, buf.String())
+}
+
+// Should log warnings with the WARN level and the logger values in JSON mode.
+func Test_Warning_json(t *testing.T) {
+	savedFormat := config.Cfg.LogFormat
+	config.Cfg.LogFormat = "json"
+	defer func() { config.Cfg.LogFormat = savedFormat }()
+	var buf bytes.Buffer
+	klog.SetSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer klog.ClearLogger()
+	ctx := klog.NewContext(context.Background(), klog.Background().WithValues("cluster", "c1"))
+
+	Warning(ctx, "Rejecting addResource", "uid", "c1/uid")
+
+	assert.Contains(t, buf.String(), `"level":"WARN","msg":"Rejecting addResource","cluster":"c1","uid":"c1/uid"`)
+}
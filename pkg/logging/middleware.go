@@ -0,0 +1,59 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package logging
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+// Header used to correlate the logs of a request. Taken from the request if present, otherwise generated.
+// It's always returned in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// Request IDs from the collector are only used if they are safe to write to the logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+type requestValuesKey struct{}
+
+// Adds a logger to the request context with the cluster, request ID and sync mode of the request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		values := []interface{}{
+			"cluster", mux.Vars(r)["id"],
+			"requestID", requestID,
+			"syncMode", SyncMode(r)}
+
+		span := trace.SpanFromContext(r.Context())
+		if span.SpanContext().IsValid() {
+			span.SetAttributes(attribute.String("request_id", requestID))
+			values = append(values, "traceID", span.SpanContext().TraceID().String())
+		}
+
+		// The values are also kept in the context for Warning, which can't read them from the logger in text mode.
+		ctx := context.WithValue(r.Context(), requestValuesKey{}, values)
+		next.ServeHTTP(w, r.WithContext(klog.NewContext(ctx, klog.FromContext(ctx).WithValues(values...))))
+	})
+}
+
+// The sync mode of the request, from the X-Overwrite-State header.
+func SyncMode(r *http.Request) string {
+	if overwriteState, _ := strconv.ParseBool(r.Header.Get("X-Overwrite-State")); overwriteState {
+		return "resync"
+	}
+	return "sync"
+}
@@ -0,0 +1,64 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package logging
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// Serve the request with a router using the middleware. Returns the response and the log written by the handler.
+func serveTestRequest(req *http.Request) (*httptest.ResponseRecorder, string) {
+	var logOutput strings.Builder
+	testLogger := funcr.New(func(prefix, args string) { logOutput.WriteString(args) }, funcr.Options{})
+
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/aggregator/clusters/{id}/sync", func(w http.ResponseWriter, r *http.Request) {
+		Operation(r.Context(), "testOperation").Info("Test message")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req.WithContext(logr.NewContext(req.Context(), testLogger)))
+	return w, logOutput.String()
+}
+
+// Should use the request ID from the header and add the request fields to the logger.
+func Test_Middleware_RequestIDFromHeader(t *testing.T) {
+	req := httptest.NewRequest("POST", "/aggregator/clusters/cluster1/sync", nil)
+	req.Header.Set(RequestIDHeader, "collector-1234")
+	req.Header.Set("X-Overwrite-State", "true")
+
+	w, logOutput := serveTestRequest(req)
+
+	assert.Equal(t, "collector-1234", w.Header().Get(RequestIDHeader))
+	assert.Contains(t, logOutput, `"msg"="Test message"`)
+	assert.Contains(t, logOutput, `"cluster"="cluster1"`)
+	assert.Contains(t, logOutput, `"requestID"="collector-1234"`)
+	assert.Contains(t, logOutput, `"syncMode"="resync"`)
+	assert.Contains(t, logOutput, `"operation"="testOperation"`)
+}
+
+// Should generate a request ID when the header is missing or can't be used.
+func Test_Middleware_GenerateRequestID(t *testing.T) {
+	for _, header := range []string{"", "bad id\nwith newline", strings.Repeat("a", 65)} {
+		req := httptest.NewRequest("POST", "/aggregator/clusters/cluster1/sync", nil)
+		req.Header.Set(RequestIDHeader, header)
+
+		w, logOutput := serveTestRequest(req)
+
+		requestID := w.Header().Get(RequestIDHeader)
+		_, err := uuid.Parse(requestID)
+		assert.Nil(t, err, "Expected a generated UUID for header %q. Got: %s", header, requestID)
+		assert.Contains(t, logOutput, `"requestID"="`+requestID+`"`)
+		assert.Contains(t, logOutput, `"syncMode"="sync"`)
+	}
+}
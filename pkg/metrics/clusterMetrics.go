@@ -0,0 +1,159 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// Label used for the clusters over the METRICS_MAX_CLUSTERS limit.
+const OtherClustersLabel = "_other"
+
+var (
+	ClusterRequests = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_cluster_requests_total",
+		Help: "Total requests processed for each managed cluster, by type (sync or resync).",
+	}, []string{"managed_cluster_name", "type"})
+
+	ClusterResourcesChanged = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_cluster_resources_changed_total",
+		Help: "Total resources added, updated or deleted for each managed cluster.",
+	}, []string{"managed_cluster_name", "operation"})
+
+	ClusterEdgesChanged = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_cluster_edges_changed_total",
+		Help: "Total edges added or deleted for each managed cluster.",
+	}, []string{"managed_cluster_name", "operation"})
+
+	ClusterSyncErrors = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_cluster_sync_errors_total",
+		Help: "Total resources and edges that weren't indexed for each managed cluster, by operation.",
+	}, []string{"managed_cluster_name", "operation"})
+
+	ClusterLastSync = promauto.With(PromRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "search_indexer_cluster_last_sync_timestamp_seconds",
+		Help: "Unix time of the last successful request from each managed cluster.",
+	}, []string{"managed_cluster_name"})
+
+	ClusterResources = promauto.With(PromRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "search_indexer_cluster_resources",
+		Help: "Total resources in the database for each managed cluster.",
+	}, []string{"managed_cluster_name"})
+
+	ClusterEdges = promauto.With(PromRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "search_indexer_cluster_edges",
+		Help: "Total edges in the database for each managed cluster.",
+	}, []string{"managed_cluster_name"})
+)
+
+// Clusters with their own label value. Used to cap the cardinality of the per-cluster metrics.
+var labeledClusters = map[string]struct{}{}
+var labeledClustersLock = sync.Mutex{}
+
+// Get the managed_cluster_name label for the cluster. After METRICS_MAX_CLUSTERS clusters, new clusters are
+// aggregated under the _other label.
+func ClusterLabel(clusterName string) string {
+	labeledClustersLock.Lock()
+	defer labeledClustersLock.Unlock()
+	if _, ok := labeledClusters[clusterName]; ok {
+		return clusterName
+	}
+	if len(labeledClusters) >= config.Cfg.MetricsMaxClusters {
+		klog.V(5).Infof("Reached the limit of %d clusters for metrics. Using label %s for cluster %s.",
+			config.Cfg.MetricsMaxClusters, OtherClustersLabel, clusterName)
+		return OtherClustersLabel
+	}
+	labeledClusters[clusterName] = struct{}{}
+	return clusterName
+}
+
+// Record the outcome of a request from a managed cluster.
+func RecordSyncResponse(clusterName string, overwriteState bool, response *model.SyncResponse) {
+	label := ClusterLabel(clusterName)
+	requestType := "sync"
+	if overwriteState {
+		requestType = "resync"
+	}
+	ClusterRequests.WithLabelValues(label, requestType).Inc()
+
+	ClusterResourcesChanged.WithLabelValues(label, "add").Add(float64(response.TotalAdded))
+	ClusterResourcesChanged.WithLabelValues(label, "update").Add(float64(response.TotalUpdated))
+	ClusterResourcesChanged.WithLabelValues(label, "delete").Add(float64(response.TotalDeleted))
+	ClusterEdgesChanged.WithLabelValues(label, "add").Add(float64(response.TotalEdgesAdded))
+	ClusterEdgesChanged.WithLabelValues(label, "delete").Add(float64(response.TotalEdgesDeleted))
+
+	ClusterSyncErrors.WithLabelValues(label, "addResource").Add(float64(len(response.AddErrors)))
+	ClusterSyncErrors.WithLabelValues(label, "updateResource").Add(float64(len(response.UpdateErrors)))
+	ClusterSyncErrors.WithLabelValues(label, "deleteResource").Add(float64(len(response.DeleteErrors)))
+	ClusterSyncErrors.WithLabelValues(label, "addEdge").Add(float64(len(response.AddEdgeErrors)))
+	ClusterSyncErrors.WithLabelValues(label, "deleteEdge").Add(float64(len(response.DeleteEdgeErrors)))
+
+	// Totals and last sync are only meaningful for clusters with their own label.
+	if label != OtherClustersLabel {
+		ClusterLastSync.WithLabelValues(label).Set(float64(time.Now().Unix()))
+		ClusterResources.WithLabelValues(label).Set(float64(response.TotalResources))
+		ClusterEdges.WithLabelValues(label).Set(float64(response.TotalEdges))
+	}
+}
+
+// Delete the metrics of a deleted cluster and free its label for other clusters.
+func DeleteClusterMetrics(clusterName string) {
+	labeledClustersLock.Lock()
+	defer labeledClustersLock.Unlock()
+	if _, ok := labeledClusters[clusterName]; !ok {
+		return
+	}
+	delete(labeledClusters, clusterName)
+	clusterLabel := prometheus.Labels{"managed_cluster_name": clusterName}
+	RequestCount.DeletePartialMatch(clusterLabel)
+	ClusterRequests.DeletePartialMatch(clusterLabel)
+	ClusterResourcesChanged.DeletePartialMatch(clusterLabel)
+	ClusterEdgesChanged.DeletePartialMatch(clusterLabel)
+	ClusterSyncErrors.DeletePartialMatch(clusterLabel)
+	ClusterLastSync.DeletePartialMatch(clusterLabel)
+	ClusterResources.DeletePartialMatch(clusterLabel)
+	ClusterEdges.DeletePartialMatch(clusterLabel)
+}
+
+var managedClusterGvr = schema.GroupVersionResource{
+	Group:    "cluster.open-cluster-management.io",
+	Version:  "v1",
+	Resource: "managedclusters",
+}
+
+// StartClusterWatch deletes the metrics of the deleted ManagedClusters. Runs on every replica, not only the leader,
+// because each replica records the metrics of the requests it receives.
+func StartClusterWatch(ctx context.Context, dynamicClient dynamic.Interface) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient,
+		time.Duration(config.Cfg.ResyncPeriodMS)*time.Millisecond)
+	informer := factory.ForResource(managedClusterGvr).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{DeleteFunc: deleteCluster})
+	if err != nil {
+		klog.Error("Error adding the event handler for the cluster metrics. ", err)
+		return
+	}
+	factory.Start(ctx.Done())
+}
+
+// Delete the metrics of the deleted ManagedCluster.
+func deleteCluster(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if cluster, ok := obj.(*unstructured.Unstructured); ok {
+		DeleteClusterMetrics(cluster.GetName())
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Clusters over the limit are aggregated under the _other label.
+func Test_ClusterLabel_Cap(t *testing.T) {
+	maxClusters := config.Cfg.MetricsMaxClusters
+	config.Cfg.MetricsMaxClusters = 1
+	defer func() {
+		config.Cfg.MetricsMaxClusters = maxClusters
+		DeleteClusterMetrics("cluster-a")
+	}()
+
+	assert.Equal(t, "cluster-a", ClusterLabel("cluster-a"))
+	assert.Equal(t, OtherClustersLabel, ClusterLabel("cluster-b"))
+	assert.Equal(t, "cluster-a", ClusterLabel("cluster-a"))
+
+	// Deleting a cluster frees the label for other clusters.
+	DeleteClusterMetrics("cluster-a")
+	assert.Equal(t, "cluster-b", ClusterLabel("cluster-b"))
+	DeleteClusterMetrics("cluster-b")
+}
+
+func Test_RecordSyncResponse(t *testing.T) {
+	defer DeleteClusterMetrics("cluster-sync")
+	response := &model.SyncResponse{TotalAdded: 3, TotalUpdated: 2, TotalEdgesAdded: 4, TotalResources: 10,
+		TotalEdges: 7, AddErrors: []model.SyncError{{ResourceUID: "uid", Message: "error"}}}
+
+	RecordSyncResponse("cluster-sync", true, response)
+	RecordSyncResponse("cluster-sync", false, response)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(ClusterRequests.WithLabelValues("cluster-sync", "resync")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ClusterRequests.WithLabelValues("cluster-sync", "sync")))
+	assert.Equal(t, 6.0, testutil.ToFloat64(ClusterResourcesChanged.WithLabelValues("cluster-sync", "add")))
+	assert.Equal(t, 4.0, testutil.ToFloat64(ClusterResourcesChanged.WithLabelValues("cluster-sync", "update")))
+	assert.Equal(t, 8.0, testutil.ToFloat64(ClusterEdgesChanged.WithLabelValues("cluster-sync", "add")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(ClusterSyncErrors.WithLabelValues("cluster-sync", "addResource")))
+	assert.Equal(t, 10.0, testutil.ToFloat64(ClusterResources.WithLabelValues("cluster-sync")))
+	assert.Equal(t, 7.0, testutil.ToFloat64(ClusterEdges.WithLabelValues("cluster-sync")))
+	assert.Greater(t, testutil.ToFloat64(ClusterLastSync.WithLabelValues("cluster-sync")), 0.0)
+}
+
+// Should delete the metrics of a deleted ManagedCluster, also from a tombstone.
+func Test_deleteCluster(t *testing.T) {
+	cluster := &unstructured.Unstructured{}
+	cluster.SetName("deleted-cluster")
+	RecordSyncResponse("deleted-cluster", false, &model.SyncResponse{TotalAdded: 1})
+	assert.Equal(t, 1, testutil.CollectAndCount(ClusterRequests))
+
+	deleteCluster(cache.DeletedFinalStateUnknown{Key: "deleted-cluster", Obj: cluster})
+
+	assert.Equal(t, 0, testutil.CollectAndCount(ClusterRequests))
+}
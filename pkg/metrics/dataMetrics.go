@@ -0,0 +1,61 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the policies applied to the resources before they are written.
+var (
+	RedactedProperties = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_redacted_properties_total",
+		Help: "Total property values removed or hashed by the redaction policy, by action and matching rule.",
+	}, []string{"action", "rule"})
+
+	QuotaRejected = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_quota_rejected_total",
+		Help: "Total resources and edges that weren't indexed because the cluster exceeded a quota, by quota.",
+	}, []string{"quota"})
+
+	OversizedData = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_oversized_total",
+		Help: "Total resources and properties over the size limits, by limit (resource or property) and action.",
+	}, []string{"limit", "action"})
+
+	EdgeValidationFailures = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_edge_validation_failures_total",
+		Help: "Total edges referencing a resource that doesn't exist, by action (warn or reject).",
+	}, []string{"action"})
+
+	OrphanEdgesDeleted = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_orphan_edges_deleted_total",
+		Help: "Total edges deleted by the orphan edge cleanup because their source or destination doesn't exist.",
+	}, []string{"edgetype"})
+
+	InterClusterEdgeUpdates = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_intercluster_edge_updates_total",
+		Help: "Total computations of the interCluster edges, by scope (cluster, kind, or all) and result (success or error).",
+	}, []string{"scope", "result"})
+
+	ClusterDeletes = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_cluster_deletes_total",
+		Help: "Total deletes of the data of a cluster, by action (immediate, pending, canceled or purged).",
+	}, []string{"action"})
+
+	ClusterDeleteQueue = promauto.With(PromRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "search_indexer_cluster_delete_queue",
+		Help: "Clusters in the cluster delete queue, by state (pending or running).",
+	}, []string{"state"})
+
+	ClusterDeleteRows = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_cluster_delete_rows_total",
+		Help: "Total rows deleted by the cluster delete queue, by table (resources or edges).",
+	}, []string{"table"})
+
+	ClusterDeleteJobs = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_cluster_delete_jobs_total",
+		Help: "Total clusters processed by the cluster delete queue, by result (success, error, or stopped).",
+	}, []string{"result"})
+)
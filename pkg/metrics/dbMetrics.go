@@ -0,0 +1,59 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	DBBatchSize = promauto.With(PromRegistry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "search_indexer_db_batch_size",
+		Help:    "Total queries in each batch sent to the database. Doesn't include the retries.",
+		Buckets: []float64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000},
+	})
+
+	DBBatchRetries = promauto.With(PromRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "search_indexer_db_batch_retries_total",
+		Help: "Total batches split in two and sent again to isolate the query producing an error.",
+	})
+
+	DBBatchRetryDepth = promauto.With(PromRegistry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "search_indexer_db_batch_retry_depth",
+		Help:    "Number of times a batch was split to isolate a failing query.",
+		Buckets: []float64{1, 2, 4, 6, 8, 10, 12, 14},
+	})
+
+	DBBatchFailedItems = promauto.With(PromRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "search_indexer_db_batch_failed_items_total",
+		Help: "Total queries isolated as the cause of a batch error, by action.",
+	}, []string{"action"})
+
+	DBQueryDuration = promauto.With(PromRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "search_indexer_db_query_duration",
+		Help:    "Time (seconds) to execute database queries, by query kind.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"query"})
+
+	DBIndexScans = promauto.With(PromRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "search_indexer_db_index_scans",
+		Help: "Scans of each index in the search schema since the database statistics were reset.",
+	}, []string{"index"})
+
+	DBIndexSize = promauto.With(PromRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "search_indexer_db_index_size_bytes",
+		Help: "Size of each index in the search schema.",
+	}, []string{"index"})
+)
+
+// Record the time when a query starts. The returned function observes the duration for the query kind and
+// should be invoked with defer.
+func QueryTimer(queryKind string) func() {
+	start := time.Now()
+	return func() {
+		DBQueryDuration.WithLabelValues(queryKind).Observe(time.Since(start).Seconds())
+	}
+}
@@ -32,6 +32,22 @@ var (
 		Buckets: []float64{50, 100, 200, 500, 5000, 10000, 25000, 50000, 100000, 200000},
 	})
 
+	LeaderStatus = promauto.With(PromRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "search_indexer_leader",
+		Help: "Set to 1 when this search indexer pod is the leader, 0 otherwise.",
+	})
+
+	LeaderTransitions = promauto.With(PromRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "search_indexer_leader_transitions_total",
+		Help: "Total leader changes observed by this search indexer pod.",
+	})
+
+	LeaderTasksDuration = promauto.With(PromRegistry).NewHistogram(prometheus.HistogramOpts{
+		Name:    "search_indexer_leader_tasks_duration",
+		Help:    "Time (seconds) this search indexer pod ran the leader tasks before losing leadership.",
+		Buckets: []float64{30, 60, 300, 900, 1800, 3600, 6 * 3600, 24 * 3600},
+	})
+
 	// FUTURE: The summary metric could combine RequestCount and RequestDuration into a single metric.
 	// RequestSummary = promauto.With(PromRegistry).NewSummaryVec(prometheus.SummaryOpts{
 	// 	Name: "search_indexer_requests_summary",
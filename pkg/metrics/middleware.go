@@ -16,7 +16,7 @@ func PrometheusMiddleware(next http.Handler) http.Handler {
 		clusterName := params["id"]
 
 		// Add the managed_cluster_name label to metrics.
-		clusterNameLabel := prometheus.Labels{"managed_cluster_name": clusterName}
+		clusterNameLabel := prometheus.Labels{"managed_cluster_name": ClusterLabel(clusterName)}
 		curriedSyncCount, _ := RequestCount.CurryWith(clusterNameLabel)
 		// curriedRequestSummary, _ := RequestSummary.CurryWith(clusterNameLabel)
 
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,8 +21,20 @@ func Test_PrometheusInstrumentation(t *testing.T) {
 
 	// Validate the collected metrics.
 
-	collectedMetrics, _ := PromRegistry.Gather() // use the prometheus registry to confirm metrics have been scraped.
-	assert.Equal(t, 4, len(collectedMetrics))    // Validate total metrics collected.
+	gathered, _ := PromRegistry.Gather() // use the prometheus registry to confirm metrics have been scraped.
+	assert.Equal(t, 10, len(gathered))   // Validate total metrics collected.
+
+	// Select the request metrics validated below. Metrics are gathered in alphabetical order.
+	collectedMetrics := []*dto.MetricFamily{}
+	for _, name := range []string{"search_indexer_request_count", "search_indexer_request_duration",
+		"search_indexer_request_size", "search_indexer_requests_in_flight"} {
+		for _, metric := range gathered {
+			if metric.GetName() == name {
+				collectedMetrics = append(collectedMetrics, metric)
+			}
+		}
+	}
+	assert.Equal(t, 4, len(collectedMetrics))
 
 	// METRIC 1:  search_indexer_request_count
 	assert.Equal(t, "search_indexer_request_count", collectedMetrics[0].GetName())
@@ -41,6 +41,7 @@ type SyncResponse struct {
 	DeleteErrors      []SyncError
 	AddEdgeErrors     []SyncError
 	DeleteEdgeErrors  []SyncError
+	PropertyErrors    []SyncError // Properties that couldn't be normalized, or were truncated or dropped by the size limits.
 	Version           string
 }
 
@@ -3,12 +3,14 @@
 package server
 
 import (
-	"k8s.io/klog/v2"
+	"fmt"
 	"net/http"
 	"sync"
 
 	"github.com/gorilla/mux"
+	"github.com/stolostron/search-indexer/pkg/clusterhealth"
 	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/logging"
 )
 
 var largeRequestCountTracker int
@@ -25,9 +27,13 @@ func largeRequestLimiterMiddleware(next http.Handler) http.Handler {
 			largeRequestCountTrackerLock.RUnlock()
 
 			if largeRequestCount >= config.Cfg.LargeRequestLimit {
-				klog.Warningf("Rejecting large request from %s because there's too many large requests processing. Request size: %dMB",
-					clusterName, r.ContentLength/1024/1024)
+				logging.Warning(r.Context(),
+					"Rejecting large request because there's too many large requests processing",
+					"operation", "largeRequestLimiter", "requestSizeMB", r.ContentLength/1024/1024)
 				http.Error(w, "Too many large requests currently processing, retry later.", http.StatusTooManyRequests)
+				clusterhealth.ReportProblem(clusterName, rejectedReason(r), fmt.Sprintf(
+					"Large request (%dMB) rejected because too many large requests are processing.",
+					r.ContentLength/1024/1024))
 				return
 			}
 
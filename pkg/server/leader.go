@@ -0,0 +1,21 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/stolostron/search-indexer/pkg/clustersync"
+	"k8s.io/klog/v2"
+)
+
+// LeaderHandler reports the leader election status of this pod. Used to debug failover.
+func LeaderHandler(w http.ResponseWriter, r *http.Request) {
+	klog.V(7).Info("leader")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(clustersync.GetLeaderStatus()); err != nil {
+		klog.Error("Error encoding leader status. ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
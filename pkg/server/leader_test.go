@@ -0,0 +1,39 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stolostron/search-indexer/pkg/clustersync"
+	"github.com/stolostron/search-indexer/pkg/config"
+)
+
+// Should return the leader status as JSON.
+func TestLeaderHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/leader", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(LeaderHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var status clustersync.LeaderStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("Error decoding leader status. %s", err)
+	}
+	if status.Identity != config.Cfg.PodName {
+		t.Errorf("Expected identity %s, got %s", config.Cfg.PodName, status.Identity)
+	}
+	if status.IsLeader {
+		t.Error("Expected pod to not be the leader.")
+	}
+}
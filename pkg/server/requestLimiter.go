@@ -3,14 +3,15 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
-	klog "k8s.io/klog/v2"
-
 	"github.com/gorilla/mux"
+	"github.com/stolostron/search-indexer/pkg/clusterhealth"
 	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/logging"
 )
 
 var requestTracker = map[string]time.Time{}
@@ -25,14 +26,17 @@ func requestLimiterMiddleware(next http.Handler) http.Handler {
 
 		requestTrackerLock.RLock()
 		requestCount := len(requestTracker)
-		klog.V(6).Info("Checking if we can process incoming request. Current requests: ", requestCount)
+		logger := logging.Operation(r.Context(), "requestLimiter")
+		logger.V(6).Info("Checking if we can process incoming request", "currentRequests", requestCount)
 		timeReqReceived, foundClusterProcessing := requestTracker[clusterName]
 		requestTrackerLock.RUnlock()
 
 		if foundClusterProcessing {
-			klog.Warningf("Rejecting request from %s because there's a previous request processing. Duration: %s",
-				clusterName, time.Since(timeReqReceived))
+			logging.Warning(r.Context(), "Rejecting request because there's a previous request processing",
+				"operation", "requestLimiter", "previousRequestDuration", time.Since(timeReqReceived))
 			http.Error(w, "A previous request from this cluster is processing, retry later.", http.StatusTooManyRequests)
+			clusterhealth.ReportProblem(clusterName, rejectedReason(r),
+				"Request rejected because a previous request from this cluster is processing.")
 			return
 		}
 
@@ -41,8 +45,11 @@ func requestLimiterMiddleware(next http.Handler) http.Handler {
 		//   request host uses internal IP when coming from proxy service, i.e. managed clusters
 		hubClusterReq := r.Host == "search-indexer.open-cluster-management.svc:3010"
 		if requestCount >= config.Cfg.RequestLimit && !hubClusterReq {
-			klog.Warningf("Too many pending requests (%d). Rejecting sync from %s", requestCount, clusterName)
+			logging.Warning(r.Context(), "Too many pending requests. Rejecting request", "operation", "requestLimiter",
+				"pendingRequests", requestCount)
 			http.Error(w, "Indexer has too many pending requests, retry later.", http.StatusTooManyRequests)
+			clusterhealth.ReportProblem(clusterName, rejectedReason(r),
+				fmt.Sprintf("Request rejected because the indexer has too many pending requests (%d).", requestCount))
 			return
 		}
 
@@ -59,3 +66,11 @@ func requestLimiterMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Reason reported to the cluster when a request is rejected by the limiters.
+func rejectedReason(r *http.Request) string {
+	if logging.SyncMode(r) == "resync" {
+		return clusterhealth.ReasonResyncRejected
+	}
+	return clusterhealth.ReasonSyncRejected
+}
@@ -12,7 +12,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stolostron/search-indexer/pkg/config"
 	"github.com/stolostron/search-indexer/pkg/database"
+	"github.com/stolostron/search-indexer/pkg/logging"
 	"github.com/stolostron/search-indexer/pkg/metrics"
+	"github.com/stolostron/search-indexer/pkg/tracing"
 	"k8s.io/klog/v2"
 )
 
@@ -25,10 +27,16 @@ func (s *ServerConfig) StartAndListen(ctx context.Context) {
 	router := mux.NewRouter()
 	router.HandleFunc("/liveness", LivenessProbe).Methods("GET")
 	router.HandleFunc("/readiness", ReadinessProbe).Methods("GET")
+	router.HandleFunc("/leader", LeaderHandler).Methods("GET")
+	if config.Cfg.SlowQueryExplainMS > 0 {
+		router.HandleFunc("/admin/slow-queries", SlowQueriesHandler).Methods("GET")
+	}
 	router.Handle("/metrics", promhttp.HandlerFor(metrics.PromRegistry, promhttp.HandlerOpts{})).Methods("GET")
 
 	// Add middleware to the /aggregator subroute.
 	syncSubrouter := router.PathPrefix("/aggregator").Subrouter()
+	syncSubrouter.Use(tracing.Middleware)
+	syncSubrouter.Use(logging.Middleware)
 	syncSubrouter.Use(metrics.PrometheusMiddleware)
 	syncSubrouter.Use(requestLimiterMiddleware)
 	syncSubrouter.Use(largeRequestLimiterMiddleware)
@@ -0,0 +1,22 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/stolostron/search-indexer/pkg/database"
+	"k8s.io/klog/v2"
+)
+
+// SlowQueriesHandler returns the EXPLAIN plans captured for slow batches, newest first.
+// Only registered when SLOW_QUERY_EXPLAIN_MS is set.
+func SlowQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	klog.V(7).Info("slow-queries")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(database.SlowQueryPlans()); err != nil {
+		klog.Error("Error encoding slow query plans. ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
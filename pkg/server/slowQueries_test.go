@@ -0,0 +1,35 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stolostron/search-indexer/pkg/database"
+)
+
+// Should return the captured query plans as a JSON list.
+func TestSlowQueriesHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/admin/slow-queries", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(SlowQueriesHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var plans []database.QueryPlan
+	if err := json.NewDecoder(rr.Body).Decode(&plans); err != nil {
+		t.Fatalf("Error decoding query plans. %s", err)
+	}
+	if len(plans) != 0 {
+		t.Errorf("Expected no query plans, got %d", len(plans))
+	}
+}
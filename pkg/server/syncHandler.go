@@ -5,6 +5,7 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
@@ -13,9 +14,12 @@ import (
 	"github.com/stolostron/search-indexer/pkg/metrics"
 
 	"github.com/gorilla/mux"
+	"github.com/stolostron/search-indexer/pkg/clusterhealth"
 	"github.com/stolostron/search-indexer/pkg/config"
+	"github.com/stolostron/search-indexer/pkg/logging"
 	"github.com/stolostron/search-indexer/pkg/model"
-	"k8s.io/klog/v2"
+	"github.com/stolostron/search-indexer/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func (s *ServerConfig) SyncResources(w http.ResponseWriter, r *http.Request) {
@@ -23,25 +27,30 @@ func (s *ServerConfig) SyncResources(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	params := mux.Vars(r)
 	clusterName := params["id"]
+	logger := logging.Operation(r.Context(), "syncResources")
 
 	var syncEvent model.SyncEvent
+	_, readSpan := tracing.StartSpan(r.Context(), "readRequest")
 	bodyBytes, err := io.ReadAll(r.Body)
+	readSpan.SetAttributes(attribute.Int("http.request.body.size", len(bodyBytes)))
+	tracing.RecordError(readSpan, err)
+	readSpan.End()
 	if err != nil {
-		klog.Errorf("Error reading request body from cluster [%s]. Error: %+v\n", clusterName, err)
+		logger.Error(err, "Error reading request body")
 		w.WriteHeader(http.StatusBadRequest)
+		clusterhealth.ReportProblem(clusterName, clusterhealth.ReasonInvalidRequest,
+			fmt.Sprintf("Error reading the request body. %s", err))
 		return
 	}
 
 	overwriteStateHeader := r.Header.Get("X-Overwrite-State")
 	overwriteState, overwriteStateErr := strconv.ParseBool(overwriteStateHeader)
 	if overwriteStateErr != nil {
-		klog.V(1).Infof("Invalid X-Overwrite-State header value [%s] from cluster[%s]: %v", overwriteStateHeader, clusterName, overwriteStateErr)
+		logger.V(1).Info("Invalid X-Overwrite-State header value", "value", overwriteStateHeader,
+			"err", overwriteStateErr)
 		overwriteState = false
 	}
 
-	resourceTotal := len(syncEvent.AddResources) + len(syncEvent.UpdateResources) + len(syncEvent.DeleteResources)
-	metrics.RequestSize.Observe(float64(resourceTotal))
-
 	// Initialize SyncResponse object.
 	syncResponse := &model.SyncResponse{
 		Version:          config.COMPONENT_VERSION,
@@ -50,6 +59,7 @@ func (s *ServerConfig) SyncResources(w http.ResponseWriter, r *http.Request) {
 		DeleteErrors:     make([]model.SyncError, 0),
 		AddEdgeErrors:    make([]model.SyncError, 0),
 		DeleteEdgeErrors: make([]model.SyncError, 0),
+		PropertyErrors:   make([]model.SyncError, 0),
 	}
 
 	// The collector sends 2 types of requests with the header:
@@ -59,42 +69,98 @@ func (s *ServerConfig) SyncResources(w http.ResponseWriter, r *http.Request) {
 		err = s.Dao.ResyncData(r.Context(), clusterName, syncResponse, bodyBytes)
 	} else {
 		// we can decode the entire request for non resync requests because they are significantly smaller
+		_, decodeSpan := tracing.StartSpan(r.Context(), "decodeRequest")
 		err = json.NewDecoder(bytes.NewReader(bodyBytes)).Decode(&syncEvent)
+		tracing.RecordError(decodeSpan, err)
+		decodeSpan.End()
 		if err != nil {
-			klog.Errorf("Error decoding request body from cluster [%s]. Error: %+v\n", clusterName, err)
+			logger.Error(err, "Error decoding request body")
 			w.WriteHeader(http.StatusBadRequest)
-		} else {
-			err = s.Dao.SyncData(r.Context(), syncEvent, clusterName, syncResponse)
+			clusterhealth.ReportProblem(clusterName, clusterhealth.ReasonInvalidRequest,
+				fmt.Sprintf("Error decoding the request body. %s", err))
+			return
 		}
+		err = s.Dao.SyncData(r.Context(), syncEvent, clusterName, syncResponse)
 	}
 	if err != nil {
-		klog.Warningf("Responding with error to request from %12s. Error: %s",
-			clusterName, err)
+		logger.Error(err, "Responding with error to request")
 		http.Error(w, "Server error while processing the request.", http.StatusInternalServerError)
+		clusterhealth.ReportProblem(clusterName, clusterhealth.ReasonDatabaseError,
+			fmt.Sprintf("Error processing the request. %s", err))
 		return
 	}
 
 	// Get the total cluster resources for validation by the collector.
 	totalResources, totalEdges, validateErr := s.Dao.ClusterTotals(r.Context(), clusterName)
 	if validateErr != nil {
-		klog.Warningf("Responding with error to request from %12s. Error: %s",
-			clusterName, validateErr)
+		logger.Error(validateErr, "Responding with error to request")
 		http.Error(w, "Server error while processing the request.", http.StatusInternalServerError)
+		clusterhealth.ReportProblem(clusterName, clusterhealth.ReasonDatabaseError,
+			fmt.Sprintf("Error getting the cluster totals. %s", validateErr))
 		return
 	}
 	syncResponse.TotalResources = totalResources
 	syncResponse.TotalEdges = totalEdges
 
+	reportSyncResponse(clusterName, syncResponse)
+
+	resourceTotal := len(syncEvent.AddResources) + len(syncEvent.UpdateResources) + len(syncEvent.DeleteResources)
+	if overwriteState {
+		resourceTotal = resyncRequestSize(bodyBytes)
+	}
+	metrics.RequestSize.Observe(float64(resourceTotal))
+	metrics.RecordSyncResponse(clusterName, overwriteState, syncResponse)
+
 	// Send Response
 	w.WriteHeader(http.StatusOK)
 	encodeError := json.NewEncoder(w).Encode(syncResponse)
 	if encodeError != nil {
-		klog.Error("Error responding to SyncEvent:", encodeError, syncResponse)
+		logger.Error(encodeError, "Error responding to SyncEvent", "response", syncResponse)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 
 	// Log request.
-	klog.V(5).Infof("Request from [%12s] took [%v] overwriteState [%t] addTotal [%d]",
-		clusterName, time.Since(start), overwriteState, len(syncEvent.AddResources))
-	// klog.V(5).Infof("Response for [%s]: %+v", clusterName, syncResponse)
+	logger.V(5).Info("Request processed", "duration", time.Since(start),
+		"addTotal", len(syncEvent.AddResources))
+}
+
+// Report the resources and edges that weren't indexed, or report the cluster as indexed.
+func reportSyncResponse(clusterName string, syncResponse *model.SyncResponse) {
+	errorCount := len(syncResponse.AddErrors) + len(syncResponse.UpdateErrors) + len(syncResponse.DeleteErrors) +
+		len(syncResponse.AddEdgeErrors) + len(syncResponse.DeleteEdgeErrors)
+	if errorCount == 0 {
+		clusterhealth.ReportIndexed(clusterName)
+		return
+	}
+	clusterhealth.ReportProblem(clusterName, clusterhealth.ReasonResourcesRejected, fmt.Sprintf(
+		"%d changes weren't indexed. addErrors: %d updateErrors: %d deleteErrors: %d addEdgeErrors: %d deleteEdgeErrors: %d",
+		errorCount, len(syncResponse.AddErrors), len(syncResponse.UpdateErrors), len(syncResponse.DeleteErrors),
+		len(syncResponse.AddEdgeErrors), len(syncResponse.DeleteEdgeErrors)))
+}
+
+// Count the resources in the resync request body, which isn't decoded into the syncEvent. The resources are read one
+// at a time into the same buffer.
+func resyncRequestSize(body []byte) int {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for {
+		field, err := dec.Token()
+		if err != nil {
+			return 0
+		}
+		if field != "addResources" {
+			continue
+		}
+		if _, err = dec.Token(); err != nil {
+			return 0
+		}
+		count := 0
+		var resource json.RawMessage
+		for dec.More() {
+			if err = dec.Decode(&resource); err != nil {
+				return count
+			}
+			count++
+		}
+		return count
+	}
 }
@@ -13,10 +13,14 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
+	"github.com/jackc/pgconn"
 	"github.com/stolostron/search-indexer/pkg/config"
 	"github.com/stolostron/search-indexer/pkg/model"
 	"github.com/stolostron/search-indexer/pkg/testutils"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_syncRequest(t *testing.T) {
@@ -139,6 +143,9 @@ func Test_resyncRequest(t *testing.T) {
 	// Create server with mock database.
 	server, mockPool := buildMockServer(t)
 	testutils.MockDatabaseState(mockPool) // Mock Postgres state and SELECT queries.
+	testutils.MockResyncGeneration(mockPool, 2)
+	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), gomock.Any()).Return(pgconn.CommandTag("DELETE 0"), nil)
+	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), gomock.Any()).Return(pgconn.CommandTag("DELETE 1"), nil)
 
 	br := &testutils.MockBatchResults{
 		MockRows: testutils.MockRows{
@@ -167,6 +174,21 @@ func Test_resyncRequest(t *testing.T) {
 	}
 }
 
+// Should count the resources in the resync request body.
+func Test_resyncRequestSize(t *testing.T) {
+	body, readErr := os.ReadFile("./mocks/clearAll.json")
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	if size := resyncRequestSize(body); size != 2 {
+		t.Errorf("Want request size 2, got %d", size)
+	}
+	if size := resyncRequestSize([]byte("{")); size != 0 {
+		t.Errorf("Want request size 0 for an invalid body, got %d", size)
+	}
+}
+
 func Test_resyncRequest_withErrorDeletingResources(t *testing.T) {
 	// Read mock request body.
 	body, readErr := os.Open("./mocks/clearAll.json")
@@ -182,16 +204,12 @@ func Test_resyncRequest_withErrorDeletingResources(t *testing.T) {
 	// Create server with mock database.
 	server, mockPool := buildMockServer(t)
 	testutils.MockDatabaseState(mockPool) // Mock Postgres state and SELECT queries.
+	testutils.MockResyncGeneration(mockPool, 2)
 
 	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("unexpected EOF"))
 
-	br := &testutils.MockBatchResults{
-		MockRows: testutils.MockRows{
-			MockData: []map[string]interface{}{{"count": 10}},
-		},
-		MockErrorOnClose: errors.New("unexpected EOF"),
-	}
-	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(3)
+	br := &testutils.MockBatchResults{}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(1)
 
 	router.HandleFunc("/aggregator/clusters/{id}/sync", server.SyncResources)
 	router.ServeHTTP(responseRecorder, request)
@@ -217,16 +235,12 @@ func Test_resyncRequest_withErrorDeletingEdges(t *testing.T) {
 	// Create server with mock database.
 	server, mockPool := buildMockServer(t)
 	testutils.MockDatabaseState(mockPool) // Mock Postgres state and SELECT queries.
-	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), gomock.Any())
+	testutils.MockResyncGeneration(mockPool, 2)
+	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), gomock.Any()).Return(pgconn.CommandTag("DELETE 0"), nil)
 	mockPool.EXPECT().Exec(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("unexpected EOF"))
 
-	br := &testutils.MockBatchResults{
-		MockRows: testutils.MockRows{
-			MockData: []map[string]interface{}{{"count": 10}},
-		},
-		MockErrorOnClose: errors.New("unexpected EOF"),
-	}
-	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(3)
+	br := &testutils.MockBatchResults{}
+	mockPool.EXPECT().SendBatch(gomock.Any(), gomock.Any()).Return(br).Times(2)
 
 	router.HandleFunc("/aggregator/clusters/{id}/sync", server.SyncResources)
 	router.ServeHTTP(responseRecorder, request)
@@ -254,3 +268,24 @@ func Test_incorrectRequestBody(t *testing.T) {
 		t.Errorf("Want status '%d', got '%d'", http.StatusBadRequest, responseRecorder.Code)
 	}
 }
+
+// Should trace reading the request body and decoding the sync event as separate spans.
+func Test_syncRequest_spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previousProvider)
+
+	body := strings.NewReader("This is an incorrect request body.")
+	responseRecorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/aggregator/clusters/local-cluster/sync", body)
+	router := mux.NewRouter()
+	server, _ := buildMockServer(t)
+	router.HandleFunc("/aggregator/clusters/{id}/sync", server.SyncResources)
+	router.ServeHTTP(responseRecorder, request)
+
+	spans := recorder.Ended()
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "readRequest", spans[0].Name())
+	assert.Equal(t, "decodeRequest", spans[1].Name())
+}
@@ -27,3 +27,9 @@ func MockDatabaseState(mockPool *pgxpoolmock.MockPgxPool) {
 		`SELECT DISTINCT "cluster" FROM "search"."resources" WHERE ("data"?'_hubClusterResource' AND "data"->>'kind' <> 'Cluster')`),
 		[]interface{}{}).Return(clusterRows, nil)
 }
+
+// MockResyncGeneration mocks the query that increments the resync generation of the cluster.
+func MockResyncGeneration(mockPool *pgxpoolmock.MockPgxPool, generation int64) {
+	mockPool.EXPECT().Query(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(pgxpoolmock.NewRows([]string{"generation"}).AddRow(generation).ToPgxRows(), nil)
+}
@@ -0,0 +1,73 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tracing
+
+import (
+	"context"
+
+	"github.com/stolostron/search-indexer/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+)
+
+const tracerName = "github.com/stolostron/search-indexer"
+
+// Start tracing. Spans are exported with OTLP over HTTP when OTEL_EXPORTER_OTLP_ENDPOINT is set. Otherwise, the
+// default no-op tracer is used, so tracing works offline. The W3C trace context is always propagated.
+// Returns a function to flush and stop the exporter.
+func Start(ctx context.Context) func(context.Context) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	if config.Cfg.OTLPEndpoint == "" {
+		klog.V(1).Info("OTEL_EXPORTER_OTLP_ENDPOINT isn't set. Tracing is disabled.")
+		return func(context.Context) {}
+	}
+
+	// The exporter reads the endpoint and other OTEL_EXPORTER_OTLP_* settings from the environment.
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		klog.Error("Error creating the OTLP trace exporter. Tracing is disabled. ", err)
+		return func(context.Context) {}
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("search-indexer"),
+		semconv.ServiceVersion(config.Cfg.Version),
+		semconv.ServiceInstanceID(config.Cfg.PodName)))
+	if err != nil {
+		klog.Warning("Error creating the trace resource. ", err)
+		res = resource.Default()
+	}
+	// The sampler can be configured with OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG.
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	klog.Infof("Exporting traces to %s", config.Cfg.OTLPEndpoint)
+
+	return func(ctx context.Context) {
+		if err := provider.Shutdown(ctx); err != nil {
+			klog.Warning("Error stopping the trace exporter. ", err)
+		}
+	}
+}
+
+// Start a span using the global tracer provider.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Record the error in the span and set the span status to error. Does nothing if err is nil.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}